@@ -0,0 +1,338 @@
+// Package alerts keeps the service alerts operators publish in the
+// GTFS-RT Alerts feed: detours, suspended routes, closed stops.
+//
+// Design decisions:
+//
+//	Alerts are few and edited by hand, so they live in memory behind a
+//	RWMutex and are written back to a JSON file after every change.
+//	Cause and effect are stored as their GTFS-RT enum names, so the file
+//	and the admin API read the same as the specification.
+//	Expiring an alert ends its active periods now but keeps the record,
+//	so operators can see what was announced; deleting it forgets it.
+package alerts
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jaggu/vehicle-tracker-prototype/clock"
+	"github.com/jaggu/vehicle-tracker-prototype/jsonfile"
+	pb "github.com/jaggu/vehicle-tracker-prototype/proto/gtfsrt"
+)
+
+// Errors returned by Manager.
+var (
+	ErrNotFound = errors.New("alert not found")
+	ErrExists   = errors.New("alert already exists")
+	ErrInvalid  = errors.New("invalid alert")
+)
+
+// Alert is a service alert.
+type Alert struct {
+	ID string `json:"id"`
+
+	// ActivePeriods are when the alert is in effect.  Without any it is
+	// in effect until expired.
+	ActivePeriods []Period `json:"active_periods"`
+
+	// InformedEntities are the agencies, routes, stops and trips the
+	// alert is about.
+	InformedEntities []Entity `json:"informed_entities"`
+
+	// Cause and Effect are GTFS-RT Alert.Cause and Alert.Effect names,
+	// such as "CONSTRUCTION" and "DETOUR".  Empty means unknown.
+	Cause  string `json:"cause,omitempty"`
+	Effect string `json:"effect,omitempty"`
+
+	Header      Text `json:"header"`
+	Description Text `json:"description,omitempty"`
+	URL         Text `json:"url,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Period is a time range.  A missing Start means since forever and a
+// missing End means until further notice.
+type Period struct {
+	Start *time.Time `json:"start,omitempty"`
+	End   *time.Time `json:"end,omitempty"`
+}
+
+// Entity selects what an alert applies to.  Fields set together narrow
+// the selection, so {route_id, stop_id} is the route at that stop.
+type Entity struct {
+	AgencyID string `json:"agency_id,omitempty"`
+	RouteID  string `json:"route_id,omitempty"`
+	StopID   string `json:"stop_id,omitempty"`
+	TripID   string `json:"trip_id,omitempty"`
+}
+
+// Text is a translated string, by BCP-47 language code.  The empty code
+// is text in the feed's default language.
+type Text map[string]string
+
+// Expired reports whether every active period of a has ended by now.
+func (a Alert) Expired(now time.Time) bool {
+	if len(a.ActivePeriods) == 0 {
+		return false
+	}
+	for _, p := range a.ActivePeriods {
+		if p.End == nil || p.End.After(now) {
+			return false
+		}
+	}
+	return true
+}
+
+// validate checks the fields every stored alert must satisfy.
+func (a Alert) validate() error {
+	if a.ID == "" {
+		return fmt.Errorf("%w: id is required", ErrInvalid)
+	}
+	if len(a.InformedEntities) == 0 {
+		return fmt.Errorf("%w: informed_entities must name at least one agency, route, stop or trip", ErrInvalid)
+	}
+	for i, e := range a.InformedEntities {
+		if e == (Entity{}) {
+			return fmt.Errorf("%w: informed_entities[%d] is empty", ErrInvalid, i)
+		}
+	}
+	if _, ok := pb.Alert_Cause_value[a.Cause]; a.Cause != "" && !ok {
+		return fmt.Errorf("%w: unknown cause %q", ErrInvalid, a.Cause)
+	}
+	if _, ok := pb.Alert_Effect_value[a.Effect]; a.Effect != "" && !ok {
+		return fmt.Errorf("%w: unknown effect %q", ErrInvalid, a.Effect)
+	}
+	if !a.Header.present() {
+		return fmt.Errorf("%w: header is required", ErrInvalid)
+	}
+	for i, p := range a.ActivePeriods {
+		if p.Start != nil && p.End != nil && !p.End.After(*p.Start) {
+			return fmt.Errorf("%w: active_periods[%d] ends before it starts", ErrInvalid, i)
+		}
+	}
+	return nil
+}
+
+// present reports whether t has any non-empty translation.
+func (t Text) present() bool {
+	for _, s := range t {
+		if s != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Manager is the set of alerts.  It is safe for concurrent use.
+type Manager struct {
+	path  string
+	clock clock.Clock
+
+	mu     sync.RWMutex
+	alerts map[string]Alert
+}
+
+// Open returns a Manager stored in the JSON file at path, loading the
+// alerts already there.  A missing file means no alerts; an empty path
+// keeps them in memory only.
+func Open(path string, clk clock.Clock) (*Manager, error) {
+	m := &Manager{path: path, clock: clk, alerts: make(map[string]Alert)}
+	if path == "" {
+		return m, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []Alert
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for _, a := range list {
+		if err := a.validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		m.alerts[a.ID] = a
+	}
+	return m, nil
+}
+
+// List returns every alert, including expired ones, oldest first.
+func (m *Manager) List() []Alert {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.listLocked()
+}
+
+func (m *Manager) listLocked() []Alert {
+	list := make([]Alert, 0, len(m.alerts))
+	for _, a := range m.alerts {
+		list = append(list, a)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].CreatedAt.Equal(list[j].CreatedAt) {
+			return list[i].CreatedAt.Before(list[j].CreatedAt)
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// Current returns the alerts that have not expired, oldest first.
+// Alerts whose periods are all in the future are included, so riders
+// can be told about planned disruptions ahead of time.
+func (m *Manager) Current() []Alert {
+	now := m.clock.Now()
+	list := m.List()
+	kept := list[:0]
+	for _, a := range list {
+		if !a.Expired(now) {
+			kept = append(kept, a)
+		}
+	}
+	return kept
+}
+
+// Get returns the alert with the given ID.
+func (m *Manager) Get(id string) (Alert, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	a, ok := m.alerts[id]
+	return a, ok
+}
+
+// Create adds a new alert, generating an ID if it has none.  It returns
+// the stored alert.
+func (m *Manager) Create(a Alert) (Alert, error) {
+	if a.ID == "" {
+		b := make([]byte, 8)
+		if _, err := rand.Read(b); err != nil {
+			return Alert{}, fmt.Errorf("alerts: %w", err)
+		}
+		a.ID = hex.EncodeToString(b)
+	}
+	now := m.clock.Now().UTC()
+	a.CreatedAt, a.UpdatedAt = now, now
+	if err := a.validate(); err != nil {
+		return Alert{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.alerts[a.ID]; ok {
+		return Alert{}, ErrExists
+	}
+	if err := m.commitLocked(a.ID, &a); err != nil {
+		return Alert{}, err
+	}
+	return a, nil
+}
+
+// Update replaces an existing alert, keeping its creation time.  It
+// returns the stored alert.
+func (m *Manager) Update(a Alert) (Alert, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	old, ok := m.alerts[a.ID]
+	if !ok {
+		return Alert{}, ErrNotFound
+	}
+	a.CreatedAt, a.UpdatedAt = old.CreatedAt, m.clock.Now().UTC()
+	if err := a.validate(); err != nil {
+		return Alert{}, err
+	}
+	if err := m.commitLocked(a.ID, &a); err != nil {
+		return Alert{}, err
+	}
+	return a, nil
+}
+
+// Expire takes an alert out of effect now: periods still running end
+// now and periods yet to start are dropped.  Expiring an expired alert
+// is a no-op.  It returns the stored alert.
+func (m *Manager) Expire(id string) (Alert, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a, ok := m.alerts[id]
+	if !ok {
+		return Alert{}, ErrNotFound
+	}
+	now := m.clock.Now().UTC()
+	if a.Expired(now) {
+		return a, nil
+	}
+
+	periods := make([]Period, 0, len(a.ActivePeriods)+1)
+	for _, p := range a.ActivePeriods {
+		switch {
+		case p.Start != nil && !p.Start.Before(now):
+			continue // not started yet
+		case p.End == nil || p.End.After(now):
+			p.End = &now
+		}
+		periods = append(periods, p)
+	}
+	if len(periods) == 0 {
+		periods = append(periods, Period{End: &now})
+	}
+	a.ActivePeriods, a.UpdatedAt = periods, now
+	if err := m.commitLocked(id, &a); err != nil {
+		return Alert{}, err
+	}
+	return a, nil
+}
+
+// Delete removes an alert.
+func (m *Manager) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.alerts[id]; !ok {
+		return ErrNotFound
+	}
+	return m.commitLocked(id, nil)
+}
+
+// commitLocked sets (or, with a nil a, deletes) an alert and saves the
+// file, undoing the change if saving fails.  m.mu must be held.
+func (m *Manager) commitLocked(id string, a *Alert) error {
+	old, existed := m.alerts[id]
+	if a != nil {
+		m.alerts[id] = *a
+	} else {
+		delete(m.alerts, id)
+	}
+
+	if err := m.saveLocked(); err != nil {
+		if existed {
+			m.alerts[id] = old
+		} else {
+			delete(m.alerts, id)
+		}
+		return err
+	}
+	return nil
+}
+
+// saveLocked rewrites the alerts file atomically.  m.mu must be held.
+func (m *Manager) saveLocked() error {
+	if m.path == "" {
+		return nil
+	}
+	if err := jsonfile.Write(m.path, m.listLocked()); err != nil {
+		return fmt.Errorf("alerts: %w", err)
+	}
+	return nil
+}
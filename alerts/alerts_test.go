@@ -0,0 +1,77 @@
+package alerts_test
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jaggu/vehicle-tracker-prototype/alerts"
+	"github.com/jaggu/vehicle-tracker-prototype/clock"
+)
+
+func TestManager_CreateUpdateExpire(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alerts.json")
+	clk := clock.NewFake(time.Unix(1752566400, 0))
+	m, err := alerts.Open(path, clk)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	if _, err := m.Create(alerts.Alert{Header: alerts.Text{"en": "Detour"}}); !errors.Is(err, alerts.ErrInvalid) {
+		t.Errorf("alert without entities: err = %v, want ErrInvalid", err)
+	}
+	if _, err := m.Create(alerts.Alert{
+		InformedEntities: []alerts.Entity{{RouteID: "5"}},
+		Effect:           "DETOURED",
+		Header:           alerts.Text{"en": "Detour"},
+	}); !errors.Is(err, alerts.ErrInvalid) {
+		t.Errorf("unknown effect: err = %v, want ErrInvalid", err)
+	}
+
+	later := clk.Now().Add(2 * time.Hour)
+	a, err := m.Create(alerts.Alert{
+		InformedEntities: []alerts.Entity{{RouteID: "5"}, {StopID: "S2"}},
+		Cause:            "CONSTRUCTION",
+		Effect:           "DETOUR",
+		Header:           alerts.Text{"en": "Route 5 detour", "sw": "Njia 5 imegeuzwa"},
+		ActivePeriods:    []alerts.Period{{}, {Start: &later}},
+	})
+	if err != nil || a.ID == "" {
+		t.Fatalf("Create = %+v, %v", a, err)
+	}
+
+	// Alerts survive a restart.
+	if m, err = alerts.Open(path, clk); err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	clk.Advance(time.Minute)
+	a.Description = alerts.Text{"en": "Use Kenyatta Avenue"}
+	updated, err := m.Update(a)
+	if err != nil || !updated.CreatedAt.Equal(a.CreatedAt) || !updated.UpdatedAt.After(a.CreatedAt) {
+		t.Fatalf("Update = %+v, %v", updated, err)
+	}
+	if got := m.Current(); len(got) != 1 || got[0].Description["en"] != "Use Kenyatta Avenue" {
+		t.Errorf("Current = %+v, want the updated alert", got)
+	}
+
+	// Expiring ends the running period and drops the one to come.
+	expired, err := m.Expire(a.ID)
+	if err != nil || len(expired.ActivePeriods) != 1 || !expired.ActivePeriods[0].End.Equal(clk.Now()) {
+		t.Fatalf("Expire = %+v, %v", expired, err)
+	}
+	clk.Advance(time.Second)
+	if got := m.Current(); len(got) != 0 {
+		t.Errorf("Current after expiry = %+v, want none", got)
+	}
+	if got := m.List(); len(got) != 1 {
+		t.Errorf("List after expiry has %d alerts, want the expired one kept", len(got))
+	}
+
+	if err := m.Delete(a.ID); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := m.Expire(a.ID); !errors.Is(err, alerts.ErrNotFound) {
+		t.Errorf("Expire after delete: err = %v, want ErrNotFound", err)
+	}
+}
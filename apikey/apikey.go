@@ -0,0 +1,267 @@
+// Package apikey manages the API keys that GTFS-RT feed consumers
+// (OneBusAway, trip planners) present when fetching the feed.
+//
+// Design decisions:
+//
+//	Only the SHA-256 hash of a key is stored; the key itself is shown
+//	once, when it is created.  Keys are long random strings, so a fast
+//	hash is enough and lets every feed request be checked with a map
+//	lookup.
+//	Revoked keys stay listed, with their revocation time, so operators
+//	can see which consumer was cut off.
+//	Request counters and rate-limit state are kept in memory only and
+//	reset on restart.
+package apikey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jaggu/vehicle-tracker-prototype/clock"
+	"github.com/jaggu/vehicle-tracker-prototype/jsonfile"
+)
+
+// keyPrefix starts every key, so that leaked keys are easy to grep for.
+const keyPrefix = "vt_"
+
+// displayPrefixLen is how much of a key is kept in clear for display.
+const displayPrefixLen = len(keyPrefix) + 6
+
+// Errors returned by Manager.
+var (
+	ErrUnknownKey  = errors.New("unknown API key")
+	ErrRevoked     = errors.New("API key has been revoked")
+	ErrRateLimited = errors.New("API key rate limit exceeded")
+	ErrNotFound    = errors.New("no API key with that ID")
+)
+
+// Key is the stored form of an API key.
+type Key struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Agency string `json:"agency,omitempty"` // empty: all agencies
+
+	// Prefix is the start of the key, for recognising it in listings.
+	Prefix string `json:"prefix"`
+	Hash   string `json:"hash"`
+
+	// RateLimit is the sustained number of requests per second allowed,
+	// with bursts of up to Burst requests.  Zero means unlimited.
+	RateLimit float64 `json:"rate_limit,omitempty"`
+	Burst     int     `json:"burst,omitempty"`
+
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Usage holds a key's request counters since the server started.
+type Usage struct {
+	Requests  int64     `json:"requests"`
+	Throttled int64     `json:"throttled"`
+	LastUsed  time.Time `json:"last_used,omitempty"`
+}
+
+// KeyInfo is a key together with its usage, as returned by List.
+type KeyInfo struct {
+	Key
+	Usage Usage `json:"usage"`
+}
+
+type entry struct {
+	key    Key
+	usage  Usage
+	bucket *bucket
+}
+
+// Manager creates, revokes and checks API keys.  It is safe for
+// concurrent use.
+type Manager struct {
+	path  string
+	clock clock.Clock
+
+	mu     sync.Mutex
+	byID   map[string]*entry
+	byHash map[string]*entry
+}
+
+// Open returns a Manager that keeps its keys in the JSON file at path,
+// loading any keys already there.  An empty path keeps keys in memory
+// only.
+func Open(path string, clk clock.Clock) (*Manager, error) {
+	m := &Manager{
+		path:   path,
+		clock:  clk,
+		byID:   make(map[string]*entry),
+		byHash: make(map[string]*entry),
+	}
+	if path == "" {
+		return m, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var keys []Key
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for _, k := range keys {
+		m.add(k)
+	}
+	return m, nil
+}
+
+func (m *Manager) add(k Key) {
+	e := &entry{key: k, bucket: newBucket(k.RateLimit, k.Burst, m.clock.Now())}
+	m.byID[k.ID] = e
+	m.byHash[k.Hash] = e
+}
+
+// Create generates a new key and returns it in clear together with its
+// stored form.  The clear key cannot be recovered later.
+func (m *Manager) Create(name, agency string, rateLimit float64, burst int) (string, Key, error) {
+	secret, err := randomString(24)
+	if err != nil {
+		return "", Key{}, err
+	}
+	secret = keyPrefix + secret
+	id, err := randomHex(8)
+	if err != nil {
+		return "", Key{}, err
+	}
+
+	k := Key{
+		ID:        id,
+		Name:      name,
+		Agency:    agency,
+		Prefix:    secret[:displayPrefixLen],
+		Hash:      hash(secret),
+		RateLimit: rateLimit,
+		Burst:     burst,
+		CreatedAt: m.clock.Now().UTC(),
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.add(k)
+	if err := m.saveLocked(); err != nil {
+		delete(m.byID, k.ID)
+		delete(m.byHash, k.Hash)
+		return "", Key{}, err
+	}
+	return secret, k, nil
+}
+
+// List returns every key, including revoked ones, oldest first.
+func (m *Manager) List() []KeyInfo {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := make([]KeyInfo, 0, len(m.byID))
+	for _, e := range m.byID {
+		list = append(list, KeyInfo{Key: e.key, Usage: e.usage})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].CreatedAt.Equal(list[j].CreatedAt) {
+			return list[i].CreatedAt.Before(list[j].CreatedAt)
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// Revoke disables a key.  Revoking an already revoked key is a no-op.
+func (m *Manager) Revoke(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.byID[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if e.key.RevokedAt != nil {
+		return nil
+	}
+	now := m.clock.Now().UTC()
+	e.key.RevokedAt = &now
+	if err := m.saveLocked(); err != nil {
+		e.key.RevokedAt = nil
+		return err
+	}
+	return nil
+}
+
+// Check verifies a key presented by a client, counts the request and
+// applies the key's rate limit.  It returns the key's stored form.
+func (m *Manager) Check(secret string) (Key, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.byHash[hash(secret)]
+	if !ok {
+		return Key{}, ErrUnknownKey
+	}
+	if e.key.RevokedAt != nil {
+		return Key{}, ErrRevoked
+	}
+
+	now := m.clock.Now()
+	e.usage.Requests++
+	e.usage.LastUsed = now.UTC()
+	if !e.bucket.allow(now) {
+		e.usage.Throttled++
+		return e.key, ErrRateLimited
+	}
+	return e.key, nil
+}
+
+// saveLocked rewrites the key file atomically.  m.mu must be held.
+func (m *Manager) saveLocked() error {
+	if m.path == "" {
+		return nil
+	}
+	keys := make([]Key, 0, len(m.byID))
+	for _, e := range m.byID {
+		keys = append(keys, e.key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+
+	if err := jsonfile.Write(m.path, keys); err != nil {
+		return fmt.Errorf("apikey: %w", err)
+	}
+	return nil
+}
+
+func hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("apikey: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("apikey: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
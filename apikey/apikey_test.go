@@ -0,0 +1,89 @@
+package apikey_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jaggu/vehicle-tracker-prototype/apikey"
+	"github.com/jaggu/vehicle-tracker-prototype/clock"
+)
+
+func TestManager_CreateCheckRevokePersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	clk := clock.NewFake(time.Unix(1752566400, 0))
+	m, err := apikey.Open(path, clk)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	secret, key, err := m.Create("OneBusAway Nairobi", "", 0, 0)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if !strings.HasPrefix(secret, key.Prefix) {
+		t.Errorf("prefix %q does not start key %q", key.Prefix, secret)
+	}
+
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), secret) {
+		t.Fatal("key file contains the key in clear")
+	}
+
+	if _, err := m.Check(secret); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if _, err := m.Check(secret + "x"); !errors.Is(err, apikey.ErrUnknownKey) {
+		t.Errorf("wrong key: err = %v, want ErrUnknownKey", err)
+	}
+	if got := m.List()[0].Usage.Requests; got != 1 {
+		t.Errorf("requests = %d, want 1", got)
+	}
+
+	if err := m.Revoke(key.ID); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+
+	// Keys and revocations survive a restart.
+	m, err = apikey.Open(path, clk)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if _, err := m.Check(secret); !errors.Is(err, apikey.ErrRevoked) {
+		t.Errorf("revoked key after restart: err = %v, want ErrRevoked", err)
+	}
+	if list := m.List(); len(list) != 1 || list[0].RevokedAt == nil {
+		t.Errorf("List = %+v, want the revoked key", list)
+	}
+}
+
+func TestManager_RateLimit(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1752566400, 0))
+	m, _ := apikey.Open("", clk)
+	secret, _, err := m.Create("planner", "", 1, 2)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := m.Check(secret); err != nil {
+			t.Fatalf("request %d within burst: %v", i, err)
+		}
+	}
+	if _, err := m.Check(secret); !errors.Is(err, apikey.ErrRateLimited) {
+		t.Fatalf("request over burst: err = %v, want ErrRateLimited", err)
+	}
+
+	clk.Advance(time.Second)
+	if _, err := m.Check(secret); err != nil {
+		t.Errorf("request after refill: %v", err)
+	}
+
+	usage := m.List()[0].Usage
+	if usage.Requests != 4 || usage.Throttled != 1 {
+		t.Errorf("usage = %+v, want 4 requests, 1 throttled", usage)
+	}
+}
@@ -0,0 +1,44 @@
+package apikey
+
+import (
+	"math"
+	"time"
+)
+
+// bucket is a token-bucket rate limiter.  It holds up to burst tokens,
+// refilled at rate tokens per second; each request takes one.  A zero
+// rate means unlimited.
+type bucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newBucket returns a full bucket.  A burst below one defaults to one
+// second's worth of requests (at least one).
+func newBucket(rate float64, burst int, now time.Time) *bucket {
+	b := &bucket{rate: rate, burst: float64(burst), last: now}
+	if b.burst < 1 {
+		b.burst = math.Max(1, math.Ceil(rate))
+	}
+	b.tokens = b.burst
+	return b
+}
+
+// allow reports whether a request at now is within the limit, taking a
+// token if so.
+func (b *bucket) allow(now time.Time) bool {
+	if b.rate <= 0 {
+		return true
+	}
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed*b.rate)
+		b.last = now
+	}
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
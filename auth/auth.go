@@ -0,0 +1,353 @@
+// Package auth authenticates drivers with a phone number and PIN and
+// issues the JWTs that driver apps send with their location reports.
+//
+// Design decisions:
+//
+//	PINs are stored as bcrypt hashes; the plain PIN never leaves Login.
+//	Tokens are HS256-signed JWTs.  A short-lived access token authorizes
+//	requests and a long-lived refresh token obtains new pairs, so a
+//	driver logs in once and stays logged in across shifts.
+//	Every token carries a unique ID (jti).  Logout and refresh revoke
+//	IDs until they would have expired anyway; the revocation list is kept
+//	in memory and does not survive a restart.
+//	Assignments and the active flag are read from the driver record on
+//	every request rather than baked into the token, so changes take
+//	effect immediately.  Resetting a PIN revokes every token issued to
+//	the driver before the reset; the cutoff is saved on the driver
+//	record, so it survives a restart.
+//	A driver may be assigned several vehicles and a vehicle several
+//	drivers, but only one driver drives a vehicle at a time; see Drive.
+//	Wrong PINs lock the phone number, and the client trying them, out of
+//	Login for a while, so short PINs can't be guessed by brute force.
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/jaggu/vehicle-tracker-prototype/clock"
+)
+
+// Defaults applied by New to zero-valued Config fields.
+const (
+	DefaultAccessTTL  = time.Hour
+	DefaultRefreshTTL = 30 * 24 * time.Hour
+
+	DefaultDrivingLease = 10 * time.Minute
+
+	DefaultMaxLoginFailures       = 5
+	DefaultClientMaxLoginFailures = 20
+	DefaultLoginLockout           = 15 * time.Minute
+)
+
+// issuer is the "iss" claim of every token.
+const issuer = "vehicle-tracker"
+
+// Token kinds, carried in the "kind" claim so that a refresh token can't
+// be used as an access token or vice versa.
+const (
+	kindAccess  = "access"
+	kindRefresh = "refresh"
+)
+
+// Errors returned by Authenticator.
+var (
+	ErrInvalidCredentials = errors.New("invalid phone number or PIN")
+	ErrInvalidToken       = errors.New("invalid or expired token")
+	ErrInactiveDriver     = errors.New("driver account is deactivated")
+	ErrVehicleInUse       = errors.New("vehicle is being driven by another driver")
+	ErrTooManyAttempts    = errors.New("too many failed login attempts")
+)
+
+// Config configures an Authenticator.
+type Config struct {
+	// Secret signs and verifies tokens.  It must be set.
+	Secret []byte
+
+	// AccessTTL and RefreshTTL are the lifetimes of the two token kinds.
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+
+	// DrivingLease is how long a vehicle stays claimed by a driver after
+	// their last report, unless they stop driving explicitly.
+	DrivingLease time.Duration
+
+	// MaxLoginFailures is how many wrong PINs in a row lock a phone
+	// number out of Login for LoginLockout, and ClientMaxLoginFailures
+	// how many lock out a client address, whatever phone numbers it
+	// tries.  Each further lockout lasts twice as long, up to a day.
+	MaxLoginFailures       int
+	ClientMaxLoginFailures int
+	LoginLockout           time.Duration
+
+	// Clock defaults to clock.Real.
+	Clock clock.Clock
+}
+
+// TokenPair is returned by Login and Refresh.
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"` // access token lifetime in seconds
+}
+
+// claims is the JWT payload.  The driver ID is the subject.
+type claims struct {
+	jwt.RegisteredClaims
+	Kind string `json:"kind"`
+}
+
+// Authenticator logs drivers in and verifies their tokens.
+type Authenticator struct {
+	cfg     Config
+	drivers *Drivers
+
+	mu      sync.Mutex
+	revoked map[string]time.Time // jti → expiry
+
+	// driving maps a vehicle to the driver currently driving it, and
+	// drivingBy the reverse.
+	driving   map[string]drivingClaim
+	drivingBy map[string]string
+
+	// phoneFailures and clientFailures throttle Login by phone number
+	// and by client address.
+	phoneFailures  *throttle
+	clientFailures *throttle
+}
+
+// New returns an Authenticator for the given drivers.
+func New(drivers *Drivers, cfg Config) (*Authenticator, error) {
+	if len(cfg.Secret) == 0 {
+		return nil, errors.New("auth: signing secret is required")
+	}
+	if cfg.AccessTTL <= 0 {
+		cfg.AccessTTL = DefaultAccessTTL
+	}
+	if cfg.RefreshTTL <= 0 {
+		cfg.RefreshTTL = DefaultRefreshTTL
+	}
+	if cfg.DrivingLease <= 0 {
+		cfg.DrivingLease = DefaultDrivingLease
+	}
+	if cfg.MaxLoginFailures <= 0 {
+		cfg.MaxLoginFailures = DefaultMaxLoginFailures
+	}
+	if cfg.ClientMaxLoginFailures <= 0 {
+		cfg.ClientMaxLoginFailures = DefaultClientMaxLoginFailures
+	}
+	if cfg.LoginLockout <= 0 {
+		cfg.LoginLockout = DefaultLoginLockout
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = clock.Real
+	}
+	return &Authenticator{
+		cfg:            cfg,
+		drivers:        drivers,
+		revoked:        make(map[string]time.Time),
+		driving:        make(map[string]drivingClaim),
+		drivingBy:      make(map[string]string),
+		phoneFailures:  newThrottle(cfg.MaxLoginFailures, cfg.LoginLockout),
+		clientFailures: newThrottle(cfg.ClientMaxLoginFailures, cfg.LoginLockout),
+	}, nil
+}
+
+// Drivers returns the driver set the Authenticator checks against.
+func (a *Authenticator) Drivers() *Drivers {
+	return a.drivers
+}
+
+// Login checks a phone number and PIN and returns a new token pair.
+// client identifies where the attempt comes from, such as the remote IP
+// address, or is "" if unknown.
+//
+// Too many wrong PINs for a phone number, or from a client, lock it out
+// for a while (see Config.MaxLoginFailures); Login then returns a
+// *ThrottledError without checking the PIN.
+func (a *Authenticator) Login(phone, pin, client string) (TokenPair, error) {
+	now := a.cfg.Clock.Now()
+	key := NormalizePhone(phone)
+	a.mu.Lock()
+	wait := a.phoneFailures.begin(key, now)
+	if wait == 0 && client != "" {
+		if wait = a.clientFailures.begin(client, now); wait > 0 {
+			a.phoneFailures.end(key, now, true, false)
+		}
+	}
+	a.mu.Unlock()
+	if wait > 0 {
+		return TokenPair{}, &ThrottledError{RetryAfter: wait}
+	}
+
+	drv, ok := a.drivers.ByPhone(phone)
+	if ok {
+		ok = CheckPIN(drv.PINHash, pin)
+	} else {
+		CheckPIN(string(dummyHash), pin)
+	}
+	a.mu.Lock()
+	a.phoneFailures.end(key, now, ok, true)
+	if client != "" {
+		a.clientFailures.end(client, now, ok, false)
+	}
+	a.mu.Unlock()
+	if !ok {
+		return TokenPair{}, ErrInvalidCredentials
+	}
+	if !drv.Active {
+		return TokenPair{}, ErrInactiveDriver
+	}
+	return a.issue(drv.ID)
+}
+
+// Refresh exchanges a refresh token for a new token pair.  The old
+// refresh token is revoked, so each one can be used only once.
+func (a *Authenticator) Refresh(refreshToken string) (TokenPair, error) {
+	c, err := a.parse(refreshToken, kindRefresh)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	if _, err := a.activeDriver(c.Subject); err != nil {
+		return TokenPair{}, err
+	}
+	a.revoke(c)
+	return a.issue(c.Subject)
+}
+
+// Authenticate verifies an access token and returns the driver it was
+// issued to.
+func (a *Authenticator) Authenticate(accessToken string) (Driver, error) {
+	c, err := a.parse(accessToken, kindAccess)
+	if err != nil {
+		return Driver{}, err
+	}
+	return a.activeDriver(c.Subject)
+}
+
+// RevokeDriver invalidates every token issued to a driver so far, for
+// example after a PIN reset, and saves the cutoff on the driver record.
+// The driver can log in again.
+func (a *Authenticator) RevokeDriver(driverID string) error {
+	// Token times have one-second resolution, so the cutoff is the start
+	// of the next second: every token issued so far is before it, and
+	// issue dates later tokens no earlier than it.
+	cutoff := a.cfg.Clock.Now().Truncate(time.Second).Add(time.Second)
+	return a.drivers.setTokensValidAfter(driverID, cutoff)
+}
+
+// Revoke invalidates a token of either kind before it expires.
+func (a *Authenticator) Revoke(token string) error {
+	c, err := a.parse(token, "")
+	if err != nil {
+		return err
+	}
+	a.revoke(c)
+	return nil
+}
+
+func (a *Authenticator) activeDriver(id string) (Driver, error) {
+	drv, ok := a.drivers.Get(id)
+	if !ok {
+		return Driver{}, ErrInvalidToken
+	}
+	if !drv.Active {
+		return Driver{}, ErrInactiveDriver
+	}
+	return drv, nil
+}
+
+// issue signs a new access and refresh token for a driver.
+func (a *Authenticator) issue(driverID string) (TokenPair, error) {
+	now := a.cfg.Clock.Now()
+	// A token issued in the second of a reset is dated after the cutoff.
+	issuedAt := now
+	if drv, ok := a.drivers.Get(driverID); ok && drv.TokensValidAfter != nil && issuedAt.Before(*drv.TokensValidAfter) {
+		issuedAt = *drv.TokensValidAfter
+	}
+	access, err := a.sign(driverID, kindAccess, issuedAt, now.Add(a.cfg.AccessTTL))
+	if err != nil {
+		return TokenPair{}, err
+	}
+	refresh, err := a.sign(driverID, kindRefresh, issuedAt, now.Add(a.cfg.RefreshTTL))
+	if err != nil {
+		return TokenPair{}, err
+	}
+	return TokenPair{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(a.cfg.AccessTTL.Seconds()),
+	}, nil
+}
+
+func (a *Authenticator) sign(driverID, kind string, issuedAt, expiresAt time.Time) (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("auth: token id: %w", err)
+	}
+	c := claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    issuer,
+			Subject:   driverID,
+			ID:        hex.EncodeToString(id),
+			IssuedAt:  jwt.NewNumericDate(issuedAt),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+		Kind: kind,
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, c).SignedString(a.cfg.Secret)
+}
+
+// parse verifies a token's signature, expiry, kind (unless kind is empty)
+// and revocation status.
+func (a *Authenticator) parse(token, kind string) (*claims, error) {
+	var c claims
+	_, err := jwt.ParseWithClaims(token, &c,
+		func(*jwt.Token) (any, error) { return a.cfg.Secret, nil },
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(issuer),
+		jwt.WithExpirationRequired(),
+		jwt.WithTimeFunc(a.cfg.Clock.Now),
+	)
+	if err != nil || c.Subject == "" || c.ID == "" {
+		return nil, ErrInvalidToken
+	}
+	if kind != "" && c.Kind != kind {
+		return nil, ErrInvalidToken
+	}
+
+	a.mu.Lock()
+	_, revoked := a.revoked[c.ID]
+	a.mu.Unlock()
+	if revoked {
+		return nil, ErrInvalidToken
+	}
+	if drv, ok := a.drivers.Get(c.Subject); ok && drv.TokensValidAfter != nil &&
+		(c.IssuedAt == nil || c.IssuedAt.Before(*drv.TokensValidAfter)) {
+		return nil, ErrInvalidToken
+	}
+	return &c, nil
+}
+
+// revoke records a token ID as revoked until the token's expiry, pruning
+// entries whose tokens have expired on their own.
+func (a *Authenticator) revoke(c *claims) {
+	now := a.cfg.Clock.Now()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for id, exp := range a.revoked {
+		if now.After(exp) {
+			delete(a.revoked, id)
+		}
+	}
+	a.revoked[c.ID] = c.ExpiresAt.Time
+}
@@ -0,0 +1,262 @@
+package auth_test
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jaggu/vehicle-tracker-prototype/auth"
+	"github.com/jaggu/vehicle-tracker-prototype/clock"
+)
+
+func newTestAuth(t *testing.T) (*auth.Authenticator, *clock.Fake) {
+	t.Helper()
+	hash, err := auth.HashPIN("4321")
+	if err != nil {
+		t.Fatalf("HashPIN: %v", err)
+	}
+	drivers := auth.NewDrivers()
+	if err := drivers.Put(auth.Driver{
+		ID: "drv-1", Name: "Ravi", Phone: "+91 98000-00001",
+		PINHash: hash, VehicleIDs: []string{"bus-1"}, Active: true,
+	}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	clk := clock.NewFake(time.Unix(1752566400, 0))
+	a, err := auth.New(drivers, auth.Config{Secret: []byte("test-secret"), AccessTTL: time.Hour, Clock: clk})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return a, clk
+}
+
+func TestLogin(t *testing.T) {
+	a, _ := newTestAuth(t)
+
+	if _, err := a.Login("+919800000001", "0000", ""); !errors.Is(err, auth.ErrInvalidCredentials) {
+		t.Errorf("wrong PIN: err = %v, want ErrInvalidCredentials", err)
+	}
+	if _, err := a.Login("+919899999999", "4321", ""); !errors.Is(err, auth.ErrInvalidCredentials) {
+		t.Errorf("unknown phone: err = %v, want ErrInvalidCredentials", err)
+	}
+
+	pair, err := a.Login("+919800000001", "4321", "")
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	drv, err := a.Authenticate(pair.AccessToken)
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if drv.ID != "drv-1" || !drv.AssignedTo("bus-1") {
+		t.Errorf("driver = %+v, want drv-1 assigned to bus-1", drv)
+	}
+	if _, err := a.Authenticate(pair.RefreshToken); !errors.Is(err, auth.ErrInvalidToken) {
+		t.Errorf("refresh token used as access token: err = %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestLogin_WrongPINsLockOut(t *testing.T) {
+	a, clk := newTestAuth(t)
+
+	for i := 0; i < auth.DefaultMaxLoginFailures; i++ {
+		if _, err := a.Login("+919800000001", "0000", "10.0.0.1"); !errors.Is(err, auth.ErrInvalidCredentials) {
+			t.Fatalf("wrong PIN %d: err = %v, want ErrInvalidCredentials", i+1, err)
+		}
+	}
+	// Locked out, even with the right PIN and from elsewhere.
+	_, err := a.Login("+91 98000 00001", "4321", "10.0.0.9")
+	var throttled *auth.ThrottledError
+	if !errors.As(err, &throttled) || !errors.Is(err, auth.ErrTooManyAttempts) || throttled.RetryAfter != auth.DefaultLoginLockout {
+		t.Fatalf("after %d wrong PINs: err = %v, want a %v lockout", auth.DefaultMaxLoginFailures, err, auth.DefaultLoginLockout)
+	}
+
+	clk.Advance(auth.DefaultLoginLockout)
+	if _, err := a.Login("+919800000001", "4321", "10.0.0.1"); err != nil {
+		t.Fatalf("after the lockout: %v", err)
+	}
+
+	// A client trying many phone numbers is locked out by address.
+	for i := 0; i < auth.DefaultClientMaxLoginFailures; i++ {
+		a.Login(fmt.Sprintf("+9198999%05d", i), "0000", "10.0.0.2")
+	}
+	if _, err := a.Login("+919800000001", "4321", "10.0.0.2"); !errors.Is(err, auth.ErrTooManyAttempts) {
+		t.Errorf("client after %d wrong PINs: err = %v, want ErrTooManyAttempts", auth.DefaultClientMaxLoginFailures, err)
+	}
+	if _, err := a.Login("+919800000001", "4321", "10.0.0.3"); err != nil {
+		t.Errorf("another client: %v", err)
+	}
+}
+
+func TestTokenExpiryRefreshAndRevocation(t *testing.T) {
+	a, clk := newTestAuth(t)
+
+	pair, err := a.Login("+919800000001", "4321", "")
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+
+	clk.Advance(61 * time.Minute)
+	if _, err := a.Authenticate(pair.AccessToken); !errors.Is(err, auth.ErrInvalidToken) {
+		t.Fatalf("expired access token: err = %v, want ErrInvalidToken", err)
+	}
+
+	next, err := a.Refresh(pair.RefreshToken)
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if _, err := a.Authenticate(next.AccessToken); err != nil {
+		t.Errorf("refreshed access token: %v", err)
+	}
+	if _, err := a.Refresh(pair.RefreshToken); !errors.Is(err, auth.ErrInvalidToken) {
+		t.Errorf("reused refresh token: err = %v, want ErrInvalidToken", err)
+	}
+
+	if err := a.Revoke(next.AccessToken); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	if _, err := a.Authenticate(next.AccessToken); !errors.Is(err, auth.ErrInvalidToken) {
+		t.Errorf("revoked access token: err = %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestDeactivatedDriverIsLockedOut(t *testing.T) {
+	a, _ := newTestAuth(t)
+
+	pair, err := a.Login("+919800000001", "4321", "")
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+
+	drv, _ := a.Drivers().Get("drv-1")
+	drv.Active = false
+	a.Drivers().Put(drv)
+
+	if _, err := a.Authenticate(pair.AccessToken); !errors.Is(err, auth.ErrInactiveDriver) {
+		t.Errorf("Authenticate: err = %v, want ErrInactiveDriver", err)
+	}
+	if _, err := a.Login("+919800000001", "4321", ""); !errors.Is(err, auth.ErrInactiveDriver) {
+		t.Errorf("Login: err = %v, want ErrInactiveDriver", err)
+	}
+}
+
+func TestPINResetRevokesExistingTokens(t *testing.T) {
+	a, clk := newTestAuth(t)
+
+	clk.Advance(300 * time.Millisecond)
+	pair, err := a.Login("+919800000001", "4321", "")
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	// The reset comes later in the same second as the login.
+	clk.Advance(300 * time.Millisecond)
+	if err := a.RevokeDriver("drv-1"); err != nil {
+		t.Fatalf("RevokeDriver: %v", err)
+	}
+
+	if _, err := a.Authenticate(pair.AccessToken); !errors.Is(err, auth.ErrInvalidToken) {
+		t.Errorf("access token after reset: err = %v, want ErrInvalidToken", err)
+	}
+	if _, err := a.Refresh(pair.RefreshToken); !errors.Is(err, auth.ErrInvalidToken) {
+		t.Errorf("refresh token after reset: err = %v, want ErrInvalidToken", err)
+	}
+	next, err := a.Login("+919800000001", "4321", "")
+	if err != nil {
+		t.Fatalf("Login after reset: %v", err)
+	}
+	if _, err := a.Authenticate(next.AccessToken); err != nil {
+		t.Errorf("token issued after reset: %v", err)
+	}
+}
+
+func TestPINResetSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "drivers.json")
+	hash, err := auth.HashPIN("4321")
+	if err != nil {
+		t.Fatalf("HashPIN: %v", err)
+	}
+	drivers, err := auth.OpenDrivers(path)
+	if err != nil {
+		t.Fatalf("OpenDrivers: %v", err)
+	}
+	drivers.Put(auth.Driver{ID: "drv-1", Phone: "+919800000001", PINHash: hash, Active: true})
+	clk := clock.NewFake(time.Unix(1752566400, 0))
+	cfg := auth.Config{Secret: []byte("test-secret"), Clock: clk}
+	a, _ := auth.New(drivers, cfg)
+
+	pair, err := a.Login("+919800000001", "4321", "")
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if err := a.RevokeDriver("drv-1"); err != nil {
+		t.Fatalf("RevokeDriver: %v", err)
+	}
+
+	// A restart loads the drivers again into a new Authenticator.
+	drivers, err = auth.OpenDrivers(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	a, _ = auth.New(drivers, cfg)
+	if _, err := a.Refresh(pair.RefreshToken); !errors.Is(err, auth.ErrInvalidToken) {
+		t.Errorf("refresh token from before the reset: err = %v, want ErrInvalidToken", err)
+	}
+	next, err := a.Login("+919800000001", "4321", "")
+	if err != nil {
+		t.Fatalf("Login after restart: %v", err)
+	}
+	if _, err := a.Authenticate(next.AccessToken); err != nil {
+		t.Errorf("token issued after reset: %v", err)
+	}
+}
+
+func TestDrive_OneDriverPerVehicle(t *testing.T) {
+	a, clk := newTestAuth(t)
+
+	if err := a.Drive("drv-1", "bus-1"); err != nil {
+		t.Fatalf("drv-1 Drive: %v", err)
+	}
+	if err := a.Drive("drv-2", "bus-1"); !errors.Is(err, auth.ErrVehicleInUse) {
+		t.Fatalf("drv-2 on a claimed vehicle: err = %v, want ErrVehicleInUse", err)
+	}
+
+	// The claim lapses once drv-1 stops reporting.
+	clk.Advance(auth.DefaultDrivingLease + time.Second)
+	if err := a.Drive("drv-2", "bus-1"); err != nil {
+		t.Fatalf("drv-2 after lease expiry: %v", err)
+	}
+	if _, ok := a.DrivingVehicle("drv-1"); ok {
+		t.Error("drv-1 still holds bus-1 after losing it")
+	}
+
+	a.StopDriving("drv-2")
+	if err := a.Drive("drv-1", "bus-1"); err != nil {
+		t.Errorf("drv-1 after drv-2 stopped: %v", err)
+	}
+}
+
+func TestDrive_MovingOnKeepsTakenOverClaim(t *testing.T) {
+	a, clk := newTestAuth(t)
+
+	if err := a.Drive("drv-1", "bus-1"); err != nil {
+		t.Fatalf("drv-1 Drive: %v", err)
+	}
+	clk.Advance(auth.DefaultDrivingLease + time.Second)
+	if err := a.Drive("drv-2", "bus-1"); err != nil {
+		t.Fatalf("drv-2 after lease expiry: %v", err)
+	}
+
+	// drv-1 moving on to bus-2 must not release drv-2's claim on bus-1.
+	if err := a.Drive("drv-1", "bus-2"); err != nil {
+		t.Fatalf("drv-1 on bus-2: %v", err)
+	}
+	if err := a.Drive("drv-3", "bus-1"); !errors.Is(err, auth.ErrVehicleInUse) {
+		t.Errorf("drv-3 on bus-1 while drv-2 drives it: err = %v, want ErrVehicleInUse", err)
+	}
+	if v, ok := a.DrivingVehicle("drv-2"); !ok || v != "bus-1" {
+		t.Errorf("drv-2 drives %q, %v; want bus-1", v, ok)
+	}
+}
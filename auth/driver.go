@@ -0,0 +1,207 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jaggu/vehicle-tracker-prototype/jsonfile"
+)
+
+// Driver is a person allowed to report locations for the vehicles they
+// are assigned to.
+type Driver struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	Phone      string   `json:"phone"`
+	PINHash    string   `json:"pin_hash"`
+	VehicleIDs []string `json:"vehicle_ids"`
+	Active     bool     `json:"active"`
+
+	// TokensValidAfter, when set, invalidates the tokens issued to the
+	// driver before it; see Authenticator.RevokeDriver.
+	TokensValidAfter *time.Time `json:"tokens_valid_after,omitempty"`
+}
+
+// AssignedTo reports whether the driver may report for vehicleID.
+func (d Driver) AssignedTo(vehicleID string) bool {
+	return slices.Contains(d.VehicleIDs, vehicleID)
+}
+
+// NormalizePhone strips the spaces, dashes and parentheses people type
+// into phone numbers, so that "+91 98000-00001" and "+919800000001" match.
+func NormalizePhone(phone string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '-', '(', ')', '.':
+			return -1
+		}
+		return r
+	}, phone)
+}
+
+// Errors returned by Drivers.Put.
+var (
+	ErrInvalidDriver = errors.New("invalid driver")
+	ErrPhoneInUse    = errors.New("phone number belongs to another driver")
+)
+
+// Drivers is the set of drivers, indexed by ID and phone, optionally
+// stored in a JSON file.  It is safe for concurrent use.
+type Drivers struct {
+	path string
+
+	mu      sync.RWMutex
+	byID    map[string]Driver
+	byPhone map[string]string // normalized phone → driver ID
+}
+
+// NewDrivers returns an empty driver set kept in memory only.
+func NewDrivers() *Drivers {
+	return &Drivers{
+		byID:    make(map[string]Driver),
+		byPhone: make(map[string]string),
+	}
+}
+
+// OpenDrivers returns a driver set stored in the JSON file at path,
+// loading the drivers already there; a missing file is an empty set.
+// Entries carry a bcrypt "pin_hash" (see HashPIN) rather than the PIN
+// itself, and are active unless they set "active": false.
+func OpenDrivers(path string) (*Drivers, error) {
+	d := NewDrivers()
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		d.path = path
+		return d, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []struct {
+		Driver
+		Active *bool `json:"active"`
+	}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	for i, entry := range list {
+		drv := entry.Driver
+		drv.Active = entry.Active == nil || *entry.Active
+		if err := d.Put(drv); err != nil {
+			return nil, fmt.Errorf("%s: driver %d: %w", path, i, err)
+		}
+	}
+	d.path = path
+	return d, nil
+}
+
+// Put adds or replaces a driver and saves the set.  It fails if the ID
+// or phone is missing, or if the phone already belongs to another driver.
+func (d *Drivers) Put(drv Driver) error {
+	drv.Phone = NormalizePhone(drv.Phone)
+	if drv.ID == "" {
+		return fmt.Errorf("%w: id is required", ErrInvalidDriver)
+	}
+	if drv.Phone == "" {
+		return fmt.Errorf("%w: phone is required", ErrInvalidDriver)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if owner, ok := d.byPhone[drv.Phone]; ok && owner != drv.ID {
+		return fmt.Errorf("%w: %s is driver %s", ErrPhoneInUse, drv.Phone, owner)
+	}
+	old, existed := d.byID[drv.ID]
+	if existed {
+		delete(d.byPhone, old.Phone)
+	}
+	d.byID[drv.ID] = drv
+	d.byPhone[drv.Phone] = drv.ID
+
+	if err := d.saveLocked(); err != nil {
+		delete(d.byPhone, drv.Phone)
+		if existed {
+			d.byID[old.ID] = old
+			d.byPhone[old.Phone] = old.ID
+		} else {
+			delete(d.byID, drv.ID)
+		}
+		return err
+	}
+	return nil
+}
+
+// setTokensValidAfter records a token cutoff on a driver and saves the
+// set.
+func (d *Drivers) setTokensValidAfter(id string, at time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	old, ok := d.byID[id]
+	if !ok {
+		return fmt.Errorf("%w: no driver %s", ErrInvalidDriver, id)
+	}
+	drv := old
+	drv.TokensValidAfter = &at
+	d.byID[id] = drv
+	if err := d.saveLocked(); err != nil {
+		d.byID[id] = old
+		return err
+	}
+	return nil
+}
+
+// Get returns the driver with the given ID.
+func (d *Drivers) Get(id string) (Driver, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	drv, ok := d.byID[id]
+	return drv, ok
+}
+
+// ByPhone returns the driver with the given phone number.
+func (d *Drivers) ByPhone(phone string) (Driver, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	id, ok := d.byPhone[NormalizePhone(phone)]
+	if !ok {
+		return Driver{}, false
+	}
+	return d.byID[id], true
+}
+
+// List returns every driver, ordered by ID.
+func (d *Drivers) List() []Driver {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.listLocked()
+}
+
+func (d *Drivers) listLocked() []Driver {
+	list := make([]Driver, 0, len(d.byID))
+	for _, drv := range d.byID {
+		list = append(list, drv)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// saveLocked rewrites the driver file atomically.  d.mu must be held.
+func (d *Drivers) saveLocked() error {
+	if d.path == "" {
+		return nil
+	}
+	if err := jsonfile.Write(d.path, d.listLocked()); err != nil {
+		return fmt.Errorf("auth: %w", err)
+	}
+	return nil
+}
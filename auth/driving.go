@@ -0,0 +1,62 @@
+package auth
+
+import "time"
+
+// drivingClaim records which driver is driving a vehicle, until when.
+type drivingClaim struct {
+	driverID string
+	until    time.Time
+}
+
+// Drive records that a driver is driving a vehicle, for DrivingLease from
+// now.  Each location report renews the claim.  It fails with
+// ErrVehicleInUse while another driver's claim on the vehicle is live.
+// Claiming a new vehicle releases the driver's previous one, unless
+// another driver has taken it over since.
+func (a *Authenticator) Drive(driverID, vehicleID string) error {
+	now := a.cfg.Clock.Now()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if c, ok := a.driving[vehicleID]; ok && c.driverID != driverID && now.Before(c.until) {
+		return ErrVehicleInUse
+	}
+	if prev, ok := a.drivingBy[driverID]; ok && prev != vehicleID && a.driving[prev].driverID == driverID {
+		delete(a.driving, prev)
+	}
+	a.driving[vehicleID] = drivingClaim{driverID: driverID, until: now.Add(a.cfg.DrivingLease)}
+	a.drivingBy[driverID] = vehicleID
+	return nil
+}
+
+// StopDriving releases the vehicle a driver is driving, if any, so that
+// another driver can take it over straight away.
+func (a *Authenticator) StopDriving(driverID string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if v, ok := a.drivingBy[driverID]; ok {
+		if a.driving[v].driverID == driverID {
+			delete(a.driving, v)
+		}
+		delete(a.drivingBy, driverID)
+	}
+}
+
+// DrivingVehicle returns the vehicle a driver currently holds a live
+// claim on.
+func (a *Authenticator) DrivingVehicle(driverID string) (string, bool) {
+	now := a.cfg.Clock.Now()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	v, ok := a.drivingBy[driverID]
+	if !ok {
+		return "", false
+	}
+	c := a.driving[v]
+	if c.driverID != driverID || !now.Before(c.until) {
+		return "", false
+	}
+	return v, true
+}
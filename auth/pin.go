@@ -0,0 +1,31 @@
+package auth
+
+import (
+	"fmt"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// MinPINLength is the shortest PIN HashPIN accepts.
+const MinPINLength = 4
+
+// HashPIN returns the bcrypt hash of a driver PIN.
+func HashPIN(pin string) (string, error) {
+	if len(pin) < MinPINLength {
+		return "", fmt.Errorf("PIN must be at least %d digits", MinPINLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(pin), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// CheckPIN reports whether pin matches a hash produced by HashPIN.
+func CheckPIN(hash, pin string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(pin)) == nil
+}
+
+// dummyHash is compared against when a login names an unknown phone, so
+// that unknown and known phones take the same time to reject.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not-a-real-pin"), bcrypt.DefaultCost)
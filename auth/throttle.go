@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"fmt"
+	"time"
+)
+
+// maxLockout caps the doubling of repeated lockouts.  A phone number or
+// client that fails no login for this long starts again from scratch.
+const maxLockout = 24 * time.Hour
+
+// ThrottledError is returned by Login while the phone number or the
+// client is locked out after too many wrong PINs.  It matches
+// ErrTooManyAttempts with errors.Is.
+type ThrottledError struct {
+	// RetryAfter is how long until Login may be tried again.
+	RetryAfter time.Duration
+}
+
+func (e *ThrottledError) Error() string {
+	return fmt.Sprintf("%v; retry in %v", ErrTooManyAttempts, e.RetryAfter.Round(time.Second))
+}
+
+func (e *ThrottledError) Is(target error) bool { return target == ErrTooManyAttempts }
+
+// failures is the login record of one phone number or client.
+type failures struct {
+	count    int       // wrong PINs since the last lockout or success
+	pending  int       // attempts being checked
+	lockouts int       // lockouts so far
+	until    time.Time // locked out until
+	last     time.Time // of the last wrong PIN
+}
+
+// throttle locks keys out after max wrong PINs in a row, for lockout,
+// doubled for every earlier lockout up to maxLockout.  The
+// Authenticator's mutex guards it.
+type throttle struct {
+	max     int
+	lockout time.Duration
+
+	keys      map[string]*failures
+	lastPrune time.Time
+}
+
+func newThrottle(max int, lockout time.Duration) *throttle {
+	return &throttle{max: max, lockout: lockout, keys: make(map[string]*failures)}
+}
+
+// begin starts an attempt for key.  It returns how long key must wait
+// instead, if it is locked out, or if attempts already being checked
+// could use up the failures it has left; PINs are slow to check, and
+// they would otherwise all get through before the first failure counts.
+func (t *throttle) begin(key string, now time.Time) time.Duration {
+	t.prune(now)
+	f, ok := t.keys[key]
+	if !ok {
+		f = &failures{}
+		t.keys[key] = f
+	}
+	switch {
+	case now.Before(f.until):
+		return f.until.Sub(now)
+	case f.count+f.pending >= t.max:
+		return time.Second
+	}
+	f.pending++
+	return 0
+}
+
+// end finishes an attempt begun for key.  A wrong PIN counts towards a
+// lockout; with reset set, a right one forgets the earlier failures.
+func (t *throttle) end(key string, now time.Time, ok, reset bool) {
+	f := t.keys[key]
+	f.pending--
+	switch {
+	case ok && reset:
+		f.count = 0
+	case !ok:
+		f.count++
+		f.last = now
+		if f.count >= t.max {
+			f.until = now.Add(min(t.lockout<<min(f.lockouts, 16), maxLockout))
+			f.count = 0
+			f.lockouts++
+		}
+	}
+}
+
+// prune forgets keys that are no longer locked out and have had no
+// wrong PIN for maxLockout.  It does the work at most once a minute.
+func (t *throttle) prune(now time.Time) {
+	if now.Sub(t.lastPrune) < time.Minute {
+		return
+	}
+	t.lastPrune = now
+	for key, f := range t.keys {
+		if f.pending == 0 && !now.Before(f.until) && now.Sub(f.last) > maxLockout {
+			delete(t.keys, key)
+		}
+	}
+}
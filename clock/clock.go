@@ -0,0 +1,111 @@
+// Package clock abstracts the wall clock so that time-dependent code
+// (staleness filtering, eviction, feed timestamps) can be tested
+// deterministically.
+//
+// Production code uses Real; tests use a Fake and move time forward
+// explicitly with Advance instead of sleeping.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time and creates tickers.
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker delivers ticks on C until stopped, like time.Ticker.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Real is the Clock backed by the time package.
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTicker struct{ t *time.Ticker }
+
+func (r realTicker) C() <-chan time.Time { return r.t.C }
+func (r realTicker) Stop()               { r.t.Stop() }
+
+// Fake is a manually driven Clock for tests.  Time only moves when
+// Advance or Set is called; tickers fire as their deadlines are passed.
+type Fake struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*fakeTicker
+}
+
+// NewFake returns a Fake clock reading t.
+func NewFake(t time.Time) *Fake {
+	return &Fake{now: t}
+}
+
+// Now returns the fake current time.
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// Advance moves the clock forward by d, firing due tickers.
+func (f *Fake) Advance(d time.Duration) {
+	f.Set(f.Now().Add(d))
+}
+
+// Set moves the clock to t, firing due tickers.  Like time.Ticker, a
+// ticker whose reader is behind drops ticks rather than blocking.
+func (f *Fake) Set(t time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = t
+	for _, tk := range f.tickers {
+		if tk.stopped || t.Before(tk.next) {
+			continue
+		}
+		select {
+		case tk.c <- t:
+		default:
+		}
+		for !t.Before(tk.next) {
+			tk.next = tk.next.Add(tk.d)
+		}
+	}
+}
+
+// NewTicker returns a ticker that fires each time the fake clock passes
+// another multiple of d.
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	tk := &fakeTicker{f: f, d: d, next: f.now.Add(d), c: make(chan time.Time, 1)}
+	f.tickers = append(f.tickers, tk)
+	return tk
+}
+
+type fakeTicker struct {
+	f       *Fake
+	d       time.Duration
+	next    time.Time
+	c       chan time.Time
+	stopped bool
+}
+
+func (t *fakeTicker) C() <-chan time.Time { return t.c }
+
+func (t *fakeTicker) Stop() {
+	t.f.mu.Lock()
+	defer t.f.mu.Unlock()
+	t.stopped = true
+}
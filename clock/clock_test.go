@@ -0,0 +1,49 @@
+package clock_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jaggu/vehicle-tracker-prototype/clock"
+)
+
+func TestFake_AdvanceMovesNowAndFiresTickers(t *testing.T) {
+	start := time.Date(2026, 7, 15, 8, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(start)
+	tk := clk.NewTicker(time.Minute)
+	defer tk.Stop()
+
+	clk.Advance(30 * time.Second)
+	select {
+	case <-tk.C():
+		t.Fatal("ticker fired before its interval elapsed")
+	default:
+	}
+
+	clk.Advance(30 * time.Second)
+	select {
+	case got := <-tk.C():
+		if !got.Equal(start.Add(time.Minute)) {
+			t.Errorf("tick time = %v, want %v", got, start.Add(time.Minute))
+		}
+	default:
+		t.Fatal("ticker did not fire after its interval elapsed")
+	}
+
+	if got := clk.Now(); !got.Equal(start.Add(time.Minute)) {
+		t.Errorf("Now() = %v, want %v", got, start.Add(time.Minute))
+	}
+}
+
+func TestFake_StoppedTickerDoesNotFire(t *testing.T) {
+	clk := clock.NewFake(time.Date(2026, 7, 15, 8, 0, 0, 0, time.UTC))
+	tk := clk.NewTicker(time.Minute)
+	tk.Stop()
+
+	clk.Advance(time.Hour)
+	select {
+	case <-tk.C():
+		t.Fatal("stopped ticker fired")
+	default:
+	}
+}
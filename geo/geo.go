@@ -0,0 +1,41 @@
+// Package geo provides the small amount of spherical geometry the tracker
+// needs: distances between WGS84 positions and projections onto
+// polyline segments.
+package geo
+
+import "math"
+
+// EarthRadius is the mean Earth radius in meters.
+const EarthRadius = 6371008.8
+
+// Distance returns the great-circle distance in meters between two points
+// given in decimal degrees, using the haversine formula.
+func Distance(lat1, lon1, lat2, lon2 float64) float64 {
+	φ1 := lat1 * math.Pi / 180
+	φ2 := lat2 * math.Pi / 180
+	dφ := (lat2 - lat1) * math.Pi / 180
+	dλ := (lon2 - lon1) * math.Pi / 180
+
+	a := math.Sin(dφ/2)*math.Sin(dφ/2) +
+		math.Cos(φ1)*math.Cos(φ2)*math.Sin(dλ/2)*math.Sin(dλ/2)
+	return 2 * EarthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// Project finds the point of segment a–b closest to (lat, lon).  It
+// returns how far along the segment that point lies, from 0 at a to 1 at
+// b, and its distance from (lat, lon) in meters.
+//
+// The segment is flattened around (lat, lon) with an equirectangular
+// projection, which is accurate for the few-hundred-meter segments of
+// GTFS shapes and stop patterns.
+func Project(lat, lon, aLat, aLon, bLat, bLon float64) (frac, dist float64) {
+	k := math.Cos(lat * math.Pi / 180)
+	ax, ay := (aLon-lon)*k, aLat-lat
+	bx, by := (bLon-lon)*k, bLat-lat
+	dx, dy := bx-ax, by-ay
+
+	if l2 := dx*dx + dy*dy; l2 > 0 {
+		frac = math.Max(0, math.Min(1, -(ax*dx+ay*dy)/l2))
+	}
+	return frac, Distance(lat, lon, aLat+frac*(bLat-aLat), aLon+frac*(bLon-aLon))
+}
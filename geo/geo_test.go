@@ -0,0 +1,43 @@
+package geo_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/jaggu/vehicle-tracker-prototype/geo"
+)
+
+func TestDistance(t *testing.T) {
+	for _, tc := range []struct {
+		name                   string
+		lat1, lon1, lat2, lon2 float64
+		want                   float64
+	}{
+		{"same point", 17.385, 78.4867, 17.385, 78.4867, 0},
+		{"one degree of latitude", 0, 0, 1, 0, 111195},
+		{"Hyderabad to Secunderabad", 17.3850, 78.4867, 17.4399, 78.4983, 6235},
+	} {
+		got := geo.Distance(tc.lat1, tc.lon1, tc.lat2, tc.lon2)
+		if math.Abs(got-tc.want) > 0.005*tc.want+1 {
+			t.Errorf("%s: Distance = %.0f m, want about %.0f m", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestProject(t *testing.T) {
+	// A segment 0.01° of longitude long on the equator, about 1.1 km.
+	for _, tc := range []struct {
+		name       string
+		lat, lon   float64
+		frac, dist float64
+	}{
+		{"beside the middle", 0.001, 0.005, 0.5, 111},
+		{"before the start", 0, -0.002, 0, 222},
+		{"past the end", 0, 0.011, 1, 111},
+	} {
+		frac, dist := geo.Project(tc.lat, tc.lon, 0, 0, 0, 0.01)
+		if math.Abs(frac-tc.frac) > 0.01 || math.Abs(dist-tc.dist) > 1 {
+			t.Errorf("%s: Project = %.3f, %.0f m; want %.3f, %.0f m", tc.name, frac, dist, tc.frac, tc.dist)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+// Package gtfs loads an agency's GTFS static schedule — routes, trips,
+// stops, stop times, shapes and service calendars — into indexed
+// in-memory structures.
+//
+// A Feed is immutable once loaded.  Schedule holds the current Feed of a
+// file on disk and swaps in a new one when the file changes, so readers
+// take Schedule.Feed once per request and use it throughout.
+//
+// The format follows https://gtfs.org/documentation/schedule/reference/.
+package gtfs
+
+import "time"
+
+// Agency is a row of agency.txt.
+type Agency struct {
+	ID       string
+	Name     string
+	Timezone string
+}
+
+// Route is a row of routes.txt.
+type Route struct {
+	ID        string
+	AgencyID  string
+	ShortName string
+	LongName  string
+	Type      int
+}
+
+// Trip is a row of trips.txt.
+type Trip struct {
+	ID          string
+	RouteID     string
+	ServiceID   string
+	ShapeID     string
+	Headsign    string
+	DirectionID int
+}
+
+// Stop is a row of stops.txt.
+type Stop struct {
+	ID   string
+	Name string
+	Lat  float64
+	Lon  float64
+}
+
+// StopTime is a row of stop_times.txt.  Arrival and Departure are
+// seconds since the start of the service day and may exceed 24 hours
+// for trips that run past midnight.  A stop with only one of the two
+// times given uses it for both; one with neither (an untimed stop
+// between timepoints) has -1 for both.
+type StopTime struct {
+	StopID            string
+	StopSequence      int
+	Arrival           int
+	Departure         int
+	ShapeDistTraveled float64 // 0 when not given
+}
+
+// ShapePoint is a row of shapes.txt.
+type ShapePoint struct {
+	Lat          float64
+	Lon          float64
+	Sequence     int
+	DistTraveled float64 // in the feed's own units; 0 when not given
+
+	// Along is the distance in meters from the shape's first point,
+	// computed at load time.
+	Along float64
+}
+
+// Service is a service_id's calendar: its weekly pattern from
+// calendar.txt and the exceptions from calendar_dates.txt.  Dates are
+// YYYYMMDD strings, which order correctly as strings.
+type Service struct {
+	ID        string
+	Weekdays  [7]bool // indexed by time.Weekday
+	StartDate string
+	EndDate   string
+	Added     map[string]bool
+	Removed   map[string]bool
+}
+
+// Frequency is a row of frequencies.txt: the trip runs every Headway
+// seconds from Start until End, in seconds since the start of the
+// service day.
+type Frequency struct {
+	Start      int
+	End        int
+	Headway    int
+	ExactTimes bool
+}
+
+// Feed is a loaded GTFS schedule.  Its fields are indexes built at load
+// time and must not be modified.
+type Feed struct {
+	Agencies    []Agency
+	Routes      map[string]*Route
+	Trips       map[string]*Trip
+	Stops       map[string]*Stop
+	Services    map[string]*Service
+	StopTimes   map[string][]StopTime   // by trip ID, in stop_sequence order
+	Shapes      map[string][]ShapePoint // by shape ID, in sequence order
+	Frequencies map[string][]Frequency  // by trip ID
+
+	// RouteTrips lists the trips of each route, ordered by trip ID.
+	RouteTrips map[string][]*Trip
+
+	zones map[string]*time.Location // by agency ID
+}
+
+// Knows reports whether tripID and routeID, each of which may be empty,
+// are in the feed and the trip runs on the route.
+func (f *Feed) Knows(tripID, routeID string) bool {
+	if _, ok := f.Routes[routeID]; routeID != "" && !ok {
+		return false
+	}
+	if tripID == "" {
+		return true
+	}
+	trip, ok := f.Trips[tripID]
+	return ok && (routeID == "" || trip.RouteID == routeID)
+}
+
+// ServiceActive reports whether serviceID runs on the service day date
+// (only its year, month and day are used).
+func (f *Feed) ServiceActive(serviceID string, date time.Time) bool {
+	svc, ok := f.Services[serviceID]
+	if !ok {
+		return false
+	}
+	day := date.Format("20060102")
+	switch {
+	case svc.Removed[day]:
+		return false
+	case svc.Added[day]:
+		return true
+	}
+	return svc.StartDate != "" && svc.StartDate <= day && day <= svc.EndDate &&
+		svc.Weekdays[date.Weekday()]
+}
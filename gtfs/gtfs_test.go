@@ -0,0 +1,189 @@
+package gtfs_test
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jaggu/vehicle-tracker-prototype/clock"
+	"github.com/jaggu/vehicle-tracker-prototype/gtfs"
+)
+
+// fixture is an unzipped feed: one route running out to Westlands and
+// back along four stops, on weekdays.
+const fixture = "testdata/kbs"
+
+// writeZip zips the fixture into dir, replacing files with the given
+// contents, and returns the zip's path.
+func writeZip(t *testing.T, dir string, replace map[string]string) string {
+	t.Helper()
+	path := filepath.Join(dir, "gtfs.zip")
+	out, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(out)
+	entries, _ := os.ReadDir(fixture)
+	for _, e := range entries {
+		data, err := os.ReadFile(filepath.Join(fixture, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r, ok := replace[e.Name()]; ok {
+			data = []byte(r)
+		}
+		w, _ := zw.Create(e.Name())
+		w.Write(data)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	out.Close()
+	return path
+}
+
+func TestLoad_IndexesFeed(t *testing.T) {
+	for _, path := range []string{fixture, writeZip(t, t.TempDir(), nil)} {
+		f, err := gtfs.Load(path)
+		if err != nil {
+			t.Fatalf("Load(%s): %v", path, err)
+		}
+
+		if len(f.Agencies) != 1 || f.Agencies[0].Timezone != "Africa/Nairobi" {
+			t.Errorf("%s: agencies = %+v", path, f.Agencies)
+		}
+		if trip := f.Trips["5_0830_in"]; trip == nil || trip.RouteID != "5" || trip.DirectionID != 1 || trip.ShapeID != "shp_5_in" {
+			t.Errorf("%s: trip 5_0830_in = %+v", path, trip)
+		}
+		if got := len(f.RouteTrips["5"]); got != 6 {
+			t.Errorf("%s: route 5 has %d trips, want 6", path, got)
+		}
+
+		sts := f.StopTimes["5_2350_out"]
+		if len(sts) != 4 || sts[0].StopID != "S1" || sts[3].Arrival != 24*3600+5*60 {
+			t.Errorf("%s: stop times of the overnight trip = %+v", path, sts)
+		}
+		if pts := f.Shapes["shp_5_in"]; len(pts) != 4 || pts[0].Lon != 36.83 {
+			t.Errorf("%s: shape shp_5_in = %+v", path, pts)
+		}
+		if fr := f.Frequencies["5_freq_out"]; len(fr) != 1 || fr[0].Headway != 600 || !fr[0].ExactTimes {
+			t.Errorf("%s: frequencies = %+v", path, fr)
+		}
+	}
+}
+
+func TestLoad_RejectsDanglingReferences(t *testing.T) {
+	path := writeZip(t, t.TempDir(), map[string]string{
+		"stop_times.txt": "trip_id,arrival_time,departure_time,stop_id,stop_sequence\n5_0800_out,08:00:00,08:00:00,S9,1\n",
+	})
+	_, err := gtfs.Load(path)
+	if err == nil || !strings.Contains(err.Error(), "unknown stop S9") {
+		t.Errorf("Load with an unknown stop: err = %v", err)
+	}
+}
+
+func TestFeed_ServiceActive(t *testing.T) {
+	f, err := gtfs.Load(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		date string
+		want bool
+	}{
+		{"2026-07-15", true},  // Wednesday
+		{"2026-07-18", false}, // Saturday
+		{"2026-12-25", false}, // Friday, removed by calendar_dates
+		{"2026-12-26", true},  // Saturday, added by calendar_dates
+		{"2028-01-05", false}, // after end_date
+	} {
+		day, _ := time.Parse("2006-01-02", tc.date)
+		if got := f.ServiceActive("WKDY", day); got != tc.want {
+			t.Errorf("ServiceActive(WKDY, %s) = %v, want %v", tc.date, got, tc.want)
+		}
+	}
+}
+
+func TestFeed_Knows(t *testing.T) {
+	f, err := gtfs.Load(fixture)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	for _, tc := range []struct {
+		trip, route string
+		want        bool
+	}{
+		{"5_0800_out", "5", true},
+		{"5_0800_out", "", true},
+		{"", "5", true},
+		{"", "", true},
+		{"5_0800_typo", "5", false},
+		{"", "99", false},
+		{"5_0800_out", "99", false},
+	} {
+		if got := f.Knows(tc.trip, tc.route); got != tc.want {
+			t.Errorf("Knows(%q, %q) = %v, want %v", tc.trip, tc.route, got, tc.want)
+		}
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	for in, want := range map[string]int{"08:05:30": 29130, "7:00:00": 25200, "25:10:00": 90600} {
+		if got, err := gtfs.ParseTime(in); err != nil || got != want {
+			t.Errorf("ParseTime(%q) = %d, %v; want %d", in, got, err, want)
+		}
+		if in != "7:00:00" && gtfs.FormatTime(want) != in {
+			t.Errorf("FormatTime(%d) = %q, want %q", want, gtfs.FormatTime(want), in)
+		}
+	}
+	if _, err := gtfs.ParseTime("08:61:00"); err == nil {
+		t.Error("ParseTime accepted minute 61")
+	}
+}
+
+func TestSchedule_ReloadsChangedFile(t *testing.T) {
+	dir := t.TempDir()
+	path := writeZip(t, dir, nil)
+	clk := clock.NewFake(time.Unix(1752566400, 0))
+	s, err := gtfs.OpenSchedule(path, clk, 0)
+	if err != nil {
+		t.Fatalf("OpenSchedule: %v", err)
+	}
+	defer s.Close()
+	var reloaded []*gtfs.Feed
+	s.OnReload(func(f *gtfs.Feed) { reloaded = append(reloaded, f) })
+
+	if changed, err := s.Reload(); changed || err != nil {
+		t.Errorf("Reload of an unchanged file = %v, %v", changed, err)
+	}
+
+	// A new route appears once the zip is replaced.
+	routes := "route_id,agency_id,route_short_name,route_type\n5,kbs,5,3\n7,kbs,7,3\n"
+	writeZip(t, dir, map[string]string{"routes.txt": routes})
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(path, later, later)
+	if changed, err := s.Reload(); !changed || err != nil {
+		t.Fatalf("Reload of a changed file = %v, %v", changed, err)
+	}
+	if _, ok := s.Feed().Routes["7"]; !ok {
+		t.Error("reloaded schedule is missing route 7")
+	}
+	if len(reloaded) != 1 || reloaded[0] != s.Feed() {
+		t.Errorf("OnReload called with %v, want the new feed once", reloaded)
+	}
+
+	// A broken upload keeps the previous schedule.
+	os.WriteFile(path, []byte("not a zip"), 0o644)
+	if _, err := s.Reload(); err == nil {
+		t.Error("Reload of a broken file succeeded")
+	}
+	if _, ok := s.Feed().Routes["7"]; !ok {
+		t.Error("failed reload replaced the schedule")
+	}
+	if len(reloaded) != 1 {
+		t.Errorf("OnReload called %d times, want once", len(reloaded))
+	}
+}
@@ -0,0 +1,172 @@
+package gtfs
+
+import (
+	"fmt"
+	"time"
+)
+
+// TripInstance is one run of a trip on a particular service day.
+type TripInstance struct {
+	Trip *Trip
+
+	// ServiceDay is the start of the service day ("noon minus 12h") in
+	// the agency's time zone.  Stop times count from it.
+	ServiceDay time.Time
+
+	// Start is when this run leaves its first stop, in seconds since
+	// ServiceDay.  For trips in frequencies.txt it is the run's actual or
+	// exact-times start rather than the template time in stop_times.txt.
+	Start int
+
+	// Frequency is the frequencies.txt window the run belongs to, or nil
+	// for a trip with fixed stop times.
+	Frequency *Frequency
+}
+
+// StartDate returns the service day as a GTFS YYYYMMDD date.
+func (ti TripInstance) StartDate() string {
+	return ti.ServiceDay.Format("20060102")
+}
+
+// StartTime returns Start as a GTFS HH:MM:SS time.
+func (ti TripInstance) StartTime() string {
+	return FormatTime(ti.Start)
+}
+
+// At returns the wall-clock time of secs since the service day.
+func (ti TripInstance) At(secs int) time.Time {
+	return ti.ServiceDay.Add(time.Duration(secs) * time.Second)
+}
+
+// Location returns the time zone of the agency running routeID: the
+// route's agency, or the feed's only agency when the route names none.
+// It is UTC for a feed without agency.txt.
+func (f *Feed) Location(routeID string) *time.Location {
+	var agencyID string
+	if r, ok := f.Routes[routeID]; ok {
+		agencyID = r.AgencyID
+	}
+	if loc, ok := f.zones[agencyID]; ok {
+		return loc
+	}
+	if len(f.Agencies) > 0 {
+		return f.zones[f.Agencies[0].ID]
+	}
+	return time.UTC
+}
+
+// AgencyID returns the agency running routeID: the route's agency, or
+// the feed's only agency when the route names none.  It is "" for an
+// unknown route or when the agency can't be told.
+func (f *Feed) AgencyID(routeID string) string {
+	r, ok := f.Routes[routeID]
+	switch {
+	case !ok:
+		return ""
+	case r.AgencyID != "":
+		return r.AgencyID
+	case len(f.Agencies) == 1:
+		return f.Agencies[0].ID
+	}
+	return ""
+}
+
+// ServiceDay returns the start of the service day date ("noon minus
+// 12h"), in loc, which is what GTFS stop times count from.  On days when
+// the clocks change it differs from midnight.
+func ServiceDay(year int, month time.Month, day int, loc *time.Location) time.Time {
+	return time.Date(year, month, day, 12, 0, 0, 0, loc).Add(-12 * time.Hour)
+}
+
+// MaxInstanceSkew is how far outside a run's scheduled span, from leaving
+// its first stop to reaching its last, a time may be and still belong to
+// that run.
+const MaxInstanceSkew = 3 * time.Hour
+
+// Instance finds the run of tripID closest to at: the service day, among
+// the day of at and the days either side, on which the trip runs and
+// whose start is nearest at.  For trips in frequencies.txt at should be
+// when the run started; the start is taken from it, snapped to the
+// headway for exact_times trips.
+//
+// It returns false if the trip is not in the schedule or has no run
+// within MaxInstanceSkew of at.
+func (f *Feed) Instance(tripID string, at time.Time) (TripInstance, bool) {
+	trip, ok := f.Trips[tripID]
+	if !ok {
+		return TripInstance{}, false
+	}
+	sts := f.StopTimes[tripID]
+	first := sts[0].Departure
+	duration := time.Duration(sts[len(sts)-1].Arrival-first) * time.Second
+	local := at.In(f.Location(trip.RouteID))
+
+	var best TripInstance
+	var bestDiff time.Duration = -1
+	for d := -1; d <= 1; d++ {
+		date := local.AddDate(0, 0, d)
+		day := ServiceDay(date.Year(), date.Month(), date.Day(), local.Location())
+		if !f.ServiceActive(trip.ServiceID, day) {
+			continue
+		}
+		candidates := []TripInstance{{Trip: trip, ServiceDay: day, Start: first}}
+		if freqs := f.Frequencies[tripID]; len(freqs) > 0 {
+			candidates = candidates[:0]
+			offset := int(at.Sub(day) / time.Second)
+			for i := range freqs {
+				fr := &freqs[i]
+				candidates = append(candidates, TripInstance{
+					Trip: trip, ServiceDay: day, Start: fr.startNear(offset), Frequency: fr,
+				})
+			}
+		}
+		for _, c := range candidates {
+			start := c.At(c.Start)
+			if at.Before(start.Add(-MaxInstanceSkew)) || at.After(start.Add(duration+MaxInstanceSkew)) {
+				continue
+			}
+			diff := start.Sub(at)
+			if diff < 0 {
+				diff = -diff
+			}
+			if bestDiff < 0 || diff < bestDiff {
+				best, bestDiff = c, diff
+			}
+		}
+	}
+	return best, bestDiff >= 0
+}
+
+// startNear returns the start within the window closest to offset
+// seconds since the service day: a multiple of the headway from Start for
+// exact_times windows, offset itself (clamped to the window) otherwise.
+func (fr *Frequency) startNear(offset int) int {
+	last := fr.End
+	if fr.ExactTimes {
+		// Runs start at Start + k*Headway, strictly before End.
+		last = fr.Start + (fr.End-fr.Start-1)/fr.Headway*fr.Headway
+	}
+	switch {
+	case offset <= fr.Start:
+		return fr.Start
+	case offset >= last:
+		return last
+	case fr.ExactTimes:
+		k := (offset - fr.Start + fr.Headway/2) / fr.Headway
+		return fr.Start + k*fr.Headway
+	}
+	return offset
+}
+
+// loadZones resolves the time zone of every agency.
+func (f *Feed) loadZones() error {
+	f.zones = make(map[string]*time.Location, len(f.Agencies))
+	for _, a := range f.Agencies {
+		loc, err := time.LoadLocation(a.Timezone)
+		if err != nil || a.Timezone == "" {
+			return fmt.Errorf("agency.txt: agency %q: unknown agency_timezone %q", a.ID, a.Timezone)
+		}
+		f.zones[a.ID] = loc
+	}
+	return nil
+}
@@ -0,0 +1,447 @@
+package gtfs
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jaggu/vehicle-tracker-prototype/geo"
+)
+
+// Load reads the GTFS feed at path, which is either a zip archive or a
+// directory holding the unzipped .txt files.
+//
+// routes.txt, trips.txt, stops.txt and stop_times.txt are required; the
+// other files are optional.  Rows referring to unknown routes, trips,
+// stops or services are errors, so a broken feed is never half-loaded.
+func Load(path string) (*Feed, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	var fsys fs.FS
+	if info.IsDir() {
+		fsys = os.DirFS(path)
+	} else {
+		zr, err := zip.OpenReader(path)
+		if err != nil {
+			return nil, fmt.Errorf("gtfs: open %s: %w", path, err)
+		}
+		defer zr.Close()
+		fsys = zr
+	}
+
+	f, err := load(fsys)
+	if err != nil {
+		return nil, fmt.Errorf("gtfs: %s: %w", path, err)
+	}
+	return f, nil
+}
+
+// load parses the feed files in fsys, in dependency order.
+func load(fsys fs.FS) (*Feed, error) {
+	f := &Feed{
+		Routes:      make(map[string]*Route),
+		Trips:       make(map[string]*Trip),
+		Stops:       make(map[string]*Stop),
+		Services:    make(map[string]*Service),
+		StopTimes:   make(map[string][]StopTime),
+		Shapes:      make(map[string][]ShapePoint),
+		Frequencies: make(map[string][]Frequency),
+		RouteTrips:  make(map[string][]*Trip),
+	}
+	steps := []struct {
+		name     string
+		required bool
+		parse    func(row) error
+	}{
+		{"agency.txt", false, f.parseAgency},
+		{"routes.txt", true, f.parseRoute},
+		{"stops.txt", true, f.parseStop},
+		{"calendar.txt", false, f.parseCalendar},
+		{"calendar_dates.txt", false, f.parseCalendarDate},
+		{"shapes.txt", false, f.parseShapePoint},
+		{"trips.txt", true, f.parseTrip},
+		{"stop_times.txt", true, f.parseStopTime},
+		{"frequencies.txt", false, f.parseFrequency},
+	}
+	for _, step := range steps {
+		if err := readTable(fsys, step.name, step.required, step.parse); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := f.loadZones(); err != nil {
+		return nil, err
+	}
+	for _, sts := range f.StopTimes {
+		sort.Slice(sts, func(i, j int) bool { return sts[i].StopSequence < sts[j].StopSequence })
+	}
+	for _, pts := range f.Shapes {
+		sort.Slice(pts, func(i, j int) bool { return pts[i].Sequence < pts[j].Sequence })
+		for i := 1; i < len(pts); i++ {
+			pts[i].Along = pts[i-1].Along + geo.Distance(pts[i-1].Lat, pts[i-1].Lon, pts[i].Lat, pts[i].Lon)
+		}
+	}
+	for _, trips := range f.RouteTrips {
+		sort.Slice(trips, func(i, j int) bool { return trips[i].ID < trips[j].ID })
+	}
+	for id := range f.Trips {
+		if len(f.StopTimes[id]) == 0 {
+			return nil, fmt.Errorf("trips.txt: trip %s has no stop times", id)
+		}
+	}
+	return f, nil
+}
+
+// row is one CSV record together with its file's header.
+type row struct {
+	cols   map[string]int
+	fields []string
+}
+
+// str returns the trimmed value of column name, or "" if the file has no
+// such column.
+func (r row) str(name string) string {
+	i, ok := r.cols[name]
+	if !ok || i >= len(r.fields) {
+		return ""
+	}
+	return strings.TrimSpace(r.fields[i])
+}
+
+// required returns column name, failing when it is empty.
+func (r row) required(name string) (string, error) {
+	v := r.str(name)
+	if v == "" {
+		return "", fmt.Errorf("%s is required", name)
+	}
+	return v, nil
+}
+
+// int parses column name, using def when it is empty.
+func (r row) int(name string, def int) (int, error) {
+	v := r.str(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %q is not an integer", name, v)
+	}
+	return n, nil
+}
+
+// float parses column name, using def when it is empty.
+func (r row) float(name string, def float64) (float64, error) {
+	v := r.str(name)
+	if v == "" {
+		return def, nil
+	}
+	x, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %q is not a number", name, v)
+	}
+	return x, nil
+}
+
+// time parses column name as HH:MM:SS, returning -1 when it is empty.
+func (r row) time(name string) (int, error) {
+	v := r.str(name)
+	if v == "" {
+		return -1, nil
+	}
+	secs, err := ParseTime(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", name, err)
+	}
+	return secs, nil
+}
+
+// ParseTime parses a GTFS HH:MM:SS time into seconds since the start of
+// the service day.  Hours may exceed 23, and a single-digit hour is
+// accepted.
+func ParseTime(s string) (int, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("%q is not an HH:MM:SS time", s)
+	}
+	var n [3]int
+	for i, p := range parts {
+		v, err := strconv.Atoi(p)
+		if err != nil || v < 0 || (i > 0 && v > 59) {
+			return 0, fmt.Errorf("%q is not an HH:MM:SS time", s)
+		}
+		n[i] = v
+	}
+	return n[0]*3600 + n[1]*60 + n[2], nil
+}
+
+// FormatTime formats seconds since the start of the service day as a
+// GTFS HH:MM:SS time.
+func FormatTime(secs int) string {
+	return fmt.Sprintf("%02d:%02d:%02d", secs/3600, secs/60%60, secs%60)
+}
+
+// readTable calls parse for every record of the named file.  A missing
+// optional file is skipped.
+func readTable(fsys fs.FS, name string, required bool, parse func(row) error) error {
+	file, err := fsys.Open(name)
+	if errors.Is(err, fs.ErrNotExist) && !required {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	cr := csv.NewReader(file)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	cols := make(map[string]int, len(header))
+	for i, h := range header {
+		if i == 0 {
+			h = strings.TrimPrefix(h, "\ufeff") // byte order mark
+		}
+		cols[strings.TrimSpace(h)] = i
+	}
+
+	for {
+		fields, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if err := parse(row{cols: cols, fields: fields}); err != nil {
+			line, _ := cr.FieldPos(0)
+			return fmt.Errorf("%s line %d: %w", name, line, err)
+		}
+	}
+}
+
+func (f *Feed) parseAgency(r row) error {
+	f.Agencies = append(f.Agencies, Agency{
+		ID:       r.str("agency_id"),
+		Name:     r.str("agency_name"),
+		Timezone: r.str("agency_timezone"),
+	})
+	return nil
+}
+
+func (f *Feed) parseRoute(r row) error {
+	id, err := r.required("route_id")
+	if err != nil {
+		return err
+	}
+	typ, err := r.int("route_type", 3)
+	if err != nil {
+		return err
+	}
+	f.Routes[id] = &Route{
+		ID:        id,
+		AgencyID:  r.str("agency_id"),
+		ShortName: r.str("route_short_name"),
+		LongName:  r.str("route_long_name"),
+		Type:      typ,
+	}
+	return nil
+}
+
+func (f *Feed) parseStop(r row) error {
+	id, err := r.required("stop_id")
+	if err != nil {
+		return err
+	}
+	lat, err := r.float("stop_lat", 0)
+	if err != nil {
+		return err
+	}
+	lon, err := r.float("stop_lon", 0)
+	if err != nil {
+		return err
+	}
+	f.Stops[id] = &Stop{ID: id, Name: r.str("stop_name"), Lat: lat, Lon: lon}
+	return nil
+}
+
+// service returns the Service for id, creating it on first use.
+func (f *Feed) service(id string) *Service {
+	svc, ok := f.Services[id]
+	if !ok {
+		svc = &Service{ID: id, Added: make(map[string]bool), Removed: make(map[string]bool)}
+		f.Services[id] = svc
+	}
+	return svc
+}
+
+func (f *Feed) parseCalendar(r row) error {
+	id, err := r.required("service_id")
+	if err != nil {
+		return err
+	}
+	svc := f.service(id)
+	days := [7]string{"sunday", "monday", "tuesday", "wednesday", "thursday", "friday", "saturday"}
+	for i, day := range days {
+		svc.Weekdays[i] = r.str(day) == "1"
+	}
+	svc.StartDate, svc.EndDate = r.str("start_date"), r.str("end_date")
+	return nil
+}
+
+func (f *Feed) parseCalendarDate(r row) error {
+	id, err := r.required("service_id")
+	if err != nil {
+		return err
+	}
+	date, err := r.required("date")
+	if err != nil {
+		return err
+	}
+	svc := f.service(id)
+	switch r.str("exception_type") {
+	case "1":
+		svc.Added[date] = true
+	case "2":
+		svc.Removed[date] = true
+	default:
+		return fmt.Errorf("exception_type must be 1 or 2")
+	}
+	return nil
+}
+
+func (f *Feed) parseShapePoint(r row) error {
+	id, err := r.required("shape_id")
+	if err != nil {
+		return err
+	}
+	var pt ShapePoint
+	if pt.Lat, err = r.float("shape_pt_lat", 0); err != nil {
+		return err
+	}
+	if pt.Lon, err = r.float("shape_pt_lon", 0); err != nil {
+		return err
+	}
+	if pt.Sequence, err = r.int("shape_pt_sequence", 0); err != nil {
+		return err
+	}
+	if pt.DistTraveled, err = r.float("shape_dist_traveled", 0); err != nil {
+		return err
+	}
+	f.Shapes[id] = append(f.Shapes[id], pt)
+	return nil
+}
+
+func (f *Feed) parseTrip(r row) error {
+	id, err := r.required("trip_id")
+	if err != nil {
+		return err
+	}
+	routeID, err := r.required("route_id")
+	if err != nil {
+		return err
+	}
+	if _, ok := f.Routes[routeID]; !ok {
+		return fmt.Errorf("trip %s: unknown route %s", id, routeID)
+	}
+	serviceID := r.str("service_id")
+	if _, ok := f.Services[serviceID]; !ok && len(f.Services) > 0 {
+		return fmt.Errorf("trip %s: unknown service %s", id, serviceID)
+	}
+	shapeID := r.str("shape_id")
+	if _, ok := f.Shapes[shapeID]; shapeID != "" && !ok {
+		return fmt.Errorf("trip %s: unknown shape %s", id, shapeID)
+	}
+	dir, err := r.int("direction_id", 0)
+	if err != nil {
+		return err
+	}
+	t := &Trip{
+		ID:          id,
+		RouteID:     routeID,
+		ServiceID:   serviceID,
+		ShapeID:     shapeID,
+		Headsign:    r.str("trip_headsign"),
+		DirectionID: dir,
+	}
+	f.Trips[id] = t
+	f.RouteTrips[routeID] = append(f.RouteTrips[routeID], t)
+	return nil
+}
+
+func (f *Feed) parseStopTime(r row) error {
+	tripID, err := r.required("trip_id")
+	if err != nil {
+		return err
+	}
+	if _, ok := f.Trips[tripID]; !ok {
+		return fmt.Errorf("unknown trip %s", tripID)
+	}
+	var st StopTime
+	if st.StopID, err = r.required("stop_id"); err != nil {
+		return err
+	}
+	if _, ok := f.Stops[st.StopID]; !ok {
+		return fmt.Errorf("unknown stop %s", st.StopID)
+	}
+	if st.StopSequence, err = r.int("stop_sequence", 0); err != nil {
+		return err
+	}
+	if st.Arrival, err = r.time("arrival_time"); err != nil {
+		return err
+	}
+	if st.Departure, err = r.time("departure_time"); err != nil {
+		return err
+	}
+	if st.Arrival < 0 {
+		st.Arrival = st.Departure
+	}
+	if st.Departure < 0 {
+		st.Departure = st.Arrival
+	}
+	if st.ShapeDistTraveled, err = r.float("shape_dist_traveled", 0); err != nil {
+		return err
+	}
+	f.StopTimes[tripID] = append(f.StopTimes[tripID], st)
+	return nil
+}
+
+func (f *Feed) parseFrequency(r row) error {
+	tripID, err := r.required("trip_id")
+	if err != nil {
+		return err
+	}
+	if _, ok := f.Trips[tripID]; !ok {
+		return fmt.Errorf("unknown trip %s", tripID)
+	}
+	var fr Frequency
+	if fr.Start, err = r.time("start_time"); err != nil {
+		return err
+	}
+	if fr.End, err = r.time("end_time"); err != nil {
+		return err
+	}
+	if fr.Headway, err = r.int("headway_secs", 0); err != nil {
+		return err
+	}
+	if fr.Start < 0 || fr.End < 0 || fr.Headway <= 0 {
+		return fmt.Errorf("start_time, end_time and a positive headway_secs are required")
+	}
+	fr.ExactTimes = r.str("exact_times") == "1"
+	f.Frequencies[tripID] = append(f.Frequencies[tripID], fr)
+	return nil
+}
@@ -0,0 +1,113 @@
+package gtfs
+
+import (
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jaggu/vehicle-tracker-prototype/clock"
+)
+
+// DefaultReloadInterval is how often a Schedule checks its file for
+// changes.
+const DefaultReloadInterval = time.Minute
+
+// Schedule holds the Feed loaded from a file and reloads it when the
+// file's modification time or size changes.  A reload that fails keeps
+// serving the previous Feed, so an agency can upload a broken zip
+// without taking validation down.
+type Schedule struct {
+	path  string
+	clock clock.Clock
+
+	feed atomic.Pointer[Feed]
+
+	mu       sync.Mutex // serialises reloads
+	modTime  time.Time
+	size     int64
+	onReload []func(*Feed)
+
+	stop      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// OpenSchedule loads the feed at path and, when interval is positive,
+// starts a goroutine that checks for changes every interval.  Call
+// Close to stop it.
+func OpenSchedule(path string, clk clock.Clock, interval time.Duration) (*Schedule, error) {
+	s := &Schedule{path: path, clock: clk, stop: make(chan struct{})}
+	if _, err := s.Reload(); err != nil {
+		return nil, err
+	}
+	if interval > 0 {
+		ticker := clk.NewTicker(interval)
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C():
+					if _, err := s.Reload(); err != nil {
+						log.Printf("gtfs: reload failed, keeping the previous schedule: %v", err)
+					}
+				case <-s.stop:
+					return
+				}
+			}
+		}()
+	}
+	return s, nil
+}
+
+// Feed returns the current schedule.  Callers should take it once and
+// use it for the whole request, as a reload may swap it at any time.
+func (s *Schedule) Feed() *Feed {
+	return s.feed.Load()
+}
+
+// Reload loads the file again if it changed since the last load and
+// reports whether it did.  The background goroutine calls it once per
+// interval; it is exported so operators and tests can force a check.
+func (s *Schedule) Reload() (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return false, err
+	}
+	if s.feed.Load() != nil && info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return false, nil
+	}
+	f, err := Load(s.path)
+	if err != nil {
+		return false, err
+	}
+	s.feed.Store(f)
+	s.modTime, s.size = info.ModTime(), info.Size()
+	for _, fn := range s.onReload {
+		fn(f)
+	}
+	return true, nil
+}
+
+// OnReload registers fn to be called with the new Feed after every
+// reload that swaps it in.  fn runs on the reloading goroutine and must
+// not call Reload.
+func (s *Schedule) OnReload(fn func(*Feed)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onReload = append(s.onReload, fn)
+}
+
+// Close stops the reload goroutine.  It is safe to call more than once.
+func (s *Schedule) Close() {
+	s.closeOnce.Do(func() {
+		close(s.stop)
+		s.wg.Wait()
+	})
+}
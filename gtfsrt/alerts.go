@@ -0,0 +1,96 @@
+package gtfsrt
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/jaggu/vehicle-tracker-prototype/alerts"
+	pb "github.com/jaggu/vehicle-tracker-prototype/proto/gtfsrt"
+)
+
+// BuildAlerts creates a GTFS-RT FeedMessage of Alert entities from the
+// alerts in b.Alerts that have not expired.  Without b.Alerts the feed
+// is empty.
+func (b *Builder) BuildAlerts() *pb.FeedMessage {
+	return b.BuildCombined(nil, Selection{Kinds: []Kind{Alerts}})
+}
+
+// buildAlert converts an alert into a FeedEntity wrapping an Alert
+// message.
+func buildAlert(a alerts.Alert) *pb.FeedEntity {
+	id := fmt.Sprintf("alert-%s", a.ID)
+	msg := &pb.Alert{
+		HeaderText:      translated(a.Header),
+		DescriptionText: translated(a.Description),
+		Url:             translated(a.URL),
+	}
+	if v, ok := pb.Alert_Cause_value[a.Cause]; ok {
+		cause := pb.Alert_Cause(v)
+		msg.Cause = &cause
+	}
+	if v, ok := pb.Alert_Effect_value[a.Effect]; ok {
+		effect := pb.Alert_Effect(v)
+		msg.Effect = &effect
+	}
+
+	for _, p := range a.ActivePeriods {
+		tr := &pb.TimeRange{}
+		if p.Start != nil {
+			start := uint64(p.Start.Unix())
+			tr.Start = &start
+		}
+		if p.End != nil {
+			end := uint64(p.End.Unix())
+			tr.End = &end
+		}
+		msg.ActivePeriod = append(msg.ActivePeriod, tr)
+	}
+
+	for _, e := range a.InformedEntities {
+		sel := &pb.EntitySelector{}
+		if e.AgencyID != "" {
+			sel.AgencyId = &e.AgencyID
+		}
+		if e.RouteID != "" {
+			sel.RouteId = &e.RouteID
+		}
+		if e.StopID != "" {
+			sel.StopId = &e.StopID
+		}
+		if e.TripID != "" {
+			sel.Trip = &pb.TripDescriptor{TripId: &e.TripID}
+		}
+		msg.InformedEntity = append(msg.InformedEntity, sel)
+	}
+
+	return &pb.FeedEntity{
+		Id:    &id,
+		Alert: msg,
+	}
+}
+
+// translated converts t to a TranslatedString, ordered by language with
+// the untagged text first, or returns nil if t is empty.
+func translated(t alerts.Text) *pb.TranslatedString {
+	langs := make([]string, 0, len(t))
+	for lang, text := range t {
+		if text != "" {
+			langs = append(langs, lang)
+		}
+	}
+	if len(langs) == 0 {
+		return nil
+	}
+	sort.Strings(langs)
+
+	ts := &pb.TranslatedString{}
+	for _, lang := range langs {
+		text := t[lang]
+		tr := &pb.TranslatedString_Translation{Text: &text}
+		if lang != "" {
+			tr.Language = &lang
+		}
+		ts.Translation = append(ts.Translation, tr)
+	}
+	return ts
+}
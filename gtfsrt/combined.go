@@ -0,0 +1,260 @@
+package gtfsrt
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/jaggu/vehicle-tracker-prototype/alerts"
+	"github.com/jaggu/vehicle-tracker-prototype/gtfs"
+	"github.com/jaggu/vehicle-tracker-prototype/model"
+	pb "github.com/jaggu/vehicle-tracker-prototype/proto/gtfsrt"
+)
+
+// Kind is a kind of feed entity.
+type Kind string
+
+// The entity kinds, named as in the combined feed's query parameters.
+const (
+	VehiclePositions Kind = "vehicle_positions"
+	TripUpdates      Kind = "trip_updates"
+	Alerts           Kind = "alerts"
+)
+
+// AllKinds lists every kind in the order the combined feed emits them.
+var AllKinds = []Kind{VehiclePositions, TripUpdates, Alerts}
+
+// ParseKinds parses a comma-separated list of kinds, such as
+// "vehicle_positions,alerts".
+func ParseKinds(list string) ([]Kind, error) {
+	var kinds []Kind
+	for _, name := range strings.Split(list, ",") {
+		k := Kind(strings.TrimSpace(name))
+		if k == "" {
+			continue
+		}
+		switch k {
+		case VehiclePositions, TripUpdates, Alerts:
+			kinds = append(kinds, k)
+		default:
+			return nil, fmt.Errorf("unknown entity kind %q", k)
+		}
+	}
+	return kinds, nil
+}
+
+// Selection narrows what a feed contains.  The zero Selection is every
+// kind of entity, unfiltered.
+type Selection struct {
+	// Kinds are the entity kinds included; nil means all of them, and
+	// an empty, non-nil list none.
+	Kinds []Kind
+
+	// RouteID, when set, keeps the vehicles and trip updates on that
+	// route, and the alerts naming the route, one of its trips, or the
+	// whole agency running it.
+	RouteID string
+
+	// Agency, when set, keeps the alerts about that agency: naming it,
+	// one of its routes or trips, or, when it is the only agency, a
+	// stop.  Vehicles are filtered by agency before they reach the
+	// Builder, as only the caller knows which agency runs them.
+	Agency string
+}
+
+// includes reports whether sel includes kind k.
+func (sel Selection) includes(k Kind) bool {
+	if sel.Kinds == nil {
+		return true
+	}
+	for _, kind := range sel.Kinds {
+		if kind == k {
+			return true
+		}
+	}
+	return false
+}
+
+// BuildCombined creates one FeedMessage holding the vehicle positions,
+// trip updates and alerts sel selects, in that order.  Each vehicle's
+// trip, position and stop are worked out once and shared by its
+// VehiclePosition and TripUpdate.
+//
+// Entity IDs are derived from what the entity describes, not its place
+// in the feed, so they stay the same from one request to the next:
+// "vehicle-<vehicle ID>", "trip-update-<vehicle ID>" and
+// "alert-<alert ID>".  The prefixes keep them unique across kinds.
+// Entities of each kind are ordered by vehicle ID, or for alerts by
+// creation.
+func (b *Builder) BuildCombined(locations []model.Location, sel Selection) *pb.FeedMessage {
+	now := b.now()
+	var entities []*pb.FeedEntity
+
+	var states []vehicleState
+	if sel.includes(VehiclePositions) || sel.includes(TripUpdates) {
+		states = b.resolve(locations, now)
+		if sel.RouteID != "" {
+			kept := states[:0]
+			for _, st := range states {
+				if st.routeID() == sel.RouteID {
+					kept = append(kept, st)
+				}
+			}
+			states = kept
+		}
+	}
+	if sel.includes(VehiclePositions) {
+		for _, st := range states {
+			entity := buildEntity(st.loc, st.reg, st.trip)
+			if st.hasStop {
+				setStop(entity.Vehicle, st.stop)
+			}
+			entities = append(entities, entity)
+		}
+	}
+	if sel.includes(TripUpdates) {
+		for _, st := range states {
+			if entity, ok := tripUpdateEntity(st, now); ok {
+				entities = append(entities, entity)
+			}
+		}
+	}
+	if sel.includes(Alerts) && b.Alerts != nil {
+		var sched *gtfs.Feed
+		if b.Schedule != nil {
+			sched = b.Schedule.Feed()
+		}
+		ra := b.routeAgencies(sched, locations)
+		for _, a := range b.Alerts.Current() {
+			if alertSelected(a, sel, sched, ra) {
+				entities = append(entities, buildAlert(a))
+			}
+		}
+	}
+
+	if entities == nil {
+		entities = []*pb.FeedEntity{}
+	}
+	return &pb.FeedMessage{
+		Header: header(now),
+		Entity: entities,
+	}
+}
+
+// routeID returns the route the vehicle is on: from its trip descriptor,
+// or, for a report naming only a trip, from the schedule.
+func (st vehicleState) routeID() string {
+	if id := st.trip.GetRouteId(); id != "" || !st.scheduled {
+		return id
+	}
+	return st.run.Trip.RouteID
+}
+
+// routeAgencies tells which agencies run each route, for selecting
+// alerts.  Routes in the schedule are run by the agency it names.
+// Without a schedule, or for routes it lacks, a route is run by the
+// agencies of the registered vehicles reporting on it.
+type routeAgencies struct {
+	sched    *gtfs.Feed
+	reported map[string][]string // route ID to agencies
+
+	// only is the one agency there is, in the schedule or, without
+	// one, among the registered vehicles; "" when there are several.
+	only string
+}
+
+// routeAgencies works out which agencies run which routes from sched,
+// or from b.Vehicles and the vehicles reporting in locations.
+func (b *Builder) routeAgencies(sched *gtfs.Feed, locations []model.Location) routeAgencies {
+	ra := routeAgencies{sched: sched, reported: make(map[string][]string)}
+	if sched != nil && len(sched.Agencies) == 1 {
+		ra.only = sched.Agencies[0].ID
+	}
+	if b.Vehicles == nil {
+		return ra
+	}
+	if sched == nil {
+		agencies := make(map[string]bool)
+		for _, v := range b.Vehicles.List() {
+			agencies[v.AgencyID] = true
+		}
+		if len(agencies) == 1 {
+			for id := range agencies {
+				ra.only = id
+			}
+		}
+	}
+	for _, loc := range locations {
+		route := loc.RouteID
+		if b.Trips != nil {
+			if t, ok := b.Trips.Active(loc.VehicleID); ok {
+				route = t.RouteID
+			}
+		}
+		agency := b.Vehicles.AgencyOf(loc.VehicleID)
+		if route != "" && agency != "" && !slices.Contains(ra.reported[route], agency) {
+			ra.reported[route] = append(ra.reported[route], agency)
+		}
+	}
+	return ra
+}
+
+// of returns the agencies running route, or, for "", the only agency.
+func (ra routeAgencies) of(route string) []string {
+	if route == "" {
+		if ra.only == "" {
+			return nil
+		}
+		return []string{ra.only}
+	}
+	if ra.sched != nil {
+		if id := ra.sched.AgencyID(route); id != "" {
+			return []string{id}
+		}
+	}
+	if ids := ra.reported[route]; len(ids) > 0 {
+		return ids
+	}
+	if ra.only != "" {
+		return []string{ra.only}
+	}
+	return nil
+}
+
+// alertSelected reports whether any of the alert's informed entities
+// matches sel's route and agency.  An entity naming only an agency
+// applies to all of that agency's routes.
+func alertSelected(a alerts.Alert, sel Selection, sched *gtfs.Feed, ra routeAgencies) bool {
+	if sel.RouteID == "" && sel.Agency == "" {
+		return true
+	}
+	for _, e := range a.InformedEntities {
+		route := e.RouteID
+		if route == "" && e.TripID != "" && sched != nil {
+			if trip, ok := sched.Trips[e.TripID]; ok {
+				route = trip.RouteID
+			}
+		}
+		agencyWide := e.AgencyID != "" && route == "" && e.TripID == "" && e.StopID == ""
+		if sel.RouteID != "" {
+			switch {
+			case agencyWide:
+				if !slices.Contains(ra.of(sel.RouteID), e.AgencyID) {
+					continue
+				}
+			case route != sel.RouteID:
+				continue
+			}
+		}
+
+		agencies := ra.of(route)
+		if e.AgencyID != "" {
+			agencies = []string{e.AgencyID}
+		}
+		if sel.Agency != "" && !slices.Contains(agencies, sel.Agency) {
+			continue
+		}
+		return true
+	}
+	return false
+}
@@ -1,61 +1,250 @@
-// Package gtfsrt converts in-memory vehicle locations into a valid
-// GTFS-Realtime Vehicle Positions FeedMessage.
+// Package gtfsrt converts in-memory vehicle locations into valid
+// GTFS-Realtime Vehicle Positions, Trip Updates and Alerts FeedMessages.
 //
 // The feed follows the official specification:
 // https://gtfs.org/documentation/realtime/proto/
 //
 // Key decisions:
-//   - incrementality is FULL_DATASET (every response contains all active vehicles)
-//   - only VehiclePosition entities are produced (no TripUpdates or Alerts)
+//   - incrementality is FULL_DATASET (every response contains all active
+//     vehicles), except on a Streamer, which sends DIFFERENTIAL updates
+//   - VehiclePosition, TripUpdate and Alert entities are produced, each
+//     in its own feed and together in a combined one
+//   - entity IDs are derived from the vehicle or alert, so they are
+//     stable across requests and unique across entity kinds
+//   - trip updates propagate the vehicle's current delay unchanged to
+//     the rest of its stops
 //   - vehicles that exceed the staleness threshold are excluded
 package gtfsrt
 
 import (
 	"fmt"
+	"sort"
 	"time"
 
+	"github.com/jaggu/vehicle-tracker-prototype/alerts"
+	"github.com/jaggu/vehicle-tracker-prototype/clock"
+	"github.com/jaggu/vehicle-tracker-prototype/gtfs"
+	"github.com/jaggu/vehicle-tracker-prototype/match"
 	"github.com/jaggu/vehicle-tracker-prototype/model"
 	pb "github.com/jaggu/vehicle-tracker-prototype/proto/gtfsrt"
+	"github.com/jaggu/vehicle-tracker-prototype/registry"
+	"github.com/jaggu/vehicle-tracker-prototype/trips"
 	"google.golang.org/protobuf/proto"
 )
 
 // gtfsRTVersion is the protocol version written into every FeedHeader.
 const gtfsRTVersion = "2.0"
 
+// Builder assembles GTFS-RT FeedMessages.  The zero value is ready to use
+// and stamps feeds with the real clock.
+type Builder struct {
+	// Clock supplies the FeedHeader timestamp; nil means clock.Real.
+	Clock clock.Clock
+
+	// Vehicles supplies VehicleDescriptor labels and license plates.
+	// Without it, or for unregistered vehicles, the label is the ID.
+	Vehicles *registry.Registry
+
+	// Trips supplies the active trip of each vehicle.  A vehicle running
+	// a trip is described by it rather than by the trip and route on its
+	// last report.
+	Trips *trips.Manager
+
+	// Schedule supplies each trip's start_date and start_time and
+	// whether it is in the static schedule.  Without it, trip
+	// descriptors carry only the trip and route IDs.
+	//
+	// Together with the Match a match.Placer recorded on a location, it
+	// also places the vehicle on its run: an inferred trip, stop_id,
+	// current_stop_sequence and current_status, and trip updates.  The
+	// builder only reads matches; it never matches fixes itself.
+	Schedule *gtfs.Schedule
+
+	// Snap publishes positions snapped onto the trip's shape instead of
+	// the raw GPS fix.  Fixes too far from the shape, as on a detour,
+	// are published as reported.
+	Snap bool
+
+	// Alerts supplies the service alerts of the Alerts feed.
+	Alerts *alerts.Manager
+}
+
+// now returns the current time according to the builder's clock.
+func (b *Builder) now() time.Time {
+	if b.Clock == nil {
+		return clock.Real.Now()
+	}
+	return b.Clock.Now()
+}
+
 // BuildFeed creates a complete GTFS-RT FeedMessage from a slice of active
+// vehicle locations, using a zero-value Builder.
+func BuildFeed(locations []model.Location) *pb.FeedMessage {
+	return (&Builder{}).Build(locations)
+}
+
+// Build creates a complete GTFS-RT FeedMessage from a slice of active
 // vehicle locations.
 //
 // Each location becomes a FeedEntity containing a VehiclePosition with
 // position (lat, lon, bearing, speed), trip descriptor, vehicle descriptor,
-// and timestamp.
-func BuildFeed(locations []model.Location) *pb.FeedMessage {
-	now := uint64(time.Now().Unix())
+// and timestamp.  The trip descriptor comes from the vehicle's active
+// trip when b.Trips has one, and otherwise from the location's match or
+// the report.
+func (b *Builder) Build(locations []model.Location) *pb.FeedMessage {
+	return b.BuildCombined(locations, Selection{Kinds: []Kind{VehiclePositions}})
+}
+
+// header returns a FULL_DATASET FeedHeader stamped with now.
+func header(now time.Time) *pb.FeedHeader {
+	nowUnix := uint64(now.Unix())
 	version := gtfsRTVersion
 	incrementality := pb.FeedHeader_FULL_DATASET
-
-	header := &pb.FeedHeader{
+	return &pb.FeedHeader{
 		GtfsRealtimeVersion: &version,
 		Incrementality:      &incrementality,
-		Timestamp:           &now,
+		Timestamp:           &nowUnix,
 	}
+}
 
-	entities := make([]*pb.FeedEntity, 0, len(locations))
+// vehicleState is what a feed knows about one vehicle: its location,
+// snapped when b.Snap is set, its registry entry, trip and stop.
+type vehicleState struct {
+	loc  *model.Location
+	reg  registry.Vehicle
+	trip *pb.TripDescriptor
+
+	// run is the scheduled run the vehicle is on, when scheduled is set.
+	run       gtfs.TripInstance
+	scheduled bool
+	sched     *gtfs.Feed
+
+	stop    match.StopMatch
+	hasStop bool
+}
+
+// resolve works out each vehicle's trip, position and stop, ordered by
+// vehicle ID, from the locations and the matches recorded on them.
+func (b *Builder) resolve(locations []model.Location, now time.Time) []vehicleState {
+	// Take the schedule once so a reload can't change it mid-feed.
+	var sched *gtfs.Feed
+	if b.Schedule != nil {
+		sched = b.Schedule.Feed()
+	}
 
+	states := make([]vehicleState, 0, len(locations))
 	for i := range locations {
 		loc := &locations[i]
-		entity := buildEntity(loc)
-		entities = append(entities, entity)
+
+		// The trip started at the active trip's start, or, for a trip
+		// sent with the report, around the time of the fix.  A match
+		// for the same trip knows the run's start; a trip started since
+		// the fix makes the match out of date.
+		startedAt := now
+		if loc.Timestamp > 0 {
+			startedAt = time.Unix(loc.Timestamp, 0)
+		}
+		m := loc.Match
+		if b.Trips != nil {
+			if t, ok := b.Trips.Active(loc.VehicleID); ok {
+				withTrip := *loc
+				withTrip.TripID, withTrip.RouteID = t.TripID, t.RouteID
+				loc = &withTrip
+				startedAt = t.StartedAt
+				if m != nil && m.TripID != t.TripID {
+					m = nil
+				}
+			}
+		}
+		if m != nil && sched != nil && (loc.TripID == "" || loc.TripID == m.TripID) {
+			withTrip := *loc
+			withTrip.TripID, withTrip.RouteID = m.TripID, m.RouteID
+			loc = &withTrip
+			startedAt = time.Unix(m.StartedAt, 0)
+		} else {
+			m = nil
+		}
+
+		st := vehicleState{sched: sched, loc: loc}
+		if b.Vehicles != nil {
+			st.reg, _ = b.Vehicles.Get(loc.VehicleID)
+		}
+		st.trip, st.run, st.scheduled = tripDescriptor(loc, sched, startedAt)
+		if st.scheduled {
+			st.stop, st.hasStop = match.PlacedStop(sched, m)
+		}
+		if st.scheduled && b.Snap && m != nil && m.Snapped {
+			snapped := *loc
+			snapped.Latitude, snapped.Longitude = m.SnappedLatitude, m.SnappedLongitude
+			st.loc = &snapped
+		}
+		states = append(states, st)
 	}
+	sort.Slice(states, func(i, j int) bool { return states[i].loc.VehicleID < states[j].loc.VehicleID })
+	return states
+}
 
-	return &pb.FeedMessage{
-		Header: header,
-		Entity: entities,
+// tripDescriptor describes the trip loc is running, or returns nil if it
+// carries no trip or route.  When the trip is a scheduled run it also
+// returns the run and true.
+//
+// With a schedule, a trip_id is located in it around startedAt.  A
+// scheduled run gets its start_date and start_time and is SCHEDULED, or
+// UNSCHEDULED if it is a frequency-based trip without exact times.  A
+// trip the schedule doesn't have, or that doesn't run that day, is ADDED,
+// with the start taken from startedAt in the agency's time zone.
+func tripDescriptor(loc *model.Location, sched *gtfs.Feed, startedAt time.Time) (*pb.TripDescriptor, gtfs.TripInstance, bool) {
+	if loc.TripID == "" && loc.RouteID == "" {
+		return nil, gtfs.TripInstance{}, false
+	}
+	td := &pb.TripDescriptor{}
+	if loc.TripID != "" {
+		td.TripId = &loc.TripID
+	}
+	if loc.RouteID != "" {
+		td.RouteId = &loc.RouteID
 	}
+	if sched == nil || loc.TripID == "" {
+		return td, gtfs.TripInstance{}, false
+	}
+
+	rel := pb.TripDescriptor_SCHEDULED
+	inst, ok := sched.Instance(loc.TripID, startedAt)
+	switch {
+	case !ok:
+		rel = pb.TripDescriptor_ADDED
+		local := startedAt.In(sched.Location(loc.RouteID))
+		day := gtfs.ServiceDay(local.Year(), local.Month(), local.Day(), local.Location())
+		inst = gtfs.TripInstance{ServiceDay: day, Start: int(startedAt.Sub(day) / time.Second)}
+	case inst.Frequency != nil && !inst.Frequency.ExactTimes:
+		rel = pb.TripDescriptor_UNSCHEDULED
+	}
+	startDate, startTime := inst.StartDate(), inst.StartTime()
+	td.StartDate = &startDate
+	td.StartTime = &startTime
+	td.ScheduleRelationship = &rel
+	return td, inst, ok
+}
+
+// setStop fills in the vehicle's stop from its stop match.
+func setStop(vp *pb.VehiclePosition, m match.StopMatch) {
+	seq := uint32(m.StopSequence)
+	status := pb.VehiclePosition_IN_TRANSIT_TO
+	switch m.Status {
+	case match.IncomingAt:
+		status = pb.VehiclePosition_INCOMING_AT
+	case match.StoppedAt:
+		status = pb.VehiclePosition_STOPPED_AT
+	}
+	vp.StopId = &m.StopID
+	vp.CurrentStopSequence = &seq
+	vp.CurrentStatus = &status
 }
 
 // buildEntity converts a single Location into a FeedEntity wrapping a
-// VehiclePosition message.
-func buildEntity(loc *model.Location) *pb.FeedEntity {
+// VehiclePosition message.  veh is the vehicle's registry entry, or the
+// zero Vehicle if it has none; trip is the trip descriptor, if any.
+func buildEntity(loc *model.Location, veh registry.Vehicle, trip *pb.TripDescriptor) *pb.FeedEntity {
 	id := fmt.Sprintf("vehicle-%s", loc.VehicleID)
 
 	lat := float32(loc.Latitude)
@@ -76,13 +265,20 @@ func buildEntity(loc *model.Location) *pb.FeedEntity {
 		position.Speed = &s
 	}
 
+	label := loc.VehicleID
+	if veh.Label != "" {
+		label = veh.Label
+	}
 	vp := &pb.VehiclePosition{
 		Position: position,
 		Vehicle: &pb.VehicleDescriptor{
 			Id:    &loc.VehicleID,
-			Label: &loc.VehicleID,
+			Label: &label,
 		},
 	}
+	if veh.LicensePlate != "" {
+		vp.Vehicle.LicensePlate = &veh.LicensePlate
+	}
 
 	// Attach timestamp if the client provided one.
 	if loc.Timestamp > 0 {
@@ -91,16 +287,7 @@ func buildEntity(loc *model.Location) *pb.FeedEntity {
 	}
 
 	// Attach trip information when available.
-	if loc.TripID != "" || loc.RouteID != "" {
-		td := &pb.TripDescriptor{}
-		if loc.TripID != "" {
-			td.TripId = &loc.TripID
-		}
-		if loc.RouteID != "" {
-			td.RouteId = &loc.RouteID
-		}
-		vp.Trip = td
-	}
+	vp.Trip = trip
 
 	return &pb.FeedEntity{
 		Id:      &id,
@@ -1,12 +1,21 @@
 package gtfsrt_test
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/jaggu/vehicle-tracker-prototype/alerts"
+	"github.com/jaggu/vehicle-tracker-prototype/clock"
+	"github.com/jaggu/vehicle-tracker-prototype/gtfs"
 	"github.com/jaggu/vehicle-tracker-prototype/gtfsrt"
+	"github.com/jaggu/vehicle-tracker-prototype/match"
 	"github.com/jaggu/vehicle-tracker-prototype/model"
 	pb "github.com/jaggu/vehicle-tracker-prototype/proto/gtfsrt"
+	"github.com/jaggu/vehicle-tracker-prototype/registry"
+	"github.com/jaggu/vehicle-tracker-prototype/trips"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -197,14 +206,453 @@ func TestMarshal_RoundTrip(t *testing.T) {
 }
 
 // TestFeedHeader_Timestamp verifies that the feed header timestamp is
-// reasonably close to the current time (within 5 seconds).
+// taken from the builder's clock.
 func TestFeedHeader_Timestamp(t *testing.T) {
-	before := uint64(time.Now().Unix())
-	feed := gtfsrt.BuildFeed([]model.Location{})
-	after := uint64(time.Now().Unix())
+	now := time.Date(2026, 7, 15, 8, 30, 0, 0, time.UTC)
+	clk := clock.NewFake(now)
+	b := &gtfsrt.Builder{Clock: clk}
+
+	feed := b.Build([]model.Location{})
+	if got, want := feed.Header.GetTimestamp(), uint64(now.Unix()); got != want {
+		t.Errorf("header timestamp = %d, want %d", got, want)
+	}
+
+	clk.Advance(30 * time.Second)
+	feed = b.Build([]model.Location{})
+	if got, want := feed.Header.GetTimestamp(), uint64(now.Unix()+30); got != want {
+		t.Errorf("header timestamp after advance = %d, want %d", got, want)
+	}
+}
+
+// TestBuild_RegistryLabels verifies that registered vehicles get their
+// label and license plate, and unregistered ones fall back to the ID.
+func TestBuild_RegistryLabels(t *testing.T) {
+	reg, _ := registry.Open("")
+	reg.Create(registry.Vehicle{ID: "bus-42", Label: "Route 5 Express", LicensePlate: "KCA 123A", Active: true})
+	b := &gtfsrt.Builder{Vehicles: reg}
+
+	feed := b.Build([]model.Location{
+		{VehicleID: "bus-42", Latitude: -1.29, Longitude: 36.82},
+		{VehicleID: "bus-7", Latitude: -1.30, Longitude: 36.83},
+	})
+
+	registered := feed.Entity[0].Vehicle.Vehicle
+	if registered.GetLabel() != "Route 5 Express" || registered.GetLicensePlate() != "KCA 123A" {
+		t.Errorf("registered vehicle descriptor = %v, want registry label and plate", registered)
+	}
+	unregistered := feed.Entity[1].Vehicle.Vehicle
+	if unregistered.GetLabel() != "bus-7" || unregistered.LicensePlate != nil {
+		t.Errorf("unregistered vehicle descriptor = %v, want ID as label and no plate", unregistered)
+	}
+}
+
+// TestBuild_ActiveTrip verifies that a vehicle running a trip is described
+// by that trip, not by the trip on its last report.
+func TestBuild_ActiveTrip(t *testing.T) {
+	m, _ := trips.Open("", clock.NewFake(time.Unix(1752566400, 0)), 0)
+	m.Start(trips.Trip{VehicleID: "bus-42", TripID: "5_0830", RouteID: "5"})
+	b := &gtfsrt.Builder{Trips: m}
+
+	locs := []model.Location{
+		{VehicleID: "bus-42", TripID: "typo", Latitude: -1.29, Longitude: 36.82},
+		{VehicleID: "bus-7", TripID: "7_0900", RouteID: "7", Latitude: -1.30, Longitude: 36.83},
+	}
+	feed := b.Build(locs)
+
+	if trip := feed.Entity[0].Vehicle.Trip; trip.GetTripId() != "5_0830" || trip.GetRouteId() != "5" {
+		t.Errorf("bus-42 trip = %v, want the active trip 5_0830 on route 5", trip)
+	}
+	if trip := feed.Entity[1].Vehicle.Trip; trip.GetTripId() != "7_0900" {
+		t.Errorf("bus-7 trip = %v, want the reported trip 7_0900", trip)
+	}
+	if locs[0].TripID != "typo" {
+		t.Errorf("Build modified its input: TripID = %q", locs[0].TripID)
+	}
+}
+
+// TestBuild_ScheduledTripDescriptor verifies start_date, start_time and
+// schedule_relationship for trips located in the static schedule.
+func TestBuild_ScheduledTripDescriptor(t *testing.T) {
+	nairobi, err := time.LoadLocation("Africa/Nairobi")
+	if err != nil {
+		t.Skipf("no time zone data: %v", err)
+	}
+	clk := clock.NewFake(time.Date(2026, 7, 15, 8, 0, 0, 0, nairobi))
+	sched, err := gtfs.OpenSchedule("../gtfs/testdata/kbs", clk, 0)
+	if err != nil {
+		t.Fatalf("OpenSchedule: %v", err)
+	}
+	m, _ := trips.Open("", clk, 0)
+	b := &gtfsrt.Builder{Clock: clk, Trips: m, Schedule: sched}
+
+	for _, tc := range []struct {
+		name      string
+		trip      string
+		startedAt time.Time
+		date      string
+		start     string
+		rel       pb.TripDescriptor_ScheduleRelationship
+	}{
+		{"fixed", "5_0800_out", time.Date(2026, 7, 15, 7, 58, 0, 0, nairobi), "20260715", "08:00:00", pb.TripDescriptor_SCHEDULED},
+		{"past midnight", "5_2350_out", time.Date(2026, 7, 16, 0, 2, 0, 0, nairobi), "20260715", "23:50:00", pb.TripDescriptor_SCHEDULED},
+		{"exact times", "5_freq_out", time.Date(2026, 7, 15, 6, 23, 0, 0, nairobi), "20260715", "06:20:00", pb.TripDescriptor_SCHEDULED},
+		{"not in schedule", "special_1", time.Date(2026, 7, 15, 10, 0, 5, 0, nairobi), "20260715", "10:00:05", pb.TripDescriptor_ADDED},
+		{"no service", "5_0800_out", time.Date(2026, 7, 18, 8, 0, 0, 0, nairobi), "20260718", "08:00:00", pb.TripDescriptor_ADDED},
+	} {
+		clk.Set(tc.startedAt)
+		m.Start(trips.Trip{VehicleID: "bus-42", TripID: tc.trip, RouteID: "5"})
+
+		feed := b.Build([]model.Location{{VehicleID: "bus-42", Latitude: -1.29, Longitude: 36.82}})
+		trip := feed.Entity[0].Vehicle.Trip
+		if trip.GetStartDate() != tc.date || trip.GetStartTime() != tc.start || trip.GetScheduleRelationship() != tc.rel {
+			t.Errorf("%s: trip = %v, want %s %s %v", tc.name, trip, tc.date, tc.start, tc.rel)
+		}
+	}
+}
+
+// TestBuild_FrequencyTripOnReports verifies that a frequency-based trip
+// sent with every report keeps the run it started on: the start doesn't
+// jump to the run nearest each fix, nor follow the fixes when the trip
+// has no exact times.  Trip updates of a trip without exact times are
+// UNSCHEDULED at every stop, and carry no delays.
+func TestBuild_FrequencyTripOnReports(t *testing.T) {
+	nairobi, err := time.LoadLocation("Africa/Nairobi")
+	if err != nil {
+		t.Skipf("no time zone data: %v", err)
+	}
+
+	// The same schedule with the frequency trip's exact_times unset.
+	inexact := t.TempDir()
+	files, _ := os.ReadDir("../gtfs/testdata/kbs")
+	for _, f := range files {
+		data, _ := os.ReadFile(filepath.Join("../gtfs/testdata/kbs", f.Name()))
+		if f.Name() == "frequencies.txt" {
+			data = []byte("trip_id,start_time,end_time,headway_secs,exact_times\n5_freq_out,06:00:00,07:00:00,600,0\n")
+		}
+		os.WriteFile(filepath.Join(inexact, f.Name()), data, 0o644)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		path    string
+		start   string
+		rel     pb.TripDescriptor_ScheduleRelationship
+		stopRel pb.TripUpdate_StopTimeUpdate_ScheduleRelationship
+	}{
+		{"exact times", "../gtfs/testdata/kbs", "06:00:00", pb.TripDescriptor_SCHEDULED, pb.TripUpdate_StopTimeUpdate_SCHEDULED},
+		{"no exact times", inexact, "06:01:00", pb.TripDescriptor_UNSCHEDULED, pb.TripUpdate_StopTimeUpdate_UNSCHEDULED},
+	} {
+		clk := clock.NewFake(time.Date(2026, 7, 15, 6, 1, 0, 0, nairobi))
+		sched, err := gtfs.OpenSchedule(tc.path, clk, 0)
+		if err != nil {
+			t.Fatalf("%s: OpenSchedule: %v", tc.name, err)
+		}
+		b := &gtfsrt.Builder{Clock: clk, Schedule: sched}
+		p := &match.Placer{Clock: clk, Schedule: sched, Matcher: match.NewMatcher(match.Config{})}
+
+		// Along the trip from S1 at 06:01 to near S4 at 06:12.
+		for _, fix := range []struct {
+			at   time.Duration // after 06:01
+			lon  float64
+			stop string
+		}{
+			{0, 36.800, "S1"},
+			{5 * time.Minute, 36.812, "S3"},
+			{8 * time.Minute, 36.819, "S3"},
+			{11 * time.Minute, 36.826, "S4"},
+		} {
+			clk.Set(time.Date(2026, 7, 15, 6, 1, 0, 0, nairobi).Add(fix.at))
+			locs := placed(p,
+				model.Location{VehicleID: "bus-42", TripID: "5_freq_out", RouteID: "5", Latitude: -1.29, Longitude: fix.lon, Timestamp: clk.Now().Unix()},
+			)
+			vp := b.Build(locs).Entity[0].Vehicle
+			if trip := vp.Trip; trip.GetStartTime() != tc.start || trip.GetScheduleRelationship() != tc.rel {
+				t.Errorf("%s, fix at +%v: trip = %v, want start %s %v", tc.name, fix.at, trip, tc.start, tc.rel)
+			}
+			if vp.GetStopId() != fix.stop {
+				t.Errorf("%s, fix at +%v: stop = %q, want %s", tc.name, fix.at, vp.GetStopId(), fix.stop)
+			}
+
+			tus := b.BuildTripUpdates(locs).Entity
+			if len(tus) != 1 {
+				t.Fatalf("%s, fix at +%v: %d trip updates, want 1", tc.name, fix.at, len(tus))
+			}
+			tu := tus[0].TripUpdate
+			if (tu.Delay != nil) == (tc.rel == pb.TripDescriptor_UNSCHEDULED) {
+				t.Errorf("%s, fix at +%v: trip update delay = %v", tc.name, fix.at, tu.Delay)
+			}
+			for _, stu := range tu.GetStopTimeUpdate() {
+				if stu.GetScheduleRelationship() != tc.stopRel || (tc.stopRel == pb.TripUpdate_StopTimeUpdate_UNSCHEDULED && stu.GetDeparture().Delay != nil) {
+					t.Errorf("%s, fix at +%v: stop time update %v, want %v", tc.name, fix.at, stu, tc.stopRel)
+				}
+			}
+		}
+	}
+}
+
+// TestBuild_StopStatus verifies that a vehicle on a scheduled trip gets
+// its stop, stop sequence and status.
+func TestBuild_StopStatus(t *testing.T) {
+	clk := clock.NewFake(time.Date(2026, 7, 15, 5, 0, 0, 0, time.UTC)) // 08:00 in Nairobi
+	sched, err := gtfs.OpenSchedule("../gtfs/testdata/kbs", clk, 0)
+	if err != nil {
+		t.Fatalf("OpenSchedule: %v", err)
+	}
+	b := &gtfsrt.Builder{Clock: clk, Schedule: sched}
+	p := &match.Placer{Clock: clk, Schedule: sched, Matcher: match.NewMatcher(match.Config{})}
+
+	feed := b.Build(placed(p,
+		model.Location{VehicleID: "bus-42", TripID: "5_0800_out", Latitude: -1.29, Longitude: 36.8095, Timestamp: clk.Now().Unix()},
+		model.Location{VehicleID: "bus-7", TripID: "special_1", Latitude: -1.29, Longitude: 36.8095, Timestamp: clk.Now().Unix()},
+	))
+
+	vp := feed.Entity[0].Vehicle
+	if vp.GetStopId() != "S2" || vp.GetCurrentStopSequence() != 2 || vp.GetCurrentStatus() != pb.VehiclePosition_INCOMING_AT {
+		t.Errorf("scheduled trip: stop %q seq %d status %v, want S2 2 INCOMING_AT",
+			vp.GetStopId(), vp.GetCurrentStopSequence(), vp.GetCurrentStatus())
+	}
+	if added := feed.Entity[1].Vehicle; added.StopId != nil || added.CurrentStatus != nil {
+		t.Errorf("trip not in the schedule got stop fields: %v", added)
+	}
+}
+
+// TestBuild_SnapToShape verifies that Snap publishes the position snapped
+// onto the trip's shape.
+func TestBuild_SnapToShape(t *testing.T) {
+	clk := clock.NewFake(time.Date(2026, 7, 15, 5, 0, 0, 0, time.UTC))
+	sched, err := gtfs.OpenSchedule("../gtfs/testdata/kbs", clk, 0)
+	if err != nil {
+		t.Fatalf("OpenSchedule: %v", err)
+	}
+	b := &gtfsrt.Builder{Clock: clk, Schedule: sched, Snap: true}
+	p := &match.Placer{Clock: clk, Schedule: sched, Matcher: match.NewMatcher(match.Config{})}
+
+	feed := b.Build(placed(p,
+		model.Location{VehicleID: "bus-42", TripID: "5_0800_out", Latitude: -1.2902, Longitude: 36.8050, Timestamp: clk.Now().Unix()},
+	))
+	pos := feed.Entity[0].Vehicle.Position
+	if pos.GetLatitude() != float32(-1.29) || pos.GetLongitude() != float32(36.805) {
+		t.Errorf("position = %v, %v; want snapped to -1.29, 36.805", pos.GetLatitude(), pos.GetLongitude())
+	}
+}
+
+// TestBuild_InferredTrip verifies that a confident inference fills in
+// the trip of a vehicle reporting without one, and that a vehicle with
+// too few positions is left without a trip.
+func TestBuild_InferredTrip(t *testing.T) {
+	clk := clock.NewFake(time.Date(2026, 7, 15, 5, 5, 0, 0, time.UTC)) // 08:05 in Nairobi
+	sched, err := gtfs.OpenSchedule("../gtfs/testdata/kbs", clk, 0)
+	if err != nil {
+		t.Fatalf("OpenSchedule: %v", err)
+	}
+	now := clk.Now().Unix()
+	trail := []model.Location{
+		{VehicleID: "bus-42", Latitude: -1.29, Longitude: 36.806, Timestamp: now - 120},
+		{VehicleID: "bus-42", Latitude: -1.29, Longitude: 36.808, Timestamp: now - 60},
+	}
+	history := func(id string) []model.Location {
+		if id == "bus-42" {
+			return trail
+		}
+		return nil
+	}
+	b := &gtfsrt.Builder{Clock: clk, Schedule: sched}
+	p := &match.Placer{Clock: clk, Schedule: sched, Inferrer: match.NewInferrer(match.InferConfig{}, clk, history)}
+
+	feed := b.Build(placed(p,
+		model.Location{VehicleID: "bus-42", Latitude: -1.29, Longitude: 36.81, Timestamp: now},
+		model.Location{VehicleID: "bus-7", Latitude: -1.29, Longitude: 36.81, Timestamp: now},
+	))
+	trip := feed.Entity[0].Vehicle.Trip
+	if trip.GetTripId() != "5_0800_out" || trip.GetStartTime() != "08:00:00" || trip.GetScheduleRelationship() != pb.TripDescriptor_SCHEDULED {
+		t.Errorf("inferred trip = %v, want 5_0800_out at 08:00:00", trip)
+	}
+	if trip := feed.Entity[1].Vehicle.Trip; trip != nil {
+		t.Errorf("vehicle with one fix got trip %v", trip)
+	}
+}
+
+// TestBuildTripUpdates verifies that a vehicle on a scheduled trip gets
+// a TripUpdate carrying its delay to the rest of its stops, and that
+// vehicles without a scheduled trip get none.
+func TestBuildTripUpdates(t *testing.T) {
+	clk := clock.NewFake(time.Date(2026, 7, 15, 5, 6, 0, 0, time.UTC)) // 08:06 in Nairobi
+	sched, err := gtfs.OpenSchedule("../gtfs/testdata/kbs", clk, 0)
+	if err != nil {
+		t.Fatalf("OpenSchedule: %v", err)
+	}
+	b := &gtfsrt.Builder{Clock: clk, Schedule: sched}
+	p := &match.Placer{Clock: clk, Schedule: sched, Matcher: match.NewMatcher(match.Config{})}
+
+	// 95% of the way from S1 (08:00) to S2 (08:05), so due at 08:04:45.
+	feed := b.BuildTripUpdates(placed(p,
+		model.Location{VehicleID: "bus-42", TripID: "5_0800_out", Latitude: -1.29, Longitude: 36.8095, Timestamp: clk.Now().Unix()},
+		model.Location{VehicleID: "bus-7", TripID: "special_1", Latitude: -1.29, Longitude: 36.8095, Timestamp: clk.Now().Unix()},
+		model.Location{VehicleID: "bus-9", Latitude: -1.29, Longitude: 36.8095, Timestamp: clk.Now().Unix()},
+	))
+	if len(feed.Entity) != 1 {
+		t.Fatalf("got %d entities, want 1", len(feed.Entity))
+	}
+	e := feed.Entity[0]
+	tu := e.TripUpdate
+	if e.GetId() != "trip-update-bus-42" || tu.GetTrip().GetTripId() != "5_0800_out" || tu.GetDelay() != 75 {
+		t.Fatalf("entity %s: trip %v, delay %d; want 5_0800_out 75 s late", e.GetId(), tu.GetTrip(), tu.GetDelay())
+	}
+
+	stus := tu.GetStopTimeUpdate()
+	if len(stus) != 3 || stus[0].GetStopId() != "S2" || stus[0].GetStopSequence() != 2 {
+		t.Fatalf("stop time updates = %v, want S2 to S4", stus)
+	}
+	s4 := time.Date(2026, 7, 15, 5, 15, 75, 0, time.UTC).Unix()
+	if last := stus[2].GetArrival(); last.GetTime() != s4 || last.GetDelay() != 75 {
+		t.Errorf("S4 arrival = %v, want %d (75 s late)", last, s4)
+	}
+}
+
+// TestBuildAlerts verifies the conversion of an alert to its GTFS-RT
+// form.
+func TestBuildAlerts(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1752566400, 0))
+	m, _ := alerts.Open("", clk)
+	end := clk.Now().Add(time.Hour)
+	m.Create(alerts.Alert{
+		ID:               "closed-s2",
+		ActivePeriods:    []alerts.Period{{End: &end}},
+		InformedEntities: []alerts.Entity{{StopID: "S2"}, {RouteID: "5", TripID: "5_0800_out"}},
+		Cause:            "CONSTRUCTION",
+		Effect:           "STOP_MOVED",
+		Header:           alerts.Text{"": "Stop moved", "sw": "Kituo kimehamishwa"},
+	})
+
+	feed := (&gtfsrt.Builder{Clock: clk, Alerts: m}).BuildAlerts()
+	if len(feed.Entity) != 1 || feed.Entity[0].GetId() != "alert-closed-s2" {
+		t.Fatalf("entities = %v, want alert-closed-s2", feed.Entity)
+	}
+	a := feed.Entity[0].Alert
+	if a.GetCause() != pb.Alert_CONSTRUCTION || a.GetEffect() != pb.Alert_STOP_MOVED {
+		t.Errorf("cause, effect = %v, %v", a.GetCause(), a.GetEffect())
+	}
+	if p := a.GetActivePeriod(); len(p) != 1 || p[0].Start != nil || p[0].GetEnd() != uint64(end.Unix()) {
+		t.Errorf("active periods = %v", p)
+	}
+	if ie := a.GetInformedEntity(); len(ie) != 2 || ie[0].GetStopId() != "S2" || ie[1].GetTrip().GetTripId() != "5_0800_out" {
+		t.Errorf("informed entities = %v", ie)
+	}
+	tr := a.GetHeaderText().GetTranslation()
+	if len(tr) != 2 || tr[0].Language != nil || tr[1].GetLanguage() != "sw" {
+		t.Errorf("header translations = %v, want untagged then sw", tr)
+	}
+	if a.DescriptionText != nil {
+		t.Errorf("empty description published as %v", a.DescriptionText)
+	}
+}
+
+// TestBuildCombined_AlertSelection verifies which alerts route and
+// agency filters keep.
+func TestBuildCombined_AlertSelection(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1752566400, 0))
+	sched, err := gtfs.OpenSchedule("../gtfs/testdata/kbs", clk, 0)
+	if err != nil {
+		t.Fatalf("OpenSchedule: %v", err)
+	}
+	m, _ := alerts.Open("", clk)
+	for _, a := range []alerts.Alert{
+		{ID: "route", InformedEntities: []alerts.Entity{{RouteID: "5"}}},
+		{ID: "trip", InformedEntities: []alerts.Entity{{TripID: "5_0830_in"}}},
+		{ID: "stop", InformedEntities: []alerts.Entity{{StopID: "S2"}}},
+		{ID: "other", InformedEntities: []alerts.Entity{{AgencyID: "tsrtc"}}},
+		{ID: "agency", InformedEntities: []alerts.Entity{{AgencyID: "kbs"}}},
+	} {
+		a.Header = alerts.Text{"en": a.ID}
+		if _, err := m.Create(a); err != nil {
+			t.Fatal(err)
+		}
+		clk.Advance(time.Second)
+	}
+	b := &gtfsrt.Builder{Clock: clk, Schedule: sched, Alerts: m}
+
+	for _, tc := range []struct {
+		sel  gtfsrt.Selection
+		want string
+	}{
+		{gtfsrt.Selection{}, "alert-route alert-trip alert-stop alert-other alert-agency"},
+		{gtfsrt.Selection{RouteID: "5"}, "alert-route alert-trip alert-agency"},
+		{gtfsrt.Selection{Agency: "kbs"}, "alert-route alert-trip alert-stop alert-agency"},
+		{gtfsrt.Selection{Agency: "tsrtc"}, "alert-other"},
+		{gtfsrt.Selection{Kinds: []gtfsrt.Kind{gtfsrt.VehiclePositions}}, ""},
+	} {
+		var ids []string
+		for _, e := range b.BuildCombined(nil, tc.sel).Entity {
+			ids = append(ids, e.GetId())
+		}
+		if got := strings.Join(ids, " "); got != tc.want {
+			t.Errorf("%+v: %q, want %q", tc.sel, got, tc.want)
+		}
+	}
+}
+
+// TestBuildCombined_AlertSelectionWithoutSchedule verifies that without
+// a schedule the agency running a route comes from the registered
+// vehicles reporting on it.
+func TestBuildCombined_AlertSelectionWithoutSchedule(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1752566400, 0))
+	m, _ := alerts.Open("", clk)
+	for _, a := range []alerts.Alert{
+		{ID: "route-5", InformedEntities: []alerts.Entity{{RouteID: "5"}}},
+		{ID: "route-9", InformedEntities: []alerts.Entity{{RouteID: "9"}}},
+		{ID: "stop", InformedEntities: []alerts.Entity{{StopID: "S2"}}},
+		{ID: "kbs", InformedEntities: []alerts.Entity{{AgencyID: "kbs"}}},
+	} {
+		a.Header = alerts.Text{"en": a.ID}
+		if _, err := m.Create(a); err != nil {
+			t.Fatal(err)
+		}
+		clk.Advance(time.Second)
+	}
+	vehicles, _ := registry.Open("")
+	vehicles.Create(registry.Vehicle{ID: "kbs-1", AgencyID: "kbs"})
+	vehicles.Create(registry.Vehicle{ID: "tsrtc-1", AgencyID: "tsrtc"})
+	b := &gtfsrt.Builder{Clock: clk, Vehicles: vehicles, Alerts: m}
+	locs := []model.Location{
+		{VehicleID: "kbs-1", RouteID: "5", Latitude: -1.29, Longitude: 36.81, Timestamp: clk.Now().Unix()},
+		{VehicleID: "tsrtc-1", RouteID: "9", Latitude: 17.4, Longitude: 78.5, Timestamp: clk.Now().Unix()},
+	}
+
+	for _, tc := range []struct {
+		sel  gtfsrt.Selection
+		want string
+	}{
+		{gtfsrt.Selection{Agency: "kbs"}, "alert-route-5 alert-kbs"},
+		{gtfsrt.Selection{Agency: "tsrtc"}, "alert-route-9"},
+		{gtfsrt.Selection{RouteID: "5"}, "alert-route-5 alert-kbs"},
+		{gtfsrt.Selection{RouteID: "9"}, "alert-route-9"},
+	} {
+		tc.sel.Kinds = []gtfsrt.Kind{gtfsrt.Alerts}
+		var ids []string
+		for _, e := range b.BuildCombined(locs, tc.sel).Entity {
+			ids = append(ids, e.GetId())
+		}
+		if got := strings.Join(ids, " "); got != tc.want {
+			t.Errorf("%+v: %q, want %q", tc.sel, got, tc.want)
+		}
+	}
+
+	// With one agency, its routes and stops need no vehicles on them.
+	vehicles.Delete("tsrtc-1")
+	var ids []string
+	for _, e := range b.BuildCombined(nil, gtfsrt.Selection{Kinds: []gtfsrt.Kind{gtfsrt.Alerts}, Agency: "kbs"}).Entity {
+		ids = append(ids, e.GetId())
+	}
+	if got, want := strings.Join(ids, " "), "alert-route-5 alert-route-9 alert-stop alert-kbs"; got != want {
+		t.Errorf("single agency: %q, want %q", got, want)
+	}
+}
 
-	ts := feed.Header.GetTimestamp()
-	if ts < before || ts > after+1 {
-		t.Errorf("header timestamp %d not in expected range [%d, %d]", ts, before, after)
+// placed returns locs with each fix placed on its trip by p, as the
+// location handlers do once the store accepts it.
+func placed(p *match.Placer, locs ...model.Location) []model.Location {
+	for i := range locs {
+		locs[i].Match = p.Place(locs[i])
 	}
+	return locs
 }
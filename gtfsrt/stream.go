@@ -0,0 +1,342 @@
+package gtfsrt
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jaggu/vehicle-tracker-prototype/clock"
+	pb "github.com/jaggu/vehicle-tracker-prototype/proto/gtfsrt"
+	"google.golang.org/protobuf/proto"
+)
+
+// DefaultStreamInterval is how often a Streamer rebuilds the feeds it
+// streams and sends out what changed.
+const DefaultStreamInterval = 5 * time.Second
+
+// StreamRetention is how long a Streamer remembers deleted entities, and
+// so how long a consumer can be disconnected and still catch up with a
+// differential update rather than a full resync.  Feeds nobody has
+// streamed for this long are forgotten.
+const StreamRetention = 10 * time.Minute
+
+// Streamer serves feeds as a stream of DIFFERENTIAL updates.
+//
+// Each distinct feed (a kind, route and agency selection) is tracked
+// separately.  Every interval the Streamer rebuilds the feeds that have
+// subscribers, compares each entity with the previous build, and, if
+// anything changed, moves the feed to its next sequence number.  A
+// subscriber is sent the entities changed since the last sequence number
+// it saw, with vehicles that vanished marked is_deleted.
+//
+// Sequence numbers are written "<epoch>:<n>", where the epoch identifies
+// one tracking of a feed.  A consumer that reconnects with a sequence
+// number from another epoch, such as from before a restart, or from
+// longer ago than StreamRetention, gets a FULL_DATASET resync instead.
+//
+// It is safe for concurrent use.
+type Streamer struct {
+	clock clock.Clock
+
+	mu    sync.Mutex
+	feeds map[string]*tracker
+
+	stop      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// tracker follows one feed.
+type tracker struct {
+	build func() *pb.FeedMessage
+	epoch string
+
+	buildMu sync.Mutex // serialises builds, so updates apply in order
+
+	mu       sync.Mutex
+	seq      uint64
+	built    bool
+	at       time.Time // of the last change
+	order    []string  // entity IDs in the order of the last build
+	entities map[string]trackedEntity
+	deleted  map[string]tombstone
+	horizon  uint64 // deletions up to here have been forgotten
+	subs     map[*Subscription]struct{}
+	lastUsed time.Time // last update with subscribers
+}
+
+type trackedEntity struct {
+	entity *pb.FeedEntity
+	seq    uint64 // when it last changed
+}
+
+type tombstone struct {
+	seq uint64
+	at  time.Time
+}
+
+// NewStreamer returns a Streamer that rebuilds streamed feeds every
+// interval, or DefaultStreamInterval if interval is not positive.  Call
+// Close to stop it.
+func NewStreamer(clk clock.Clock, interval time.Duration) *Streamer {
+	if interval <= 0 {
+		interval = DefaultStreamInterval
+	}
+	s := &Streamer{clock: clk, feeds: make(map[string]*tracker), stop: make(chan struct{})}
+	ticker := clk.NewTicker(interval)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C():
+				s.Update()
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+	return s
+}
+
+// Close stops the update goroutine.  It is safe to call more than once.
+func (s *Streamer) Close() {
+	s.closeOnce.Do(func() {
+		close(s.stop)
+		s.wg.Wait()
+	})
+}
+
+// Update rebuilds every feed that has subscribers and notifies them of
+// any change, and forgets feeds nobody has streamed for StreamRetention.
+// The update goroutine calls it once per interval; it is exported so
+// tests can force an update.
+func (s *Streamer) Update() {
+	now := s.clock.Now()
+	var live []*tracker
+	s.mu.Lock()
+	for key, t := range s.feeds {
+		t.mu.Lock()
+		active, idle := len(t.subs) > 0, now.Sub(t.lastUsed) > StreamRetention
+		t.mu.Unlock()
+		switch {
+		case active:
+			live = append(live, t)
+		case idle:
+			delete(s.feeds, key)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, t := range live {
+		t.update(now)
+	}
+}
+
+// Subscribe starts streaming the feed identified by key, which build
+// creates in full.  since is the last sequence number the consumer saw,
+// or "" for a new consumer.  The subscription's first event is a full
+// dataset unless the consumer can catch up from since.
+func (s *Streamer) Subscribe(key string, build func() *pb.FeedMessage, since string) *Subscription {
+	now := s.clock.Now()
+	s.mu.Lock()
+	t, ok := s.feeds[key]
+	if !ok {
+		t = &tracker{
+			build:    build,
+			epoch:    newEpoch(),
+			entities: make(map[string]trackedEntity),
+			deleted:  make(map[string]tombstone),
+			subs:     make(map[*Subscription]struct{}),
+		}
+		s.feeds[key] = t
+	}
+	s.mu.Unlock()
+
+	// Nobody has been keeping the feed up to date while it had no
+	// subscribers.
+	t.mu.Lock()
+	stale := !t.built || len(t.subs) == 0
+	t.mu.Unlock()
+	if stale {
+		t.update(now)
+	}
+
+	sub := &Subscription{t: t, c: make(chan struct{}, 1), full: true}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if epoch, seq, ok := parseSeq(since); ok && epoch == t.epoch && seq >= t.horizon && seq <= t.seq {
+		sub.last, sub.full = seq, false
+	}
+	t.subs[sub] = struct{}{}
+	t.lastUsed = now
+	sub.c <- struct{}{} // the first event is ready
+	return sub
+}
+
+// Subscription is one consumer's stream.
+type Subscription struct {
+	t    *tracker
+	c    chan struct{}
+	last uint64 // sequence number last sent
+	full bool   // the next event must be a full dataset
+}
+
+// C receives a value when there may be a new event.
+func (sub *Subscription) C() <-chan struct{} { return sub.c }
+
+// Event is one message of a stream.
+type Event struct {
+	// ID is the sequence number the event brings the consumer to.
+	ID string
+
+	// Full is set for a FULL_DATASET resync.
+	Full bool
+
+	Feed *pb.FeedMessage
+}
+
+// Next returns the event bringing the consumer up to date, or false if
+// it already is.
+func (sub *Subscription) Next() (Event, bool) {
+	t := sub.t
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !sub.full && sub.last >= t.seq {
+		return Event{}, false
+	}
+	ev := Event{ID: t.epoch + ":" + strconv.FormatUint(t.seq, 10), Full: sub.full}
+	if sub.full {
+		ev.Feed = t.fullLocked()
+	} else {
+		ev.Feed = t.sinceLocked(sub.last)
+	}
+	sub.last, sub.full = t.seq, false
+	return ev, true
+}
+
+// Close ends the subscription.
+func (sub *Subscription) Close() {
+	t := sub.t
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.subs, sub)
+}
+
+// update rebuilds the feed and, if any entity changed, moves to the next
+// sequence number and notifies the subscribers.
+func (t *tracker) update(now time.Time) {
+	t.buildMu.Lock()
+	defer t.buildMu.Unlock()
+	feed := t.build()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	next := t.seq + 1
+	changed := false
+	seen := make(map[string]bool, len(feed.Entity))
+	order := make([]string, 0, len(feed.Entity))
+	for _, e := range feed.Entity {
+		id := e.GetId()
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		order = append(order, id)
+		if prev, ok := t.entities[id]; !ok || !proto.Equal(prev.entity, e) {
+			t.entities[id] = trackedEntity{entity: e, seq: next}
+			delete(t.deleted, id)
+			changed = true
+		}
+	}
+	for id := range t.entities {
+		if !seen[id] {
+			delete(t.entities, id)
+			t.deleted[id] = tombstone{seq: next, at: now}
+			changed = true
+		}
+	}
+	for id, ts := range t.deleted {
+		if now.Sub(ts.at) > StreamRetention {
+			delete(t.deleted, id)
+			t.horizon = max(t.horizon, ts.seq)
+		}
+	}
+	t.order = order
+	if len(t.subs) > 0 {
+		t.lastUsed = now
+	}
+	if !changed && t.built {
+		return
+	}
+	t.seq, t.at, t.built = next, now, true
+	for sub := range t.subs {
+		select {
+		case sub.c <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// fullLocked returns the whole feed as a FULL_DATASET message.  t.mu must
+// be held.
+func (t *tracker) fullLocked() *pb.FeedMessage {
+	entities := make([]*pb.FeedEntity, 0, len(t.order))
+	for _, id := range t.order {
+		entities = append(entities, t.entities[id].entity)
+	}
+	return &pb.FeedMessage{Header: header(t.at), Entity: entities}
+}
+
+// sinceLocked returns the entities changed or deleted after seq as a
+// DIFFERENTIAL message.  t.mu must be held.
+func (t *tracker) sinceLocked(seq uint64) *pb.FeedMessage {
+	entities := []*pb.FeedEntity{}
+	for _, id := range t.order {
+		if te := t.entities[id]; te.seq > seq {
+			entities = append(entities, te.entity)
+		}
+	}
+	var gone []string
+	for id, ts := range t.deleted {
+		if ts.seq > seq {
+			gone = append(gone, id)
+		}
+	}
+	sort.Strings(gone)
+	for _, id := range gone {
+		entities = append(entities, &pb.FeedEntity{Id: proto.String(id), IsDeleted: proto.Bool(true)})
+	}
+
+	h := header(t.at)
+	incrementality := pb.FeedHeader_DIFFERENTIAL
+	h.Incrementality = &incrementality
+	return &pb.FeedMessage{Header: h, Entity: entities}
+}
+
+// parseSeq splits a "<epoch>:<n>" sequence number.
+func parseSeq(s string) (string, uint64, bool) {
+	epoch, n, ok := strings.Cut(s, ":")
+	if !ok {
+		return "", 0, false
+	}
+	seq, err := strconv.ParseUint(n, 10, 64)
+	return epoch, seq, err == nil
+}
+
+// newEpoch returns a random epoch, so sequence numbers from an earlier
+// tracking of a feed are never mistaken for current ones.
+func newEpoch() string {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,165 @@
+package gtfsrt_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jaggu/vehicle-tracker-prototype/clock"
+	"github.com/jaggu/vehicle-tracker-prototype/gtfsrt"
+	"github.com/jaggu/vehicle-tracker-prototype/model"
+	pb "github.com/jaggu/vehicle-tracker-prototype/proto/gtfsrt"
+)
+
+// next waits for the subscription's next event.
+func next(t *testing.T, sub *gtfsrt.Subscription) gtfsrt.Event {
+	t.Helper()
+	select {
+	case <-sub.C():
+	default:
+		t.Fatal("no event signalled")
+	}
+	ev, ok := sub.Next()
+	if !ok {
+		t.Fatal("no event ready")
+	}
+	return ev
+}
+
+// entityIDs lists the IDs in feed, with a "-" suffix on deletions.
+func entityIDs(feed *pb.FeedMessage) []string {
+	var ids []string
+	for _, e := range feed.Entity {
+		id := e.GetId()
+		if e.GetIsDeleted() {
+			id += "-"
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func sameIDs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// TestStreamer verifies that subscribers get a full dataset first, then
+// only the entities that changed, and a full resync when they reconnect
+// with a sequence number that can't be caught up from.
+func TestStreamer(t *testing.T) {
+	clk := clock.NewFake(time.Date(2025, 7, 15, 8, 0, 0, 0, time.UTC))
+	s := gtfsrt.NewStreamer(clk, time.Hour)
+	defer s.Close()
+
+	locs := []model.Location{
+		{VehicleID: "bus-1", Latitude: -1.29, Longitude: 36.82},
+		{VehicleID: "bus-2", Latitude: -1.30, Longitude: 36.83},
+	}
+	build := func() *pb.FeedMessage { return gtfsrt.BuildFeed(locs) }
+
+	sub := s.Subscribe("all", build, "")
+	ev := next(t, sub)
+	if !ev.Full || ev.Feed.Header.GetIncrementality() != pb.FeedHeader_FULL_DATASET {
+		t.Errorf("first event: full %v, %v, want a FULL_DATASET", ev.Full, ev.Feed.Header.GetIncrementality())
+	}
+	if got := entityIDs(ev.Feed); !sameIDs(got, []string{"vehicle-bus-1", "vehicle-bus-2"}) {
+		t.Errorf("first event entities = %v", got)
+	}
+	if _, ok := sub.Next(); ok {
+		t.Error("Next returned an event with nothing new")
+	}
+
+	// Nothing changed: no event.
+	s.Update()
+	select {
+	case <-sub.C():
+		if _, ok := sub.Next(); ok {
+			t.Error("unchanged feed produced an event")
+		}
+	default:
+	}
+
+	locs[0].Latitude = -1.28
+	s.Update()
+	ev = next(t, sub)
+	if ev.Full || ev.Feed.Header.GetIncrementality() != pb.FeedHeader_DIFFERENTIAL {
+		t.Errorf("moved vehicle: full %v, %v, want DIFFERENTIAL", ev.Full, ev.Feed.Header.GetIncrementality())
+	}
+	if got := entityIDs(ev.Feed); !sameIDs(got, []string{"vehicle-bus-1"}) {
+		t.Errorf("moved vehicle entities = %v, want only vehicle-bus-1", got)
+	}
+
+	locs = locs[:1]
+	s.Update()
+	ev = next(t, sub)
+	if got := entityIDs(ev.Feed); !sameIDs(got, []string{"vehicle-bus-2-"}) {
+		t.Errorf("vanished vehicle entities = %v, want vehicle-bus-2 deleted", got)
+	}
+	last := ev.ID
+	sub.Close()
+
+	// While disconnected a vehicle appears; reconnecting from the last
+	// event catches up with just that.
+	locs = append(locs, model.Location{VehicleID: "bus-3", Latitude: -1.31, Longitude: 36.84})
+	sub = s.Subscribe("all", build, last)
+	ev = next(t, sub)
+	if ev.Full {
+		t.Error("reconnect from the last event got a full resync")
+	}
+	if got := entityIDs(ev.Feed); !sameIDs(got, []string{"vehicle-bus-3"}) {
+		t.Errorf("reconnect entities = %v, want only vehicle-bus-3", got)
+	}
+	sub.Close()
+
+	for _, since := range []string{"bogus", "other:1", ev.ID + "0"} {
+		sub := s.Subscribe("all", build, since)
+		if ev := next(t, sub); !ev.Full || len(ev.Feed.Entity) != 2 {
+			t.Errorf("since %q: full %v with %d entities, want a full resync", since, ev.Full, len(ev.Feed.Entity))
+		}
+		sub.Close()
+	}
+}
+
+// TestStreamer_ResyncAfterRetention verifies that a consumer away for
+// longer than deletions are remembered gets a full resync, since it
+// could otherwise miss them.
+func TestStreamer_ResyncAfterRetention(t *testing.T) {
+	clk := clock.NewFake(time.Date(2025, 7, 15, 8, 0, 0, 0, time.UTC))
+	s := gtfsrt.NewStreamer(clk, time.Hour)
+	defer s.Close()
+
+	locs := []model.Location{
+		{VehicleID: "bus-1", Latitude: -1.29, Longitude: 36.82},
+		{VehicleID: "bus-2", Latitude: -1.30, Longitude: 36.83},
+	}
+	build := func() *pb.FeedMessage { return gtfsrt.BuildFeed(locs) }
+
+	away := s.Subscribe("all", build, "")
+	last := next(t, away).ID
+	away.Close()
+
+	sub := s.Subscribe("all", build, "")
+	next(t, sub)
+	locs = locs[:1]
+	s.Update()
+	next(t, sub)
+	clk.Advance(gtfsrt.StreamRetention + time.Minute)
+	s.Update()
+
+	away = s.Subscribe("all", build, last)
+	defer away.Close()
+	ev := next(t, away)
+	if !ev.Full {
+		t.Fatal("reconnect after the deletion was forgotten did not resync")
+	}
+	if got := entityIDs(ev.Feed); !sameIDs(got, []string{"vehicle-bus-1"}) {
+		t.Errorf("resync entities = %v, want vehicle-bus-1", got)
+	}
+}
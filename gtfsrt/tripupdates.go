@@ -0,0 +1,104 @@
+package gtfsrt
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jaggu/vehicle-tracker-prototype/match"
+	"github.com/jaggu/vehicle-tracker-prototype/model"
+	pb "github.com/jaggu/vehicle-tracker-prototype/proto/gtfsrt"
+)
+
+// BuildTripUpdates creates a GTFS-RT FeedMessage of TripUpdate entities
+// from a slice of active vehicle locations.
+//
+// Only vehicles on a scheduled run whose match places them along its
+// stops get a TripUpdate.  It predicts the stop the vehicle is at or
+// heading to and every stop after it by carrying the vehicle's current
+// delay through the rest of stop_times.txt (see match.Predict).  Runs of
+// frequency-based trips without exact times are UNSCHEDULED, as is each
+// of their StopTimeUpdates, and so carry times but no delays.
+func (b *Builder) BuildTripUpdates(locations []model.Location) *pb.FeedMessage {
+	return b.BuildCombined(locations, Selection{Kinds: []Kind{TripUpdates}})
+}
+
+// tripUpdateEntity predicts the vehicle's remaining stops, or returns
+// false if it isn't placed along a scheduled run.
+func tripUpdateEntity(st vehicleState, now time.Time) (*pb.FeedEntity, bool) {
+	if !st.hasStop {
+		return nil, false
+	}
+	at := now
+	if st.loc.Timestamp > 0 {
+		at = time.Unix(st.loc.Timestamp, 0)
+	}
+	p, ok := match.Predict(st.sched, st.run, st.stop, st.loc.Latitude, st.loc.Longitude, at)
+	if !ok {
+		return nil, false
+	}
+	return buildTripUpdate(st, p), true
+}
+
+// buildTripUpdate converts a vehicle's prediction into a FeedEntity
+// wrapping a TripUpdate message.
+func buildTripUpdate(st vehicleState, p match.Prediction) *pb.FeedEntity {
+	id := fmt.Sprintf("trip-update-%s", st.loc.VehicleID)
+	withDelay := st.trip.GetScheduleRelationship() != pb.TripDescriptor_UNSCHEDULED
+
+	label := st.loc.VehicleID
+	if st.reg.Label != "" {
+		label = st.reg.Label
+	}
+	tu := &pb.TripUpdate{
+		Trip: st.trip,
+		Vehicle: &pb.VehicleDescriptor{
+			Id:    &st.loc.VehicleID,
+			Label: &label,
+		},
+	}
+	if st.reg.LicensePlate != "" {
+		tu.Vehicle.LicensePlate = &st.reg.LicensePlate
+	}
+	if st.loc.Timestamp > 0 {
+		ts := uint64(st.loc.Timestamp)
+		tu.Timestamp = &ts
+	}
+	if withDelay {
+		delay := int32(p.Delay)
+		tu.Delay = &delay
+	}
+
+	for _, sp := range p.Stops {
+		seq := uint32(sp.StopSequence)
+		stu := &pb.TripUpdate_StopTimeUpdate{
+			StopSequence: &seq,
+			StopId:       &sp.StopID,
+		}
+		if !withDelay {
+			rel := pb.TripUpdate_StopTimeUpdate_UNSCHEDULED
+			stu.ScheduleRelationship = &rel
+		}
+		if !sp.Arrival.IsZero() {
+			stu.Arrival = stopTimeEvent(sp.Arrival, sp.ScheduledArrival, withDelay)
+		}
+		stu.Departure = stopTimeEvent(sp.Departure, sp.ScheduledDeparture, withDelay)
+		tu.StopTimeUpdate = append(tu.StopTimeUpdate, stu)
+	}
+
+	return &pb.FeedEntity{
+		Id:         &id,
+		TripUpdate: tu,
+	}
+}
+
+// stopTimeEvent returns the event predicted at predicted, with its delay
+// against scheduled when withDelay is set.
+func stopTimeEvent(predicted, scheduled time.Time, withDelay bool) *pb.TripUpdate_StopTimeEvent {
+	t := predicted.Unix()
+	ev := &pb.TripUpdate_StopTimeEvent{Time: &t}
+	if withDelay {
+		d := int32(predicted.Sub(scheduled) / time.Second)
+		ev.Delay = &d
+	}
+	return ev
+}
@@ -0,0 +1,26 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"net/http"
+)
+
+// RequireAdmin wraps an admin handler so that it only runs for requests
+// carrying "Authorization: Bearer <token>" with the configured admin
+// token.  An empty token disables the admin API altogether rather than
+// leaving it open.
+func RequireAdmin(token string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token == "" {
+			writeError(w, http.StatusForbidden, "Admin API is disabled; start the server with -admin-token")
+			return
+		}
+		got, ok := bearerToken(r)
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="vehicle-tracker-admin"`)
+			writeError(w, http.StatusUnauthorized, "Admin token required")
+			return
+		}
+		next(w, r)
+	}
+}
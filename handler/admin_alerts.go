@@ -0,0 +1,201 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/jaggu/vehicle-tracker-prototype/alerts"
+	"github.com/jaggu/vehicle-tracker-prototype/gtfs"
+)
+
+// alertRequest is the JSON body of the alert admin endpoints.  Fields
+// are pointers so that PUT can tell an omitted field from a zero one.
+type alertRequest struct {
+	ID               *string          `json:"id"`
+	ActivePeriods    *[]alerts.Period `json:"active_periods"`
+	InformedEntities *[]alerts.Entity `json:"informed_entities"`
+	Cause            *string          `json:"cause"`
+	Effect           *string          `json:"effect"`
+	Header           *alerts.Text     `json:"header"`
+	Description      *alerts.Text     `json:"description"`
+	URL              *alerts.Text     `json:"url"`
+}
+
+// applyTo copies the fields present in the request onto a.
+func (req alertRequest) applyTo(a *alerts.Alert) {
+	if req.ActivePeriods != nil {
+		a.ActivePeriods = *req.ActivePeriods
+	}
+	if req.InformedEntities != nil {
+		a.InformedEntities = *req.InformedEntities
+	}
+	if req.Cause != nil {
+		a.Cause = *req.Cause
+	}
+	if req.Effect != nil {
+		a.Effect = *req.Effect
+	}
+	if req.Header != nil {
+		a.Header = *req.Header
+	}
+	if req.Description != nil {
+		a.Description = *req.Description
+	}
+	if req.URL != nil {
+		a.URL = *req.URL
+	}
+}
+
+// AdminAlerts handles /api/v1/admin/alerts.
+//
+// GET lists every alert, expired ones included.  POST creates one from
+// {"id", "active_periods", "informed_entities", "cause", "effect",
+// "header", "description", "url"}; the id is generated when omitted.
+// With a schedule, informed routes, stops and trips must be in it.
+func AdminAlerts(m *alerts.Manager, sched *gtfs.Schedule) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, map[string]any{"alerts": m.List()})
+
+		case http.MethodPost:
+			var req alertRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeError(w, http.StatusBadRequest, "Invalid JSON body")
+				return
+			}
+			var a alerts.Alert
+			if req.ID != nil {
+				a.ID = *req.ID
+			}
+			req.applyTo(&a)
+			if err := checkInformed(sched, a.InformedEntities); err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			a, err := m.Create(a)
+			if err != nil {
+				writeAlertError(w, err)
+				return
+			}
+			writeJSON(w, http.StatusCreated, a)
+
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "Only GET and POST are allowed")
+		}
+	}
+}
+
+// AdminAlert handles /api/v1/admin/alerts/{id}.
+//
+// GET returns the alert.  PUT updates it; fields omitted from the body
+// keep their current values.  DELETE removes it.
+func AdminAlert(m *alerts.Manager, sched *gtfs.Schedule) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+
+		switch r.Method {
+		case http.MethodGet:
+			a, ok := m.Get(id)
+			if !ok {
+				writeAlertError(w, alerts.ErrNotFound)
+				return
+			}
+			writeJSON(w, http.StatusOK, a)
+
+		case http.MethodPut:
+			var req alertRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeError(w, http.StatusBadRequest, "Invalid JSON body")
+				return
+			}
+			if req.ID != nil && *req.ID != id {
+				writeError(w, http.StatusBadRequest, "Alert id cannot be changed")
+				return
+			}
+			a, ok := m.Get(id)
+			if !ok {
+				writeAlertError(w, alerts.ErrNotFound)
+				return
+			}
+			req.applyTo(&a)
+			if err := checkInformed(sched, a.InformedEntities); err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			a, err := m.Update(a)
+			if err != nil {
+				writeAlertError(w, err)
+				return
+			}
+			writeJSON(w, http.StatusOK, a)
+
+		case http.MethodDelete:
+			if err := m.Delete(id); err != nil {
+				writeAlertError(w, err)
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "Only GET, PUT and DELETE are allowed")
+		}
+	}
+}
+
+// ExpireAlert handles POST /api/v1/admin/alerts/{id}/expire.
+//
+// It ends the alert now, taking it out of the feed while keeping it
+// listed.
+func ExpireAlert(m *alerts.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "Only POST is allowed")
+			return
+		}
+		a, err := m.Expire(r.PathValue("id"))
+		if err != nil {
+			writeAlertError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, a)
+	}
+}
+
+// checkInformed checks that the routes, stops and trips an alert names
+// are in the schedule, if there is one, so a typo doesn't publish an
+// alert that applies to nothing.
+func checkInformed(sched *gtfs.Schedule, entities []alerts.Entity) error {
+	if sched == nil {
+		return nil
+	}
+	f := sched.Feed()
+	for i, e := range entities {
+		if _, ok := f.Routes[e.RouteID]; e.RouteID != "" && !ok {
+			return fmt.Errorf("informed_entities[%d]: route %s is not in the schedule", i, e.RouteID)
+		}
+		if _, ok := f.Stops[e.StopID]; e.StopID != "" && !ok {
+			return fmt.Errorf("informed_entities[%d]: stop %s is not in the schedule", i, e.StopID)
+		}
+		if _, ok := f.Trips[e.TripID]; e.TripID != "" && !ok {
+			return fmt.Errorf("informed_entities[%d]: trip %s is not in the schedule", i, e.TripID)
+		}
+	}
+	return nil
+}
+
+// writeAlertError maps alerts errors to HTTP statuses.
+func writeAlertError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, alerts.ErrNotFound):
+		writeError(w, http.StatusNotFound, "Alert not found")
+	case errors.Is(err, alerts.ErrExists):
+		writeError(w, http.StatusConflict, "Alert already exists")
+	case errors.Is(err, alerts.ErrInvalid):
+		writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, "Failed to save alerts")
+	}
+}
@@ -0,0 +1,81 @@
+package handler_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jaggu/vehicle-tracker-prototype/alerts"
+	"github.com/jaggu/vehicle-tracker-prototype/clock"
+	"github.com/jaggu/vehicle-tracker-prototype/gtfs"
+	"github.com/jaggu/vehicle-tracker-prototype/gtfsrt"
+	"github.com/jaggu/vehicle-tracker-prototype/handler"
+	"github.com/jaggu/vehicle-tracker-prototype/store"
+)
+
+func TestAdminAlerts_PublishAndExpire(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1752566400, 0))
+	sched, err := gtfs.OpenSchedule("../gtfs/testdata/kbs", clk, 0)
+	if err != nil {
+		t.Fatalf("OpenSchedule: %v", err)
+	}
+	m, _ := alerts.Open("", clk)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/admin/alerts", handler.AdminAlerts(m, sched))
+	mux.HandleFunc("/api/v1/admin/alerts/{id}", handler.AdminAlert(m, sched))
+	mux.HandleFunc("/api/v1/admin/alerts/{id}/expire", handler.ExpireAlert(m))
+	s := store.New(store.WithClock(clk))
+	defer s.Close()
+	mux.HandleFunc("/gtfs-rt/alerts", handler.GetAlerts(s, &gtfsrt.Builder{Clock: clk, Alerts: m}, nil))
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+	feedAlerts := func() int {
+		var feed struct {
+			Entity []json.RawMessage `json:"entity"`
+		}
+		json.Unmarshal(do(http.MethodGet, "/gtfs-rt/alerts?format=json", "").Body.Bytes(), &feed)
+		return len(feed.Entity)
+	}
+
+	if rec := do(http.MethodPost, "/api/v1/admin/alerts", `{"informed_entities": [{"route_id": "55"}], "header": {"en": "Detour"}}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("route not in the schedule: status = %d, want 400", rec.Code)
+	}
+	rec := do(http.MethodPost, "/api/v1/admin/alerts", `{
+		"id": "detour-5",
+		"informed_entities": [{"route_id": "5"}],
+		"cause": "CONSTRUCTION",
+		"effect": "DETOUR",
+		"header": {"en": "Route 5 detour"}
+	}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create: status = %d, body %s", rec.Code, rec.Body)
+	}
+	if n := feedAlerts(); n != 1 {
+		t.Errorf("feed has %d alerts, want 1", n)
+	}
+
+	if rec := do(http.MethodPut, "/api/v1/admin/alerts/detour-5", `{"description": {"en": "Use Kenyatta Avenue"}}`); rec.Code != http.StatusOK {
+		t.Fatalf("update: status = %d", rec.Code)
+	}
+	if a, _ := m.Get("detour-5"); a.Effect != "DETOUR" || a.Description["en"] != "Use Kenyatta Avenue" {
+		t.Errorf("PUT dropped omitted fields: %+v", a)
+	}
+
+	if rec := do(http.MethodPost, "/api/v1/admin/alerts/detour-5/expire", ""); rec.Code != http.StatusOK {
+		t.Fatalf("expire: status = %d", rec.Code)
+	}
+	clk.Advance(time.Second)
+	if n := feedAlerts(); n != 0 {
+		t.Errorf("feed has %d alerts after expiry, want 0", n)
+	}
+	if rec := do(http.MethodGet, "/api/v1/admin/alerts/detour-5", ""); rec.Code != http.StatusOK {
+		t.Errorf("expired alert: status = %d, want it still listed", rec.Code)
+	}
+}
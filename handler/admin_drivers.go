@@ -0,0 +1,185 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/jaggu/vehicle-tracker-prototype/auth"
+	"github.com/jaggu/vehicle-tracker-prototype/registry"
+	"github.com/jaggu/vehicle-tracker-prototype/trips"
+)
+
+// driverView is how the admin API shows a driver: without the PIN hash,
+// and with the vehicle they are driving right now, if any.
+type driverView struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	Phone      string   `json:"phone"`
+	VehicleIDs []string `json:"vehicle_ids"`
+	Active     bool     `json:"active"`
+	Driving    string   `json:"driving_vehicle_id,omitempty"`
+}
+
+func newDriverView(a *auth.Authenticator, drv auth.Driver) driverView {
+	v := driverView{
+		ID:         drv.ID,
+		Name:       drv.Name,
+		Phone:      drv.Phone,
+		VehicleIDs: drv.VehicleIDs,
+		Active:     drv.Active,
+	}
+	if v.VehicleIDs == nil {
+		v.VehicleIDs = []string{}
+	}
+	v.Driving, _ = a.DrivingVehicle(drv.ID)
+	return v
+}
+
+// driverRequest is the JSON body of POST /api/v1/admin/drivers.  Fields
+// are pointers so that an update can tell an omitted field from a zero
+// one.
+type driverRequest struct {
+	ID         string    `json:"id"`
+	Name       *string   `json:"name"`
+	Phone      *string   `json:"phone"`
+	PIN        *string   `json:"pin"`
+	VehicleIDs *[]string `json:"vehicle_ids"`
+	Active     *bool     `json:"active"`
+}
+
+// AdminDrivers handles /api/v1/admin/drivers.
+//
+// GET lists drivers.  POST creates a driver, or updates the one with the
+// given "id"; on update, fields omitted from the body keep their values.
+// A new driver needs "name", "phone" and "pin".
+//
+// Setting "pin" resets the PIN and signs the driver out of every device.
+// Setting "active": false locks the driver out immediately and releases
+// the vehicle they were driving.  Either ends the trips they started in
+// t, as does unassigning the trip's vehicle; t may be nil.  When a
+// vehicle registry is configured, "vehicle_ids" must name registered
+// vehicles.
+func AdminDrivers(a *auth.Authenticator, vehicles *registry.Registry, t *trips.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			list := a.Drivers().List()
+			views := make([]driverView, len(list))
+			for i, drv := range list {
+				views[i] = newDriverView(a, drv)
+			}
+			writeJSON(w, http.StatusOK, map[string]any{"drivers": views})
+
+		case http.MethodPost:
+			var req driverRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeError(w, http.StatusBadRequest, "Invalid JSON body")
+				return
+			}
+			if req.ID == "" {
+				writeError(w, http.StatusBadRequest, "id is required")
+				return
+			}
+
+			drv, exists := a.Drivers().Get(req.ID)
+			if !exists {
+				if req.Name == nil || req.Phone == nil || req.PIN == nil {
+					writeError(w, http.StatusBadRequest, "name, phone and pin are required for a new driver")
+					return
+				}
+				drv = auth.Driver{ID: req.ID, Active: true}
+			}
+			if err := applyDriverRequest(&drv, req, vehicles); err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+
+			if err := a.Drivers().Put(drv); err != nil {
+				switch {
+				case errors.Is(err, auth.ErrPhoneInUse):
+					writeError(w, http.StatusConflict, err.Error())
+				case errors.Is(err, auth.ErrInvalidDriver):
+					writeError(w, http.StatusBadRequest, err.Error())
+				default:
+					writeError(w, http.StatusInternalServerError, "Failed to save driver")
+				}
+				return
+			}
+
+			// A PIN reset signs the driver out everywhere; that,
+			// deactivation and unassignment free the vehicle they drive.
+			if exists && req.PIN != nil {
+				if err := a.RevokeDriver(drv.ID); err != nil {
+					writeError(w, http.StatusInternalServerError, "Failed to save driver")
+					return
+				}
+			}
+			if v, ok := a.DrivingVehicle(drv.ID); ok && (!drv.Active || !drv.AssignedTo(v) || req.PIN != nil) {
+				a.StopDriving(drv.ID)
+			}
+			if t != nil && exists {
+				if err := endDriverTrips(t, drv, req.PIN != nil); err != nil {
+					writeError(w, http.StatusInternalServerError, "Failed to end trip")
+					return
+				}
+			}
+
+			status := http.StatusOK
+			if !exists {
+				status = http.StatusCreated
+			}
+			writeJSON(w, status, newDriverView(a, drv))
+
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "Only GET and POST are allowed")
+		}
+	}
+}
+
+// endDriverTrips ends the trips drv started on vehicles they may no
+// longer drive: all of them when the driver is inactive or all is set.
+func endDriverTrips(t *trips.Manager, drv auth.Driver, all bool) error {
+	for _, trip := range t.List() {
+		if trip.DriverID != drv.ID || (drv.Active && !all && drv.AssignedTo(trip.VehicleID)) {
+			continue
+		}
+		if _, err := t.End(trip.VehicleID); err != nil && !errors.Is(err, trips.ErrNoActiveTrip) {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyDriverRequest copies the fields present in req onto drv, hashing
+// a new PIN and checking vehicle IDs against the registry.
+func applyDriverRequest(drv *auth.Driver, req driverRequest, vehicles *registry.Registry) error {
+	if req.Name != nil {
+		drv.Name = *req.Name
+	}
+	if req.Phone != nil {
+		drv.Phone = *req.Phone
+	}
+	if req.Active != nil {
+		drv.Active = *req.Active
+	}
+	if req.VehicleIDs != nil {
+		if vehicles != nil {
+			for _, id := range *req.VehicleIDs {
+				if _, ok := vehicles.Get(id); !ok {
+					return fmt.Errorf("vehicle %q is not registered", id)
+				}
+			}
+		}
+		drv.VehicleIDs = *req.VehicleIDs
+	}
+	if req.PIN != nil {
+		hash, err := auth.HashPIN(*req.PIN)
+		if err != nil {
+			return err
+		}
+		drv.PINHash = hash
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package handler_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jaggu/vehicle-tracker-prototype/auth"
+	"github.com/jaggu/vehicle-tracker-prototype/clock"
+	"github.com/jaggu/vehicle-tracker-prototype/handler"
+	"github.com/jaggu/vehicle-tracker-prototype/store"
+	"github.com/jaggu/vehicle-tracker-prototype/trips"
+)
+
+func TestAdminDrivers_AssignmentAndSingleDriverPerVehicle(t *testing.T) {
+	clk := clock.NewFake(testNow)
+	a, err := auth.New(auth.NewDrivers(), auth.Config{Secret: []byte("test-secret"), Clock: clk})
+	if err != nil {
+		t.Fatalf("auth.New: %v", err)
+	}
+	m, _ := trips.Open("", clk, 0)
+	admin := handler.AdminDrivers(a, nil, m)
+
+	s := store.New()
+	defer s.Close()
+	post := handler.RequireDriver(a, handler.PostLocation(s, testValidator(), nil, nil))
+
+	call := func(h http.HandlerFunc, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		return rec
+	}
+	login := func(phone, pin string) string {
+		rec := call(handler.PostLogin(a), "", `{"phone": "`+phone+`", "pin": "`+pin+`"}`)
+		var pair auth.TokenPair
+		json.NewDecoder(rec.Body).Decode(&pair)
+		return pair.AccessToken
+	}
+	report := `{"vehicle_id": "bus-1", "latitude": 17.3, "longitude": 78.4, "timestamp": 1752566390}`
+
+	for _, body := range []string{
+		`{"id": "drv-1", "name": "Ravi", "phone": "+919800000001", "pin": "1111", "vehicle_ids": ["bus-1"]}`,
+		`{"id": "drv-2", "name": "Asha", "phone": "+919800000002", "pin": "2222", "vehicle_ids": ["bus-1", "bus-2"]}`,
+	} {
+		if rec := call(admin, "", body); rec.Code != http.StatusCreated {
+			t.Fatalf("create driver: status = %d, body %s", rec.Code, rec.Body)
+		}
+	}
+	if rec := call(admin, "", `{"id": "drv-3", "name": "X", "phone": "+919800000001", "pin": "3333"}`); rec.Code != http.StatusConflict {
+		t.Errorf("duplicate phone: status = %d, want 409", rec.Code)
+	}
+
+	ravi, asha := login("+919800000001", "1111"), login("+919800000002", "2222")
+	if rec := call(post, ravi, report); rec.Code != http.StatusOK {
+		t.Fatalf("drv-1 report: status = %d, body %s", rec.Code, rec.Body)
+	}
+	if rec := call(post, asha, report); rec.Code != http.StatusConflict {
+		t.Errorf("drv-2 on bus-1 while drv-1 drives it: status = %d, want 409", rec.Code)
+	}
+	m.Start(trips.Trip{VehicleID: "bus-1", RouteID: "5", DriverID: "drv-1"})
+
+	// Taking bus-1 away from drv-1 frees it for drv-2 and locks drv-1 out.
+	if rec := call(admin, "", `{"id": "drv-1", "vehicle_ids": []}`); rec.Code != http.StatusOK {
+		t.Fatalf("unassign: status = %d", rec.Code)
+	}
+	if _, ok := m.Active("bus-1"); ok {
+		t.Error("drv-1's trip on bus-1 is still active after unassignment")
+	}
+	if rec := call(post, asha, report); rec.Code != http.StatusOK {
+		t.Errorf("drv-2 after reassignment: status = %d, want 200", rec.Code)
+	}
+	if rec := call(post, ravi, report); rec.Code != http.StatusForbidden {
+		t.Errorf("unassigned drv-1: status = %d, want 403", rec.Code)
+	}
+
+	// A PIN reset invalidates existing tokens.
+	clk.Advance(time.Second)
+	if rec := call(admin, "", `{"id": "drv-2", "pin": "9999"}`); rec.Code != http.StatusOK {
+		t.Fatalf("PIN reset: status = %d", rec.Code)
+	}
+	if rec := call(post, asha, report); rec.Code != http.StatusUnauthorized {
+		t.Errorf("token after PIN reset: status = %d, want 401", rec.Code)
+	}
+	if login("+919800000002", "9999") == "" {
+		t.Error("login with the new PIN failed")
+	}
+}
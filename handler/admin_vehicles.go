@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/jaggu/vehicle-tracker-prototype/registry"
+)
+
+// vehicleRequest is the JSON body of the vehicle admin endpoints.  Fields
+// are pointers so that PUT can tell an omitted field from a zero one.
+type vehicleRequest struct {
+	ID           *string `json:"id"`
+	Label        *string `json:"label"`
+	LicensePlate *string `json:"license_plate"`
+	AgencyID     *string `json:"agency_id"`
+	Active       *bool   `json:"active"`
+	Capacity     *int    `json:"capacity"`
+}
+
+// applyTo copies the fields present in the request onto v.
+func (req vehicleRequest) applyTo(v *registry.Vehicle) {
+	if req.Label != nil {
+		v.Label = *req.Label
+	}
+	if req.LicensePlate != nil {
+		v.LicensePlate = *req.LicensePlate
+	}
+	if req.AgencyID != nil {
+		v.AgencyID = *req.AgencyID
+	}
+	if req.Active != nil {
+		v.Active = *req.Active
+	}
+	if req.Capacity != nil {
+		v.Capacity = *req.Capacity
+	}
+}
+
+// AdminVehicles handles /api/v1/admin/vehicles.
+//
+// GET lists the registered vehicles.  POST registers a new one from
+// {"id", "label", "license_plate", "agency_id", "active", "capacity"};
+// vehicles are active unless the body says otherwise.
+func AdminVehicles(reg *registry.Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, map[string]any{"vehicles": reg.List()})
+
+		case http.MethodPost:
+			var req vehicleRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeError(w, http.StatusBadRequest, "Invalid JSON body")
+				return
+			}
+			v := registry.Vehicle{Active: true}
+			if req.ID != nil {
+				v.ID = *req.ID
+			}
+			req.applyTo(&v)
+			if err := reg.Create(v); err != nil {
+				writeRegistryError(w, err)
+				return
+			}
+			writeJSON(w, http.StatusCreated, v)
+
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "Only GET and POST are allowed")
+		}
+	}
+}
+
+// AdminVehicle handles /api/v1/admin/vehicles/{id}.
+//
+// GET returns the vehicle.  PUT updates it; fields omitted from the body
+// keep their current values, so {"active": false} deactivates a vehicle.
+// DELETE removes it from the registry.
+func AdminVehicle(reg *registry.Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+
+		switch r.Method {
+		case http.MethodGet:
+			v, ok := reg.Get(id)
+			if !ok {
+				writeRegistryError(w, registry.ErrNotFound)
+				return
+			}
+			writeJSON(w, http.StatusOK, v)
+
+		case http.MethodPut:
+			var req vehicleRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeError(w, http.StatusBadRequest, "Invalid JSON body")
+				return
+			}
+			if req.ID != nil && *req.ID != id {
+				writeError(w, http.StatusBadRequest, "Vehicle id cannot be changed")
+				return
+			}
+			v, ok := reg.Get(id)
+			if !ok {
+				writeRegistryError(w, registry.ErrNotFound)
+				return
+			}
+			req.applyTo(&v)
+			if err := reg.Update(v); err != nil {
+				writeRegistryError(w, err)
+				return
+			}
+			writeJSON(w, http.StatusOK, v)
+
+		case http.MethodDelete:
+			if err := reg.Delete(id); err != nil {
+				writeRegistryError(w, err)
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "Only GET, PUT and DELETE are allowed")
+		}
+	}
+}
+
+// writeRegistryError maps registry errors to HTTP statuses.
+func writeRegistryError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, registry.ErrNotFound):
+		writeError(w, http.StatusNotFound, "Vehicle not found")
+	case errors.Is(err, registry.ErrExists):
+		writeError(w, http.StatusConflict, "Vehicle already exists")
+	case errors.Is(err, registry.ErrInvalid):
+		writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, "Failed to save vehicle registry")
+	}
+}
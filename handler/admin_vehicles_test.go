@@ -0,0 +1,63 @@
+package handler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jaggu/vehicle-tracker-prototype/handler"
+	"github.com/jaggu/vehicle-tracker-prototype/registry"
+	"github.com/jaggu/vehicle-tracker-prototype/store"
+)
+
+func TestAdminVehicles_RegistryGatesIngestion(t *testing.T) {
+	reg, _ := registry.Open("")
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/admin/vehicles", handler.AdminVehicles(reg))
+	mux.HandleFunc("/api/v1/admin/vehicles/{id}", handler.AdminVehicle(reg))
+
+	s := store.New()
+	defer s.Close()
+	v := testValidator()
+	v.Vehicles = reg
+	post := handler.PostLocation(s, v, nil, nil)
+
+	do := func(h http.Handler, method, path, body string) int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec.Code
+	}
+	report := `{"vehicle_id": "bus-42", "latitude": -1.29, "longitude": 36.82, "timestamp": 1752566390}`
+
+	if code := do(post, http.MethodPost, "/api/v1/locations", report); code != http.StatusBadRequest {
+		t.Errorf("unregistered vehicle: status = %d, want 400", code)
+	}
+
+	if code := do(mux, http.MethodPost, "/api/v1/admin/vehicles", `{"id": "bus-42", "label": "Route 5", "capacity": 60}`); code != http.StatusCreated {
+		t.Fatalf("create: status = %d", code)
+	}
+	if code := do(mux, http.MethodPost, "/api/v1/admin/vehicles", `{"id": "bus-42"}`); code != http.StatusConflict {
+		t.Errorf("duplicate create: status = %d, want 409", code)
+	}
+	if code := do(post, http.MethodPost, "/api/v1/locations", report); code != http.StatusOK {
+		t.Errorf("registered vehicle: status = %d, want 200", code)
+	}
+
+	if code := do(mux, http.MethodPut, "/api/v1/admin/vehicles/bus-42", `{"active": false}`); code != http.StatusOK {
+		t.Fatalf("deactivate: status = %d", code)
+	}
+	if veh, _ := reg.Get("bus-42"); veh.Label != "Route 5" || veh.Capacity != 60 {
+		t.Errorf("PUT dropped omitted fields: %+v", veh)
+	}
+	if code := do(post, http.MethodPost, "/api/v1/locations", report); code != http.StatusBadRequest {
+		t.Errorf("deactivated vehicle: status = %d, want 400", code)
+	}
+
+	if code := do(mux, http.MethodDelete, "/api/v1/admin/vehicles/bus-42", ""); code != http.StatusOK {
+		t.Errorf("delete: status = %d", code)
+	}
+	if code := do(mux, http.MethodGet, "/api/v1/admin/vehicles/bus-42", ""); code != http.StatusNotFound {
+		t.Errorf("get after delete: status = %d, want 404", code)
+	}
+}
@@ -0,0 +1,164 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+
+	"github.com/jaggu/vehicle-tracker-prototype/apikey"
+)
+
+// FeedAccess controls who may read the GTFS-RT feed.
+type FeedAccess struct {
+	// Keys checks consumer API keys.  When nil, the feed is public.
+	Keys *apikey.Manager
+
+	// PublicAgencies lists agencies whose feed (?agency=<id>) can be read
+	// without a key.  "*" makes the whole feed public.
+	PublicAgencies []string
+}
+
+// public reports whether the feed for agency may be read without a key.
+func (a FeedAccess) public(agency string) bool {
+	return a.Keys == nil ||
+		slices.Contains(a.PublicAgencies, "*") ||
+		(agency != "" && slices.Contains(a.PublicAgencies, agency))
+}
+
+// feedAgencyKey is the request context key holding the agency a feed
+// request is restricted to.
+type feedAgencyKey struct{}
+
+// feedAgency returns the agency whose vehicles a feed request should
+// contain, or "" for all of them.
+func feedAgency(r *http.Request) string {
+	if agency, ok := r.Context().Value(feedAgencyKey{}).(string); ok {
+		return agency
+	}
+	return r.URL.Query().Get("agency")
+}
+
+// RequireAPIKey wraps a feed handler so that it only runs for requests
+// carrying a valid API key, in the X-API-Key header or the "key" query
+// parameter (for consumers such as OneBusAway that can only be given a
+// URL).  Feeds of public agencies are served without a key.
+//
+// A key issued for one agency only ever sees that agency's vehicles.
+// Unknown keys get a 401, revoked keys and other agencies a 403, and
+// keys over their rate limit a 429.
+func RequireAPIKey(access FeedAccess, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		agency := r.URL.Query().Get("agency")
+		if access.public(agency) {
+			next(w, r)
+			return
+		}
+
+		secret := r.Header.Get("X-API-Key")
+		if secret == "" {
+			secret = r.URL.Query().Get("key")
+		}
+		if secret == "" {
+			writeError(w, http.StatusUnauthorized, "API key required (X-API-Key header or ?key=)")
+			return
+		}
+
+		key, err := access.Keys.Check(secret)
+		switch {
+		case errors.Is(err, apikey.ErrRateLimited):
+			w.Header().Set("Retry-After", "1")
+			writeError(w, http.StatusTooManyRequests, "API key rate limit exceeded")
+			return
+		case errors.Is(err, apikey.ErrRevoked):
+			writeError(w, http.StatusForbidden, "API key has been revoked")
+			return
+		case err != nil:
+			writeError(w, http.StatusUnauthorized, "Invalid API key")
+			return
+		}
+
+		if key.Agency != "" {
+			if agency != "" && agency != key.Agency {
+				writeError(w, http.StatusForbidden, "API key is not valid for this agency")
+				return
+			}
+			agency = key.Agency
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), feedAgencyKey{}, agency)))
+	}
+}
+
+// createAPIKeyRequest is the JSON body of POST /api/v1/admin/api-keys.
+type createAPIKeyRequest struct {
+	Name      string  `json:"name"`
+	Agency    string  `json:"agency"`
+	RateLimit float64 `json:"rate_limit"`
+	Burst     int     `json:"burst"`
+}
+
+// createAPIKeyResponse returns the new key in clear, once.
+type createAPIKeyResponse struct {
+	Key  string     `json:"key"`
+	Info apikey.Key `json:"info"`
+}
+
+// AdminAPIKeys handles /api/v1/admin/api-keys.
+//
+// GET lists all keys with their usage counters.  POST creates a key from
+// {"name", "agency", "rate_limit", "burst"} and returns it in clear; it
+// cannot be retrieved again.
+func AdminAPIKeys(m *apikey.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, map[string]any{"keys": m.List()})
+
+		case http.MethodPost:
+			var req createAPIKeyRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeError(w, http.StatusBadRequest, "Invalid JSON body")
+				return
+			}
+			if req.Name == "" {
+				writeError(w, http.StatusBadRequest, "name is required")
+				return
+			}
+			if req.RateLimit < 0 || req.Burst < 0 {
+				writeError(w, http.StatusBadRequest, "rate_limit and burst must not be negative")
+				return
+			}
+			secret, key, err := m.Create(req.Name, req.Agency, req.RateLimit, req.Burst)
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, "Failed to create API key")
+				return
+			}
+			writeJSON(w, http.StatusCreated, createAPIKeyResponse{Key: secret, Info: key})
+
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "Only GET and POST are allowed")
+		}
+	}
+}
+
+// DeleteAPIKey handles DELETE /api/v1/admin/api-keys/{id}, revoking the
+// key.  The key stays listed with its revocation time.
+func DeleteAPIKey(m *apikey.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			writeError(w, http.StatusMethodNotAllowed, "Only DELETE is allowed")
+			return
+		}
+
+		err := m.Revoke(r.PathValue("id"))
+		switch {
+		case errors.Is(err, apikey.ErrNotFound):
+			writeError(w, http.StatusNotFound, "API key not found")
+		case err != nil:
+			writeError(w, http.StatusInternalServerError, "Failed to revoke API key")
+		default:
+			writeJSON(w, http.StatusOK, map[string]string{"status": "revoked"})
+		}
+	}
+}
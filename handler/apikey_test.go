@@ -0,0 +1,81 @@
+package handler_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jaggu/vehicle-tracker-prototype/apikey"
+	"github.com/jaggu/vehicle-tracker-prototype/clock"
+	"github.com/jaggu/vehicle-tracker-prototype/gtfsrt"
+	"github.com/jaggu/vehicle-tracker-prototype/handler"
+	"github.com/jaggu/vehicle-tracker-prototype/model"
+	"github.com/jaggu/vehicle-tracker-prototype/store"
+)
+
+func TestRequireAPIKey(t *testing.T) {
+	clk := clock.NewFake(testNow)
+	keys, _ := apikey.Open("", clk)
+
+	s := store.New(store.WithClock(clk))
+	defer s.Close()
+	s.UpdateLocation(model.Location{VehicleID: "kbs-1", Latitude: 17.3, Longitude: 78.4})
+	s.UpdateLocation(model.Location{VehicleID: "tsrtc-1", Latitude: 17.4, Longitude: 78.5})
+	agencyOf := func(id string) string { return strings.Split(id, "-")[0] }
+
+	admin := handler.RequireAdmin("admin-secret", handler.AdminAPIKeys(keys))
+	createKey := func(body string) string {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/api-keys", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer admin-secret")
+		rec := httptest.NewRecorder()
+		admin(rec, req)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("create key: status = %d, body %s", rec.Code, rec.Body)
+		}
+		var resp struct{ Key string }
+		json.NewDecoder(rec.Body).Decode(&resp)
+		return resp.Key
+	}
+	allKey := createKey(`{"name": "OneBusAway"}`)
+	kbsKey := createKey(`{"name": "KBS planner", "agency": "kbs"}`)
+
+	feed := handler.RequireAPIKey(
+		handler.FeedAccess{Keys: keys, PublicAgencies: []string{"tsrtc"}},
+		handler.GetGTFSRT(s, &gtfsrt.Builder{Clock: clk}, agencyOf),
+	)
+	get := func(url, header string) (int, int) {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		if header != "" {
+			req.Header.Set("X-API-Key", header)
+		}
+		rec := httptest.NewRecorder()
+		feed(rec, req)
+		if rec.Code != http.StatusOK {
+			return rec.Code, 0
+		}
+		var msg struct{ Entity []json.RawMessage }
+		json.NewDecoder(rec.Body).Decode(&msg)
+		return rec.Code, len(msg.Entity)
+	}
+
+	for _, tc := range []struct {
+		name, url, header string
+		status, entities  int
+	}{
+		{"no key", "/gtfs-rt/vehicle-positions?format=json", "", http.StatusUnauthorized, 0},
+		{"bad key", "/gtfs-rt/vehicle-positions?format=json", "vt_nope", http.StatusUnauthorized, 0},
+		{"key in header", "/gtfs-rt/vehicle-positions?format=json", allKey, http.StatusOK, 2},
+		{"key in query", "/gtfs-rt/vehicle-positions?format=json&key=" + allKey, "", http.StatusOK, 2},
+		{"agency key sees its agency", "/gtfs-rt/vehicle-positions?format=json", kbsKey, http.StatusOK, 1},
+		{"public agency with another agency's key", "/gtfs-rt/vehicle-positions?format=json&agency=tsrtc&key=" + kbsKey, "", http.StatusOK, 1},
+		{"public agency without key", "/gtfs-rt/vehicle-positions?format=json&agency=tsrtc", "", http.StatusOK, 1},
+		{"private agency without key", "/gtfs-rt/vehicle-positions?format=json&agency=kbs", "", http.StatusUnauthorized, 0},
+	} {
+		status, entities := get(tc.url, tc.header)
+		if status != tc.status || entities != tc.entities {
+			t.Errorf("%s: status %d with %d entities, want %d with %d", tc.name, status, entities, tc.status, tc.entities)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jaggu/vehicle-tracker-prototype/auth"
+	"github.com/jaggu/vehicle-tracker-prototype/trips"
+)
+
+// loginRequest is the JSON body of POST /api/v1/auth/login.
+type loginRequest struct {
+	Phone string `json:"phone"`
+	PIN   string `json:"pin"`
+}
+
+// refreshRequest is the JSON body of POST /api/v1/auth/refresh and the
+// optional body of POST /api/v1/auth/logout.
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// PostLogin handles POST /api/v1/auth/login.
+//
+// It expects {"phone": ..., "pin": ...} and responds with an access and
+// refresh token.  Wrong credentials get a 401; a deactivated driver with
+// the right PIN gets a 403 so the app can tell them to call the depot.
+// After too many wrong PINs for the phone number or from the client's
+// address, attempts get a 429 with Retry-After until the lockout ends.
+func PostLogin(a *auth.Authenticator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "Only POST is allowed")
+			return
+		}
+
+		var req loginRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid JSON body")
+			return
+		}
+		if req.Phone == "" || req.PIN == "" {
+			writeError(w, http.StatusBadRequest, "phone and pin are required")
+			return
+		}
+
+		pair, err := a.Login(req.Phone, req.PIN, clientAddr(r))
+		if err != nil {
+			writeAuthError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, pair)
+	}
+}
+
+// PostRefresh handles POST /api/v1/auth/refresh.
+//
+// It exchanges {"refresh_token": ...} for a new token pair.  Refresh
+// tokens are single-use: the one presented is revoked.
+func PostRefresh(a *auth.Authenticator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "Only POST is allowed")
+			return
+		}
+
+		var req refreshRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+			writeError(w, http.StatusBadRequest, "refresh_token is required")
+			return
+		}
+
+		pair, err := a.Refresh(req.RefreshToken)
+		if err != nil {
+			writeAuthError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, pair)
+	}
+}
+
+// PostLogout handles POST /api/v1/auth/logout.
+//
+// It revokes the bearer access token and, if the body carries one, the
+// refresh token, so a lost phone can't keep reporting.  The driver's
+// vehicle is released for the next driver, and the trips they started
+// in t end.  t may be nil.
+func PostLogout(a *auth.Authenticator, t *trips.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "Only POST is allowed")
+			return
+		}
+
+		token, ok := bearerToken(r)
+		if !ok {
+			writeAuthError(w, auth.ErrInvalidToken)
+			return
+		}
+		if drv, err := a.Authenticate(token); err == nil {
+			a.StopDriving(drv.ID)
+			if t != nil {
+				if _, err := t.EndDriver(drv.ID); err != nil {
+					writeError(w, http.StatusInternalServerError, "Failed to end trip")
+					return
+				}
+			}
+		}
+		if err := a.Revoke(token); err != nil {
+			writeAuthError(w, err)
+			return
+		}
+
+		// The refresh token is optional; a bad one doesn't undo the logout.
+		var req refreshRequest
+		if json.NewDecoder(r.Body).Decode(&req) == nil && req.RefreshToken != "" {
+			a.Revoke(req.RefreshToken) //nolint: errcheck
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	}
+}
+
+// driverKey is the request context key holding the driverSession of an
+// authenticated request.
+type driverKey struct{}
+
+// driverSession is the authenticated driver and the Authenticator that
+// tracks which vehicle they are driving.
+type driverSession struct {
+	driver auth.Driver
+	auth   *auth.Authenticator
+}
+
+// RequireDriver wraps a driver-facing handler so that it only runs for
+// requests carrying a valid access token in an "Authorization: Bearer"
+// header.  The driver is stored in the request context, where the
+// ingestion handlers check vehicle assignments.
+//
+// A nil Authenticator disables the check, for local development.
+func RequireDriver(a *auth.Authenticator, next http.HandlerFunc) http.HandlerFunc {
+	if a == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := bearerToken(r)
+		if !ok {
+			writeAuthError(w, auth.ErrInvalidToken)
+			return
+		}
+		drv, err := a.Authenticate(token)
+		if err != nil {
+			writeAuthError(w, err)
+			return
+		}
+		sess := driverSession{driver: drv, auth: a}
+		next(w, r.WithContext(context.WithValue(r.Context(), driverKey{}, sess)))
+	}
+}
+
+// checkReporter decides whether the request's driver may report for
+// vehicleID: they must be assigned to it, and no other driver may be
+// driving it.  A successful check renews the driver's claim on the
+// vehicle.  It returns 0 when the report may proceed, otherwise an HTTP
+// status and message.
+//
+// Requests that went through no authentication (RequireDriver with a nil
+// Authenticator) may report for any vehicle.
+func checkReporter(r *http.Request, vehicleID string) (int, string) {
+	sess, ok := r.Context().Value(driverKey{}).(driverSession)
+	if !ok {
+		return 0, ""
+	}
+	if !sess.driver.AssignedTo(vehicleID) {
+		return http.StatusForbidden, "Driver is not assigned to this vehicle"
+	}
+	if err := sess.auth.Drive(sess.driver.ID, vehicleID); err != nil {
+		return http.StatusConflict, "Vehicle is being driven by another driver"
+	}
+	return 0, ""
+}
+
+// clientAddr returns the IP address a request came from.  Proxy headers
+// are not trusted, as anyone can set them.
+func clientAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// bearerToken extracts the token from an "Authorization: Bearer" header.
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// writeAuthError maps auth errors to 401, 403 or 429.
+func writeAuthError(w http.ResponseWriter, err error) {
+	var throttled *auth.ThrottledError
+	switch {
+	case errors.As(err, &throttled):
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+		writeError(w, http.StatusTooManyRequests, "Too many failed login attempts; try again later")
+	case errors.Is(err, auth.ErrInactiveDriver):
+		writeError(w, http.StatusForbidden, "Driver account is deactivated")
+	case errors.Is(err, auth.ErrInvalidCredentials):
+		writeError(w, http.StatusUnauthorized, "Invalid phone number or PIN")
+	default:
+		w.Header().Set("WWW-Authenticate", `Bearer realm="vehicle-tracker"`)
+		writeError(w, http.StatusUnauthorized, "Missing, invalid or expired token")
+	}
+}
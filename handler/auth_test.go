@@ -0,0 +1,110 @@
+package handler_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jaggu/vehicle-tracker-prototype/auth"
+	"github.com/jaggu/vehicle-tracker-prototype/clock"
+	"github.com/jaggu/vehicle-tracker-prototype/handler"
+	"github.com/jaggu/vehicle-tracker-prototype/store"
+	"github.com/jaggu/vehicle-tracker-prototype/trips"
+)
+
+func TestDriverAuth_LoginReportLogout(t *testing.T) {
+	hash, err := auth.HashPIN("4321")
+	if err != nil {
+		t.Fatalf("HashPIN: %v", err)
+	}
+	drivers := auth.NewDrivers()
+	drivers.Put(auth.Driver{ID: "drv-1", Phone: "+919800000001", PINHash: hash, VehicleIDs: []string{"bus-1"}, Active: true})
+	a, err := auth.New(drivers, auth.Config{Secret: []byte("test-secret"), Clock: clock.NewFake(testNow)})
+	if err != nil {
+		t.Fatalf("auth.New: %v", err)
+	}
+
+	s := store.New()
+	defer s.Close()
+	m, _ := trips.Open("", clock.NewFake(testNow), 0)
+	m.Start(trips.Trip{VehicleID: "bus-1", RouteID: "5", DriverID: "drv-1"})
+	post := handler.RequireDriver(a, handler.PostLocation(s, testValidator(), m, nil))
+
+	call := func(h http.HandlerFunc, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		return rec
+	}
+	report := func(vehicleID string) string {
+		return `{"vehicle_id": "` + vehicleID + `", "latitude": 17.3, "longitude": 78.4, "timestamp": 1752566390}`
+	}
+
+	if rec := call(handler.PostLogin(a), "/api/v1/auth/login", "", `{"phone": "+919800000001", "pin": "0000"}`); rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong PIN: status = %d, want 401", rec.Code)
+	}
+	rec := call(handler.PostLogin(a), "/api/v1/auth/login", "", `{"phone": "+919800000001", "pin": "4321"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login: status = %d, body %s", rec.Code, rec.Body)
+	}
+	var pair auth.TokenPair
+	if err := json.NewDecoder(rec.Body).Decode(&pair); err != nil || pair.AccessToken == "" {
+		t.Fatalf("login response: %+v, %v", pair, err)
+	}
+
+	if rec := call(post, "/api/v1/locations", "", report("bus-1")); rec.Code != http.StatusUnauthorized {
+		t.Errorf("no token: status = %d, want 401", rec.Code)
+	}
+	if rec := call(post, "/api/v1/locations", pair.AccessToken, report("bus-2")); rec.Code != http.StatusForbidden {
+		t.Errorf("unassigned vehicle: status = %d, want 403", rec.Code)
+	}
+	if rec := call(post, "/api/v1/locations", pair.AccessToken, report("bus-1")); rec.Code != http.StatusOK {
+		t.Errorf("assigned vehicle: status = %d, body %s", rec.Code, rec.Body)
+	}
+
+	if rec := call(handler.PostLogout(a, m), "/api/v1/auth/logout", pair.AccessToken, ""); rec.Code != http.StatusOK {
+		t.Fatalf("logout: status = %d", rec.Code)
+	}
+	if _, ok := m.Active("bus-1"); ok {
+		t.Error("the driver's trip is still active after logout")
+	}
+	if rec := call(post, "/api/v1/locations", pair.AccessToken, report("bus-1")); rec.Code != http.StatusUnauthorized {
+		t.Errorf("after logout: status = %d, want 401", rec.Code)
+	}
+}
+
+func TestPostLogin_LocksOutAfterWrongPINs(t *testing.T) {
+	hash, err := auth.HashPIN("4321")
+	if err != nil {
+		t.Fatalf("HashPIN: %v", err)
+	}
+	drivers := auth.NewDrivers()
+	drivers.Put(auth.Driver{ID: "drv-1", Phone: "+919800000001", PINHash: hash, Active: true})
+	a, err := auth.New(drivers, auth.Config{Secret: []byte("test-secret"), MaxLoginFailures: 2, Clock: clock.NewFake(testNow)})
+	if err != nil {
+		t.Fatalf("auth.New: %v", err)
+	}
+
+	login := func(pin string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.PostLogin(a)(rec, httptest.NewRequest(http.MethodPost, "/api/v1/auth/login", strings.NewReader(`{"phone": "+919800000001", "pin": "`+pin+`"}`)))
+		return rec
+	}
+	for i := 0; i < 2; i++ {
+		if rec := login("0000"); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("wrong PIN %d: status = %d, want 401", i+1, rec.Code)
+		}
+	}
+	rec := login("4321")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("locked out: status = %d, want 429", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "900" {
+		t.Errorf("Retry-After = %q, want 900", got)
+	}
+}
@@ -0,0 +1,149 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/jaggu/vehicle-tracker-prototype/match"
+	"github.com/jaggu/vehicle-tracker-prototype/model"
+	"github.com/jaggu/vehicle-tracker-prototype/store"
+	"github.com/jaggu/vehicle-tracker-prototype/trips"
+)
+
+// BatchConfig limits the size of POST /api/v1/locations/batch requests.
+type BatchConfig struct {
+	// MaxItems is the largest number of locations accepted in one batch.
+	MaxItems int
+
+	// MaxBodyBytes caps the request body; larger bodies are rejected
+	// before they are decoded.
+	MaxBodyBytes int64
+}
+
+// DefaultBatchConfig allows a phone to flush several minutes of 1 Hz
+// fixes in one request.
+var DefaultBatchConfig = BatchConfig{
+	MaxItems:     500,
+	MaxBodyBytes: 1 << 20,
+}
+
+// batchItemResult reports the outcome of one location in a batch.
+// Status is the same value PostLocation would return ("ok",
+// "ignored_stale", ...), "invalid" for a rejected report, "forbidden"
+// for a vehicle the driver is not assigned to, "conflict" for a vehicle
+// another driver is driving, or "error" when storing it failed.
+// Warnings names a trip_id or route_id dropped as in PostLocation.
+type batchItemResult struct {
+	Index    int                   `json:"index"`
+	Status   string                `json:"status"`
+	Error    string                `json:"error,omitempty"`
+	Fields   model.ValidationError `json:"fields,omitempty"`
+	Warnings model.ValidationError `json:"warnings,omitempty"`
+}
+
+// batchResponse is the JSON shape returned by POST /api/v1/locations/batch.
+type batchResponse struct {
+	Results []batchItemResult `json:"results"`
+}
+
+// PostLocationBatch handles POST /api/v1/locations/batch.
+//
+// It expects a JSON array of location reports, possibly from several
+// vehicles, so that a phone on a slow network can send many GPS fixes in
+// one request.  Each report is validated independently; valid reports are
+// stored in timestamp order, so the newest fix of each vehicle ends up as
+// its latest location.
+//
+// The response always lists one result per input item, in input order.
+// A malformed body or one over the configured limits fails as a whole.
+// Reports inherit the active trip from t, and are placed on their trip
+// by p, as in PostLocation.
+func PostLocationBatch(s store.Store, v *Validator, t *trips.Manager, p *match.Placer, cfg BatchConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		// Only accept POST
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "Only POST is allowed")
+			return
+		}
+
+		// Decode request body, bounded by the configured limit
+		var locs []model.Location
+		r.Body = http.MaxBytesReader(w, r.Body, cfg.MaxBodyBytes)
+		if err := json.NewDecoder(r.Body).Decode(&locs); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				writeError(w, http.StatusRequestEntityTooLarge,
+					fmt.Sprintf("Request body exceeds %d bytes", cfg.MaxBodyBytes))
+				return
+			}
+			writeError(w, http.StatusBadRequest, "Invalid JSON body: expected an array of locations")
+			return
+		}
+		if len(locs) == 0 {
+			writeError(w, http.StatusBadRequest, "Batch must contain at least one location")
+			return
+		}
+		if len(locs) > cfg.MaxItems {
+			writeError(w, http.StatusRequestEntityTooLarge,
+				fmt.Sprintf("Batch exceeds %d locations", cfg.MaxItems))
+			return
+		}
+
+		// Validate each report independently
+		results := make([]batchItemResult, len(locs))
+		valid := make([]int, 0, len(locs))
+		for i := range locs {
+			results[i].Index = i
+			locs[i].Match = nil
+			if t != nil {
+				locs[i] = t.Inherit(locs[i])
+			}
+			locs[i], results[i].Warnings = v.dropUnknownTrip(locs[i])
+			loc := locs[i]
+			if errs := v.validate(loc); errs != nil {
+				results[i].Status = "invalid"
+				results[i].Error = "Invalid location report"
+				results[i].Fields = errs
+				continue
+			}
+			if status, msg := checkReporter(r, loc.VehicleID); status != 0 {
+				results[i].Status = "forbidden"
+				if status == http.StatusConflict {
+					results[i].Status = "conflict"
+				}
+				results[i].Error = msg
+				continue
+			}
+			valid = append(valid, i)
+		}
+
+		// Store oldest first so out-of-order fixes within the batch
+		// don't shadow newer ones
+		sort.SliceStable(valid, func(a, b int) bool {
+			return locs[valid[a]].Timestamp < locs[valid[b]].Timestamp
+		})
+		for _, i := range valid {
+			res, err := s.UpdateLocation(locs[i])
+			if err != nil {
+				results[i].Status = "error"
+				results[i].Error = "Failed to store location"
+				continue
+			}
+			if t != nil {
+				t.Seen(locs[i].VehicleID)
+			}
+			if res == store.Accepted {
+				if m := p.Place(locs[i]); m != nil {
+					s.SetMatch(locs[i].VehicleID, locs[i].Timestamp, m)
+				}
+			}
+			results[i].Status = res.String()
+		}
+
+		writeJSON(w, http.StatusOK, batchResponse{Results: results})
+	}
+}
@@ -0,0 +1,107 @@
+package handler_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jaggu/vehicle-tracker-prototype/clock"
+	"github.com/jaggu/vehicle-tracker-prototype/handler"
+	"github.com/jaggu/vehicle-tracker-prototype/model"
+	"github.com/jaggu/vehicle-tracker-prototype/store"
+)
+
+// testNow is the server time used by handler tests; report timestamps in
+// the fixtures are a few seconds before it.
+var testNow = time.Unix(1752566400, 0)
+
+func testValidator() *handler.Validator {
+	return handler.NewValidator(model.DefaultValidationConfig, clock.NewFake(testNow))
+}
+
+type batchResult struct {
+	Index  int                `json:"index"`
+	Status string             `json:"status"`
+	Error  string             `json:"error"`
+	Fields []model.FieldError `json:"fields"`
+}
+
+func postBatch(t *testing.T, h http.HandlerFunc, body string) (*httptest.ResponseRecorder, []batchResult) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/locations/batch", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	h(rec, req)
+
+	var resp struct {
+		Results []batchResult `json:"results"`
+	}
+	if rec.Code == http.StatusOK {
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+	}
+	return rec, resp.Results
+}
+
+func TestPostLocationBatch_PerItemResults(t *testing.T) {
+	s := store.New()
+	defer s.Close()
+	h := handler.PostLocationBatch(s, testValidator(), nil, nil, handler.DefaultBatchConfig)
+
+	// Out of timestamp order, with one invalid item and two vehicles.
+	rec, results := postBatch(t, h, `[
+		{"vehicle_id": "bus-1", "latitude": 17.3, "longitude": 78.3, "timestamp": 1752566390},
+		{"vehicle_id": "bus-1", "latitude": 17.1, "longitude": 78.1, "timestamp": 1752566370},
+		{"vehicle_id": "",      "latitude": 97.2, "longitude": 78.2, "timestamp": 1752566380},
+		{"vehicle_id": "bus-2", "latitude": 18.0, "longitude": 79.0, "timestamp": 1752566375}
+	]`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	if len(results) != 4 {
+		t.Fatalf("got %d results, want 4", len(results))
+	}
+	want := []string{"ok", "ok", "invalid", "ok"}
+	for i, r := range results {
+		if r.Index != i || r.Status != want[i] {
+			t.Errorf("results[%d] = %+v, want index %d status %q", i, r, i, want[i])
+		}
+	}
+	if len(results[2].Fields) != 2 {
+		t.Errorf("invalid item fields = %v, want vehicle_id and latitude errors", results[2].Fields)
+	}
+
+	// Items were applied oldest first, so the newest fix is the latest.
+	for _, loc := range s.GetAllLocations() {
+		if loc.VehicleID == "bus-1" && loc.Timestamp != 1752566390 {
+			t.Errorf("bus-1 latest timestamp = %d, want 1752566390", loc.Timestamp)
+		}
+	}
+}
+
+func TestPostLocationBatch_Limits(t *testing.T) {
+	s := store.New()
+	defer s.Close()
+	h := handler.PostLocationBatch(s, testValidator(), nil, nil, handler.BatchConfig{MaxItems: 1, MaxBodyBytes: 200})
+
+	rec, _ := postBatch(t, h, `[
+		{"vehicle_id": "bus-1", "latitude": 17.1, "longitude": 78.1},
+		{"vehicle_id": "bus-1", "latitude": 17.2, "longitude": 78.2}
+	]`)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("too many items: status = %d, want 413", rec.Code)
+	}
+
+	rec, _ = postBatch(t, h, `[{"vehicle_id": "`+strings.Repeat("x", 300)+`", "latitude": 1, "longitude": 1}]`)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized body: status = %d, want 413", rec.Code)
+	}
+
+	rec, _ = postBatch(t, h, `{"vehicle_id": "bus-1"}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("non-array body: status = %d, want 400", rec.Code)
+	}
+}
@@ -2,9 +2,11 @@ package handler
 
 import (
 	"net/http"
+	"slices"
 
 	"github.com/jaggu/vehicle-tracker-prototype/gtfsrt"
 	"github.com/jaggu/vehicle-tracker-prototype/model"
+	pb "github.com/jaggu/vehicle-tracker-prototype/proto/gtfsrt"
 	"github.com/jaggu/vehicle-tracker-prototype/store"
 	"google.golang.org/protobuf/encoding/protojson"
 )
@@ -20,7 +22,24 @@ import (
 // Only vehicles that have reported within the staleness threshold are
 // included.  A feed with zero active vehicles is still valid — it returns
 // a FeedMessage with an empty entity list.
-func GetGTFSRT(s *store.MemoryStore) http.HandlerFunc {
+//
+// ?agency=<id> (or an agency-scoped API key, see RequireAPIKey) limits
+// the feed to the vehicles agencyOf assigns to that agency.
+func GetGTFSRT(s store.Store, b *gtfsrt.Builder, agencyOf func(vehicleID string) string) http.HandlerFunc {
+	return getFeed(s, b.Build, agencyOf)
+}
+
+// GetTripUpdates handles GET /gtfs-rt/trip-updates.
+//
+// It serves the TripUpdates feed built by b.BuildTripUpdates: predicted
+// arrivals and departures for vehicles matched to a scheduled trip.  The
+// format, staleness and agency handling are those of GetGTFSRT.
+func GetTripUpdates(s store.Store, b *gtfsrt.Builder, agencyOf func(vehicleID string) string) http.HandlerFunc {
+	return getFeed(s, b.BuildTripUpdates, agencyOf)
+}
+
+// getFeed serves the feed build makes from the active vehicles.
+func getFeed(s store.Store, build func([]model.Location) *pb.FeedMessage, agencyOf func(vehicleID string) string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		// Only accept GET
@@ -31,36 +50,145 @@ func GetGTFSRT(s *store.MemoryStore) http.HandlerFunc {
 
 		// Fetch only active (non-stale) vehicles
 		locations := s.GetActiveLocations(model.DefaultStalenessThreshold)
+		if agency := feedAgency(r); agency != "" {
+			locations = filterAgency(locations, agency, agencyOf)
+		}
 
 		// Build the GTFS-RT FeedMessage
-		feed := gtfsrt.BuildFeed(locations)
-
-		// Check if the caller wants JSON output for debugging
-		if r.URL.Query().Get("format") == "json" {
-			marshaler := protojson.MarshalOptions{
-				EmitUnpopulated: false,
-				Indent:          "  ",
-			}
-			jsonBytes, err := marshaler.Marshal(feed)
-			if err != nil {
-				writeError(w, http.StatusInternalServerError, "Failed to marshal feed to JSON")
-				return
-			}
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusOK)
-			w.Write(jsonBytes)
+		feed := build(locations)
+
+		writeFeed(w, r, feed)
+	}
+}
+
+// GetAlerts handles GET /gtfs-rt/alerts.
+//
+// It serves the service alerts in effect or still to come, in the
+// formats GetGTFSRT offers.  ?agency=<id> (or an agency-scoped API key)
+// keeps the alerts about that agency.  Without a schedule, the routes an
+// agency runs are those its active vehicles in s report.
+func GetAlerts(s store.Store, b *gtfsrt.Builder, agencyOf func(vehicleID string) string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "Only GET is allowed")
+			return
+		}
+		sel := gtfsrt.Selection{Kinds: []gtfsrt.Kind{gtfsrt.Alerts}, Agency: feedAgency(r)}
+		writeFeed(w, r, buildSelected(s, b, agencyOf, sel))
+	}
+}
+
+// GetCombinedFeed handles GET /gtfs-rt/feed.
+//
+// It serves vehicle positions, trip updates and alerts together in one
+// FeedMessage (see gtfsrt.Builder.BuildCombined), in the formats
+// GetGTFSRT offers.  Query parameters narrow it:
+//
+//	?include=vehicle_positions,alerts   only these kinds
+//	?exclude=trip_updates               every kind but these
+//	?route_id=5                         vehicles, trip updates and alerts of route 5
+//	?agency=kbs                         one agency's vehicles and alerts
+//
+// An agency-scoped API key always limits the feed to its agency.
+func GetCombinedFeed(s store.Store, b *gtfsrt.Builder, agencyOf func(vehicleID string) string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "Only GET is allowed")
 			return
 		}
 
-		// Default: binary protobuf
-		data, err := gtfsrt.Marshal(feed)
+		sel, err := feedSelection(r)
 		if err != nil {
-			writeError(w, http.StatusInternalServerError, "Failed to marshal feed")
+			writeError(w, http.StatusBadRequest, err.Error())
 			return
 		}
+		writeFeed(w, r, buildSelected(s, b, agencyOf, sel))
+	}
+}
+
+// feedSelection reads the combined feed's query parameters.
+func feedSelection(r *http.Request) (gtfsrt.Selection, error) {
+	q := r.URL.Query()
+	kinds, err := selectKinds(q.Get("include"), q.Get("exclude"))
+	if err != nil {
+		return gtfsrt.Selection{}, err
+	}
+	return gtfsrt.Selection{Kinds: kinds, RouteID: q.Get("route_id"), Agency: feedAgency(r)}, nil
+}
+
+// buildSelected builds the combined feed sel selects from the active
+// vehicles.
+func buildSelected(s store.Store, b *gtfsrt.Builder, agencyOf func(vehicleID string) string, sel gtfsrt.Selection) *pb.FeedMessage {
+	locations := s.GetActiveLocations(model.DefaultStalenessThreshold)
+	if sel.Agency != "" {
+		locations = filterAgency(locations, sel.Agency, agencyOf)
+	}
+	return b.BuildCombined(locations, sel)
+}
+
+// selectKinds returns the entity kinds in include, or every kind when it
+// is empty, less those in exclude.
+func selectKinds(include, exclude string) ([]gtfsrt.Kind, error) {
+	kinds := gtfsrt.AllKinds
+	if include != "" {
+		var err error
+		if kinds, err = gtfsrt.ParseKinds(include); err != nil {
+			return nil, err
+		}
+	}
+	excluded, err := gtfsrt.ParseKinds(exclude)
+	if err != nil {
+		return nil, err
+	}
+	kept := []gtfsrt.Kind{}
+	for _, k := range kinds {
+		if !slices.Contains(excluded, k) {
+			kept = append(kept, k)
+		}
+	}
+	return kept, nil
+}
 
-		w.Header().Set("Content-Type", "application/x-protobuf")
+// writeFeed writes feed as binary protobuf, or as JSON when the request
+// has ?format=json.
+func writeFeed(w http.ResponseWriter, r *http.Request, feed *pb.FeedMessage) {
+	// Check if the caller wants JSON output for debugging
+	if r.URL.Query().Get("format") == "json" {
+		marshaler := protojson.MarshalOptions{
+			EmitUnpopulated: false,
+			Indent:          "  ",
+		}
+		jsonBytes, err := marshaler.Marshal(feed)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to marshal feed to JSON")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
-		w.Write(data)
+		w.Write(jsonBytes)
+		return
+	}
+
+	// Default: binary protobuf
+	data, err := gtfsrt.Marshal(feed)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to marshal feed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// filterAgency keeps the locations of vehicles belonging to agency.
+// Without an agencyOf lookup no vehicle belongs to any agency.
+func filterAgency(locs []model.Location, agency string, agencyOf func(string) string) []model.Location {
+	kept := locs[:0]
+	for _, loc := range locs {
+		if agencyOf != nil && agencyOf(loc.VehicleID) == agency {
+			kept = append(kept, loc)
+		}
 	}
+	return kept
 }
@@ -0,0 +1,86 @@
+package handler_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jaggu/vehicle-tracker-prototype/alerts"
+	"github.com/jaggu/vehicle-tracker-prototype/clock"
+	"github.com/jaggu/vehicle-tracker-prototype/gtfs"
+	"github.com/jaggu/vehicle-tracker-prototype/gtfsrt"
+	"github.com/jaggu/vehicle-tracker-prototype/handler"
+	"github.com/jaggu/vehicle-tracker-prototype/match"
+	"github.com/jaggu/vehicle-tracker-prototype/model"
+	"github.com/jaggu/vehicle-tracker-prototype/store"
+)
+
+func TestGetCombinedFeed(t *testing.T) {
+	clk := clock.NewFake(time.Date(2026, 7, 15, 5, 6, 0, 0, time.UTC)) // 08:06 in Nairobi
+	sched, err := gtfs.OpenSchedule("../gtfs/testdata/kbs", clk, 0)
+	if err != nil {
+		t.Fatalf("OpenSchedule: %v", err)
+	}
+	notices, _ := alerts.Open("", clk)
+	notices.Create(alerts.Alert{ID: "detour-5", InformedEntities: []alerts.Entity{{RouteID: "5"}}, Header: alerts.Text{"en": "Detour"}})
+
+	s := store.New(store.WithClock(clk))
+	defer s.Close()
+	v := handler.NewValidator(model.DefaultValidationConfig, clk)
+	post := handler.PostLocation(s, v, nil, &match.Placer{Clock: clk, Schedule: sched, Matcher: match.NewMatcher(match.Config{})})
+	now := strconv.FormatInt(clk.Now().Unix(), 10)
+	for _, body := range []string{
+		`{"vehicle_id": "kbs-1", "trip_id": "5_0800_out", "latitude": -1.29, "longitude": 36.8095, "timestamp": ` + now + `}`,
+		`{"vehicle_id": "tsrtc-1", "route_id": "9", "latitude": 17.4, "longitude": 78.5, "timestamp": ` + now + `}`,
+	} {
+		rec := httptest.NewRecorder()
+		post(rec, httptest.NewRequest(http.MethodPost, "/api/v1/locations", strings.NewReader(body)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("report %s: status %d, body %s", body, rec.Code, rec.Body)
+		}
+	}
+	agencyOf := func(id string) string { return strings.Split(id, "-")[0] }
+
+	b := &gtfsrt.Builder{Clock: clk, Schedule: sched, Alerts: notices}
+	feed := handler.GetCombinedFeed(s, b, agencyOf)
+	get := func(query string) (int, string) {
+		rec := httptest.NewRecorder()
+		feed(rec, httptest.NewRequest(http.MethodGet, "/gtfs-rt/feed?format=json"+query, nil))
+		var msg struct{ Entity []struct{ ID string } }
+		json.NewDecoder(rec.Body).Decode(&msg)
+		var ids []string
+		for _, e := range msg.Entity {
+			ids = append(ids, e.ID)
+		}
+		return rec.Code, strings.Join(ids, " ")
+	}
+
+	for _, tc := range []struct {
+		name, query string
+		status      int
+		ids         string
+	}{
+		{"everything", "", http.StatusOK, "vehicle-kbs-1 vehicle-tsrtc-1 trip-update-kbs-1 alert-detour-5"},
+		{"include", "&include=vehicle_positions", http.StatusOK, "vehicle-kbs-1 vehicle-tsrtc-1"},
+		{"exclude", "&exclude=vehicle_positions,trip_updates", http.StatusOK, "alert-detour-5"},
+		{"everything excluded", "&exclude=vehicle_positions,trip_updates,alerts", http.StatusOK, ""},
+		{"route", "&route_id=5", http.StatusOK, "vehicle-kbs-1 trip-update-kbs-1 alert-detour-5"},
+		{"other route", "&route_id=9", http.StatusOK, "vehicle-tsrtc-1"},
+		{"agency", "&agency=kbs", http.StatusOK, "vehicle-kbs-1 trip-update-kbs-1 alert-detour-5"},
+		{"unknown kind", "&include=vehicles", http.StatusBadRequest, ""},
+	} {
+		status, ids := get(tc.query)
+		if status != tc.status || ids != tc.ids {
+			t.Errorf("%s: status %d with %q, want %d with %q", tc.name, status, ids, tc.status, tc.ids)
+		}
+	}
+
+	// Entity IDs don't depend on the request.
+	if _, again := get(""); again != "vehicle-kbs-1 vehicle-tsrtc-1 trip-update-kbs-1 alert-detour-5" {
+		t.Errorf("second request: %q", again)
+	}
+}
@@ -3,6 +3,8 @@ package handler
 import (
 	"encoding/json"
 	"net/http"
+
+	"github.com/jaggu/vehicle-tracker-prototype/model"
 )
 
 //Shared helpers used by all handlers 
@@ -18,3 +20,17 @@ func writeJSON(w http.ResponseWriter, status int, v any) {
 func writeError(w http.ResponseWriter, status int, message string) {
 	writeJSON(w, status, map[string]string{"error": message})
 }
+
+// validationErrorResponse is the JSON shape of a 400 caused by invalid fields.
+type validationErrorResponse struct {
+	Error  string                `json:"error"`
+	Fields model.ValidationError `json:"fields"`
+}
+
+// writeValidationError sends a 400 listing every invalid field.
+func writeValidationError(w http.ResponseWriter, errs model.ValidationError) {
+	writeJSON(w, http.StatusBadRequest, validationErrorResponse{
+		Error:  "Invalid location report",
+		Fields: errs,
+	})
+}
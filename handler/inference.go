@@ -0,0 +1,42 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/jaggu/vehicle-tracker-prototype/match"
+)
+
+// inferenceResponse is the JSON shape returned by
+// GET /api/v1/admin/inference.
+type inferenceResponse struct {
+	Count     int               `json:"count"`
+	Decisions []match.Inference `json:"decisions"`
+}
+
+// GetInference handles GET /api/v1/admin/inference.
+//
+// It lists the latest trip inference decision for each vehicle without a
+// trip, with the candidate runs and their scores, so operators can see
+// why a trip was or wasn't published.  ?vehicle_id= limits the list to
+// one vehicle.  Decisions are made as reports are accepted, by the
+// match.Placer the location handlers use.
+func GetInference(in *match.Inferrer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "Only GET is allowed")
+			return
+		}
+
+		decisions := in.Decisions()
+		if id := r.URL.Query().Get("vehicle_id"); id != "" {
+			kept := []match.Inference{}
+			for _, d := range decisions {
+				if d.VehicleID == id {
+					kept = append(kept, d)
+				}
+			}
+			decisions = kept
+		}
+		writeJSON(w, http.StatusOK, inferenceResponse{Count: len(decisions), Decisions: decisions})
+	}
+}
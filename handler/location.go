@@ -1,23 +1,48 @@
 // Package handler implements the HTTP handlers for the vehicle tracker API.
 //
 // Handlers:
-//   - PostLocation:  accepts a vehicle's GPS update       (POST /location)
-//   - GetVehicles:   returns all known vehicle locations   (GET  /vehicles)
+//   - PostLocation:      accepts a vehicle's GPS update       (POST /location)
+//   - PostLocationBatch: accepts many GPS updates at once     (POST /api/v1/locations/batch)
+//   - GetVehicles:       returns all known vehicle locations   (GET  /vehicles)
+//   - PostLogin:         exchanges phone + PIN for a JWT       (POST /api/v1/auth/login)
+//   - PostTripStart:     binds a vehicle to a trip and route   (POST /api/v1/trips/start)
 package handler
 
 import (
 	"encoding/json"
 	"net/http"
 
+	"github.com/jaggu/vehicle-tracker-prototype/match"
 	"github.com/jaggu/vehicle-tracker-prototype/model"
 	"github.com/jaggu/vehicle-tracker-prototype/store"
+	"github.com/jaggu/vehicle-tracker-prototype/trips"
 )
 
 // PostLocation handles POST /location.
 //
 // It expects a JSON body with vehicle_id, latitude, longitude, and timestamp.
+// Invalid reports get a 400 with an "error" message and a "fields" array
+// naming each rejected field.
 // On success it stores the location and responds with {"status": "ok"}.
-func PostLocation(s *store.MemoryStore) http.HandlerFunc {
+// A report older than, or with the same timestamp as, the vehicle's stored
+// one is not applied and gets {"status": "ignored_stale"} or
+// {"status": "ignored_duplicate"} instead.  A point flagged with
+// "backfill": true (or detected as one by its age) is recorded in history
+// only and gets {"status": "backfilled"}.
+//
+// Behind RequireDriver, a report for a vehicle the driver is not
+// assigned to gets a 403, and one for a vehicle another driver is
+// driving gets a 409.
+//
+// A report without trip_id and route_id inherits them from the vehicle's
+// active trip in t, if any (see PostTripStart).  t may be nil.  A trip_id
+// or route_id that isn't in v's schedule is dropped rather than failing
+// the report, and named in a "warnings" array of the response.
+//
+// Once a report becomes the vehicle's latest location, p places it on
+// its trip and the match is stored with it, for the feeds and the trail.
+// p may be nil.
+func PostLocation(s store.Store, v *Validator, t *trips.Manager, p *match.Placer) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		// Only accept POST 
@@ -32,21 +57,54 @@ func PostLocation(s *store.MemoryStore) http.HandlerFunc {
 			writeError(w, http.StatusBadRequest, "Invalid JSON body")
 			return
 		}
+		loc.Match = nil // set by p, never by the client
 
-		// Validate required fields 
-		if loc.VehicleID == "" {
-			writeError(w, http.StatusBadRequest, "vehicle_id is required")
+		// Fill in the trip the vehicle is running, then drop a trip or
+		// route the schedule doesn't know
+		if t != nil {
+			loc = t.Inherit(loc)
+		}
+		loc, warnings := v.dropUnknownTrip(loc)
+
+		// Validate fields against physical ranges and agency limits
+		if errs := v.validate(loc); errs != nil {
+			writeValidationError(w, errs)
 			return
 		}
-		if loc.Latitude == 0 && loc.Longitude == 0 {
-			writeError(w, http.StatusBadRequest, "latitude and longitude are required")
+
+		// Drivers may only report for their own vehicles, one driver
+		// per vehicle at a time
+		if status, msg := checkReporter(r, loc.VehicleID); status != 0 {
+			writeError(w, status, msg)
 			return
 		}
 
 		// Store the location 
-		s.UpdateLocation(loc)
+		res, err := s.UpdateLocation(loc)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to store location")
+			return
+		}
+
+		// Keep the vehicle's active trip from going idle
+		if t != nil {
+			t.Seen(loc.VehicleID)
+		}
 
-		// Respond 
-		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+		// Place a new latest fix on its trip
+		if res == store.Accepted {
+			if m := p.Place(loc); m != nil {
+				s.SetMatch(loc.VehicleID, loc.Timestamp, m)
+			}
+		}
+
+		// Respond: "ok", or "ignored_stale"/"ignored_duplicate" when an
+		// older or repeated report (e.g. a delayed retry) was not applied,
+		// with the dropped trip or route as warnings
+		resp := map[string]any{"status": res.String()}
+		if warnings != nil {
+			resp["warnings"] = warnings
+		}
+		writeJSON(w, http.StatusOK, resp)
 	}
 }
@@ -0,0 +1,130 @@
+package handler_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/jaggu/vehicle-tracker-prototype/clock"
+	"github.com/jaggu/vehicle-tracker-prototype/gtfs"
+	"github.com/jaggu/vehicle-tracker-prototype/handler"
+	"github.com/jaggu/vehicle-tracker-prototype/model"
+	"github.com/jaggu/vehicle-tracker-prototype/store"
+)
+
+func postLocation(t *testing.T, h http.HandlerFunc, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/locations", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	h(rec, req)
+	return rec
+}
+
+func TestPostLocation_FieldLevelValidationErrors(t *testing.T) {
+	s := store.New()
+	defer s.Close()
+	h := handler.PostLocation(s, testValidator(), nil, nil)
+
+	rec := postLocation(t, h, `{"vehicle_id": "bus-1", "latitude": 500, "longitude": 78.0,
+		"bearing": 720, "speed": -3, "accuracy": -1, "timestamp": 1}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", rec.Code)
+	}
+
+	var resp struct {
+		Error  string             `json:"error"`
+		Fields []model.FieldError `json:"fields"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	got := make(map[string]bool)
+	for _, f := range resp.Fields {
+		got[f.Field] = true
+	}
+	for _, field := range []string{"latitude", "bearing", "speed", "accuracy", "timestamp"} {
+		if !got[field] {
+			t.Errorf("missing field error for %q in %v", field, resp.Fields)
+		}
+	}
+	if s.TotalVehicleCount() != 0 {
+		t.Error("invalid report must not be stored")
+	}
+}
+
+func TestPostLocation_PerAgencyLimits(t *testing.T) {
+	s := store.New()
+	defer s.Close()
+
+	v := testValidator()
+	v.Config.Agencies = map[string]model.Limits{
+		"slow": {MaxSpeed: 10, MaxFutureSkew: model.DefaultLimits.MaxFutureSkew, MaxAge: model.DefaultLimits.MaxAge},
+	}
+	v.AgencyOf = func(vehicleID string) string {
+		if strings.HasPrefix(vehicleID, "slow-") {
+			return "slow"
+		}
+		return ""
+	}
+	h := handler.PostLocation(s, v, nil, nil)
+
+	body := `{"vehicle_id": "%s", "latitude": 17.3, "longitude": 78.4, "speed": 20, "timestamp": 1752566390}`
+	if rec := postLocation(t, h, strings.Replace(body, "%s", "bus-1", 1)); rec.Code != http.StatusOK {
+		t.Errorf("default agency: status = %d, want 200", rec.Code)
+	}
+	if rec := postLocation(t, h, strings.Replace(body, "%s", "slow-1", 1)); rec.Code != http.StatusBadRequest {
+		t.Errorf("agency with 10 m/s limit: status = %d, want 400", rec.Code)
+	}
+}
+
+func TestPostLocation_ScheduleDropsUnknownTripAndRoute(t *testing.T) {
+	s := store.New()
+	defer s.Close()
+
+	sched, err := gtfs.OpenSchedule("../gtfs/testdata/kbs", clock.NewFake(testNow), 0)
+	if err != nil {
+		t.Fatalf("OpenSchedule: %v", err)
+	}
+	v := testValidator()
+	v.Schedule = sched
+	h := handler.PostLocation(s, v, nil, nil)
+
+	for i, tc := range []struct {
+		trip, route         string
+		warning             string // "" when the report is kept whole
+		keptTrip, keptRoute string
+	}{
+		{"5_0800_out", "5", "", "5_0800_out", "5"},
+		{"", "5", "", "", "5"},
+		{"5_0800_out", "", "", "5_0800_out", ""},
+		{"5_0800_typo", "5", "trip_id", "", "5"},
+		{"", "99", "route_id", "", ""},
+		{"5_0800_out", "99", "route_id", "5_0800_out", ""},
+	} {
+		body := `{"vehicle_id": "bus-1", "trip_id": "` + tc.trip + `", "route_id": "` + tc.route +
+			`", "latitude": 17.3, "longitude": 78.4, "timestamp": ` + strconv.Itoa(1752566300+i) + `}`
+		rec := postLocation(t, h, body)
+		var resp struct {
+			Status   string             `json:"status"`
+			Warnings []model.FieldError `json:"warnings"`
+		}
+		json.NewDecoder(rec.Body).Decode(&resp)
+		if rec.Code != http.StatusOK || resp.Status != "ok" {
+			t.Errorf("trip %q route %q: status = %d %q, want 200 ok", tc.trip, tc.route, rec.Code, resp.Status)
+			continue
+		}
+		switch {
+		case tc.warning == "" && len(resp.Warnings) != 0:
+			t.Errorf("trip %q route %q: warnings = %+v, want none", tc.trip, tc.route, resp.Warnings)
+		case tc.warning != "" && (len(resp.Warnings) != 1 || resp.Warnings[0].Field != tc.warning):
+			t.Errorf("trip %q route %q: warnings = %+v, want one on %s", tc.trip, tc.route, resp.Warnings, tc.warning)
+		}
+		if got := s.GetAllLocations()[0]; got.TripID != tc.keptTrip || got.RouteID != tc.keptRoute {
+			t.Errorf("trip %q route %q: stored %q/%q, want %q/%q",
+				tc.trip, tc.route, got.TripID, got.RouteID, tc.keptTrip, tc.keptRoute)
+		}
+	}
+}
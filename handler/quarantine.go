@@ -0,0 +1,34 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/jaggu/vehicle-tracker-prototype/store"
+)
+
+// quarantineResponse is the JSON shape returned by
+// GET /api/v1/admin/quarantine.
+type quarantineResponse struct {
+	Count  int                      `json:"count"`
+	Points []store.QuarantinedPoint `json:"points"`
+}
+
+// GetQuarantine handles GET /api/v1/admin/quarantine.
+//
+// It lists the reports rejected by the store's outlier filter, oldest
+// first, so operators can tell a noisy phone from a filter that is too
+// strict.
+func GetQuarantine(s store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "Only GET is allowed")
+			return
+		}
+
+		points := s.Quarantine()
+		if points == nil {
+			points = []store.QuarantinedPoint{}
+		}
+		writeJSON(w, http.StatusOK, quarantineResponse{Count: len(points), Points: points})
+	}
+}
@@ -4,40 +4,87 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/jaggu/vehicle-tracker-prototype/clock"
 	"github.com/jaggu/vehicle-tracker-prototype/model"
 	"github.com/jaggu/vehicle-tracker-prototype/store"
 )
 
 // statusResponse is the JSON shape returned by GET /api/v1/status.
 type statusResponse struct {
-	Status              string `json:"status"`
-	ActiveVehicles      int    `json:"active_vehicles"`
-	TotalVehicles       int    `json:"total_vehicles"`
-	StalenessThreshold  string `json:"staleness_threshold"`
-	ServerTimeUTC       string `json:"server_time_utc"`
-	FeedEndpoint        string `json:"feed_endpoint"`
-	FeedEndpointJSON    string `json:"feed_endpoint_json"`
+	Status             string         `json:"status"`
+	ActiveVehicles     int            `json:"active_vehicles"`
+	TotalVehicles      int            `json:"total_vehicles"`
+	StalenessThreshold string         `json:"staleness_threshold"`
+	ServerTimeUTC      string         `json:"server_time_utc"`
+	FeedEndpoint       string         `json:"feed_endpoint"`
+	FeedEndpointJSON   string         `json:"feed_endpoint_json"`
+	History            historyStatus  `json:"history"`
+	EvictedVehicles    int            `json:"evicted_vehicles"`
+	StaleReports       int            `json:"stale_reports"`
+	DuplicateReports   int            `json:"duplicate_reports"`
+	Backfill           backfillStatus `json:"backfill"`
+	Outliers           outlierStatus  `json:"outliers"`
+}
+
+// outlierStatus counts location reports rejected as GPS jumps.
+type outlierStatus struct {
+	Rejected    int `json:"rejected"`
+	Quarantined int `json:"quarantined"`
+}
+
+// backfillStatus reports late-arriving points recorded in history only.
+type backfillStatus struct {
+	Points         int    `json:"points"`
+	AverageLatency string `json:"average_latency"`
+	MaxLatency     string `json:"max_latency"`
+}
+
+// historyStatus reports the memory held by per-vehicle location trails.
+type historyStatus struct {
+	Points    int   `json:"points"`
+	MaxPoints int   `json:"max_points"`
+	Bytes     int64 `json:"bytes"`
 }
 
 // GetStatus handles GET /api/v1/status.
 //
 // It returns basic system health information: how many vehicles are
 // actively reporting, the staleness threshold in use, and the feed URL.
-func GetStatus(s *store.MemoryStore) http.HandlerFunc {
+//
+// Server time is read from clk so that tests can pin it.
+func GetStatus(s store.Store, clk clock.Clock) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			writeError(w, http.StatusMethodNotAllowed, "Only GET is allowed")
 			return
 		}
 
+		stats := s.Stats()
 		resp := statusResponse{
 			Status:             "ok",
 			ActiveVehicles:     s.ActiveVehicleCount(model.DefaultStalenessThreshold),
 			TotalVehicles:      s.TotalVehicleCount(),
 			StalenessThreshold: model.DefaultStalenessThreshold.String(),
-			ServerTimeUTC:      time.Now().UTC().Format(time.RFC3339),
+			ServerTimeUTC:      clk.Now().UTC().Format(time.RFC3339),
 			FeedEndpoint:       "/gtfs-rt/vehicle-positions",
 			FeedEndpointJSON:   "/gtfs-rt/vehicle-positions?format=json",
+			History: historyStatus{
+				Points:    stats.HistoryPoints,
+				MaxPoints: stats.HistoryMaxPoints,
+				Bytes:     stats.HistoryBytes,
+			},
+			EvictedVehicles:  stats.Evictions,
+			StaleReports:     stats.StaleReports,
+			DuplicateReports: stats.DuplicateReports,
+			Backfill: backfillStatus{
+				Points:         stats.BackfillPoints,
+				AverageLatency: stats.BackfillLatency.String(),
+				MaxLatency:     stats.BackfillLatencyMax.String(),
+			},
+			Outliers: outlierStatus{
+				Rejected:    stats.OutlierReports,
+				Quarantined: stats.QuarantinedPoints,
+			},
 		}
 
 		writeJSON(w, http.StatusOK, resp)
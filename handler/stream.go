@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jaggu/vehicle-tracker-prototype/gtfsrt"
+	pb "github.com/jaggu/vehicle-tracker-prototype/proto/gtfsrt"
+	"github.com/jaggu/vehicle-tracker-prototype/store"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// streamKeepAlive is how often an idle stream gets a comment line, so
+// proxies don't close it.
+const streamKeepAlive = 15 * time.Second
+
+// GetFeedStream handles GET /gtfs-rt/stream.
+//
+// It streams the combined feed, narrowed by the query parameters of
+// GetCombinedFeed, as Server-Sent Events.  The first event is the full
+// dataset; after that each event is a DIFFERENTIAL FeedMessage holding
+// only the entities that changed, with vanished vehicles marked
+// is_deleted.  Each event's id is its sequence number.  A consumer that
+// reconnects with it, in the Last-Event-ID header (as EventSource does)
+// or ?since=, catches up from there, or gets a full resync if it is too
+// old to catch up from.
+//
+// Event data is the base64-encoded protobuf FeedMessage, or JSON with
+// ?format=json.  The event type is "full" or "differential".
+func GetFeedStream(s store.Store, b *gtfsrt.Builder, agencyOf func(vehicleID string) string, st *gtfsrt.Streamer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "Only GET is allowed")
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			writeError(w, http.StatusInternalServerError, "Streaming is not supported")
+			return
+		}
+		sel, err := feedSelection(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		asJSON := r.URL.Query().Get("format") == "json"
+
+		since := r.Header.Get("Last-Event-ID")
+		if q := r.URL.Query().Get("since"); q != "" {
+			since = q
+		}
+		kinds := make([]string, len(sel.Kinds))
+		for i, k := range sel.Kinds {
+			kinds[i] = string(k)
+		}
+		key := strings.Join([]string{strings.Join(kinds, ","), sel.RouteID, sel.Agency}, "|")
+		build := func() *pb.FeedMessage { return buildSelected(s, b, agencyOf, sel) }
+		sub := st.Subscribe(key, build, since)
+		defer sub.Close()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		keepAlive := time.NewTicker(streamKeepAlive)
+		defer keepAlive.Stop()
+		for {
+			select {
+			case <-sub.C():
+				ev, ok := sub.Next()
+				if !ok {
+					continue
+				}
+				if err := writeEvent(w, ev, asJSON); err != nil {
+					return
+				}
+			case <-keepAlive.C:
+				if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+					return
+				}
+			case <-r.Context().Done():
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// writeEvent writes ev as a Server-Sent Event.
+func writeEvent(w http.ResponseWriter, ev gtfsrt.Event, asJSON bool) error {
+	var data string
+	if asJSON {
+		b, err := protojson.Marshal(ev.Feed)
+		if err != nil {
+			return err
+		}
+		data = string(b)
+	} else {
+		b, err := proto.Marshal(ev.Feed)
+		if err != nil {
+			return err
+		}
+		data = base64.StdEncoding.EncodeToString(b)
+	}
+	kind := "differential"
+	if ev.Full {
+		kind = "full"
+	}
+	_, err := fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", ev.ID, kind, data)
+	return err
+}
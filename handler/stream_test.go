@@ -0,0 +1,126 @@
+package handler_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jaggu/vehicle-tracker-prototype/clock"
+	"github.com/jaggu/vehicle-tracker-prototype/gtfsrt"
+	"github.com/jaggu/vehicle-tracker-prototype/handler"
+	"github.com/jaggu/vehicle-tracker-prototype/model"
+	"github.com/jaggu/vehicle-tracker-prototype/store"
+)
+
+// sseEvent is one parsed Server-Sent Event.
+type sseEvent struct {
+	id, event string
+	entities  []string
+}
+
+// readEvent reads the next event from an event stream, skipping
+// comments.
+func readEvent(t *testing.T, r *bufio.Reader) sseEvent {
+	t.Helper()
+	var ev sseEvent
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading stream: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && ev.event != "":
+			return ev
+		case strings.HasPrefix(line, "id: "):
+			ev.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			ev.event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			var msg struct {
+				Entity []struct {
+					ID        string
+					IsDeleted bool
+				}
+			}
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &msg); err != nil {
+				t.Fatalf("event data: %v", err)
+			}
+			for _, e := range msg.Entity {
+				id := e.ID
+				if e.IsDeleted {
+					id += "-"
+				}
+				ev.entities = append(ev.entities, id)
+			}
+		}
+	}
+}
+
+func TestGetFeedStream(t *testing.T) {
+	clk := clock.NewFake(time.Date(2026, 7, 15, 5, 6, 0, 0, time.UTC))
+	s := store.New(store.WithClock(clk))
+	defer s.Close()
+	now := clk.Now().Unix()
+	s.UpdateLocation(model.Location{VehicleID: "kbs-1", Latitude: -1.29, Longitude: 36.81, Timestamp: now})
+	s.UpdateLocation(model.Location{VehicleID: "kbs-2", Latitude: -1.30, Longitude: 36.82, Timestamp: now})
+
+	streamer := gtfsrt.NewStreamer(clk, time.Hour)
+	defer streamer.Close()
+	srv := httptest.NewServer(handler.GetFeedStream(s, &gtfsrt.Builder{Clock: clk}, nil, streamer))
+	defer srv.Close()
+
+	open := func(query, lastEventID string) (*http.Response, *bufio.Reader) {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"?format=json&include=vehicle_positions"+query, nil)
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("GET: %v", err)
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+			t.Fatalf("Content-Type = %q", ct)
+		}
+		return resp, bufio.NewReader(resp.Body)
+	}
+
+	resp, r := open("", "")
+	ev := readEvent(t, r)
+	if ev.event != "full" || strings.Join(ev.entities, " ") != "vehicle-kbs-1 vehicle-kbs-2" {
+		t.Errorf("first event: %s %v, want full with both vehicles", ev.event, ev.entities)
+	}
+
+	s.UpdateLocation(model.Location{VehicleID: "kbs-1", Latitude: -1.28, Longitude: 36.81, Timestamp: now + 5})
+	streamer.Update()
+	ev = readEvent(t, r)
+	if ev.event != "differential" || strings.Join(ev.entities, " ") != "vehicle-kbs-1" {
+		t.Errorf("after a move: %s %v, want differential with vehicle-kbs-1", ev.event, ev.entities)
+	}
+	resp.Body.Close()
+
+	// Reconnecting from the last event needs nothing new; from an
+	// unknown one it resyncs.
+	s.UpdateLocation(model.Location{VehicleID: "kbs-2", Latitude: -1.31, Longitude: 36.82, Timestamp: now + 5})
+	resp, r = open("", ev.id)
+	streamer.Update() // in case the first stream hasn't been closed yet
+	if ev := readEvent(t, r); ev.event != "differential" || strings.Join(ev.entities, " ") != "vehicle-kbs-2" {
+		t.Errorf("reconnect: %s %v, want differential with vehicle-kbs-2", ev.event, ev.entities)
+	}
+	resp.Body.Close()
+	resp, r = open("&since=unknown:3", "")
+	if ev := readEvent(t, r); ev.event != "full" || len(ev.entities) != 2 {
+		t.Errorf("unknown since: %s %v, want a full resync", ev.event, ev.entities)
+	}
+	resp.Body.Close()
+
+	rec := httptest.NewRecorder()
+	handler.GetFeedStream(s, &gtfsrt.Builder{Clock: clk}, nil, streamer)(rec, httptest.NewRequest(http.MethodGet, "/gtfs-rt/stream?include=vehicles", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown kind: status %d, want 400", rec.Code)
+	}
+}
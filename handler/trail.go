@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/jaggu/vehicle-tracker-prototype/model"
+	"github.com/jaggu/vehicle-tracker-prototype/store"
+)
+
+// trailResponse is the JSON shape returned by GET /api/v1/vehicles/{id}/trail.
+type trailResponse struct {
+	VehicleID string           `json:"vehicle_id"`
+	Points    []model.Location `json:"points"`
+}
+
+// geoJSONFeature is a GeoJSON Feature wrapping the trail as a LineString
+// (RFC 7946).  Coordinates are [longitude, latitude] pairs.
+type geoJSONFeature struct {
+	Type       string            `json:"type"`
+	Geometry   geoJSONLineString `json:"geometry"`
+	Properties geoJSONProperties `json:"properties"`
+}
+
+type geoJSONLineString struct {
+	Type        string       `json:"type"`
+	Coordinates [][2]float64 `json:"coordinates"`
+}
+
+type geoJSONProperties struct {
+	VehicleID  string  `json:"vehicle_id"`
+	Timestamps []int64 `json:"timestamps"`
+}
+
+// GetTrail handles GET /api/v1/vehicles/{id}/trail.
+//
+// It returns the vehicle's recent positions, oldest first, as used by the
+// admin map to draw where a bus has been.  Append ?format=geojson to get
+// a GeoJSON Feature with a LineString geometry instead.
+func GetTrail(s store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		// Only accept GET
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "Only GET is allowed")
+			return
+		}
+
+		vehicleID := r.PathValue("id")
+		points := s.Trail(vehicleID)
+		if len(points) == 0 {
+			writeError(w, http.StatusNotFound, "No history for vehicle")
+			return
+		}
+
+		if r.URL.Query().Get("format") == "geojson" {
+			feature := geoJSONFeature{
+				Type: "Feature",
+				Geometry: geoJSONLineString{
+					Type:        "LineString",
+					Coordinates: make([][2]float64, len(points)),
+				},
+				Properties: geoJSONProperties{
+					VehicleID:  vehicleID,
+					Timestamps: make([]int64, len(points)),
+				},
+			}
+			for i, p := range points {
+				feature.Geometry.Coordinates[i] = [2]float64{p.Longitude, p.Latitude}
+				feature.Properties.Timestamps[i] = p.Timestamp
+			}
+			w.Header().Set("Content-Type", "application/geo+json")
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(feature) //nolint: errcheck
+			return
+		}
+
+		writeJSON(w, http.StatusOK, trailResponse{VehicleID: vehicleID, Points: points})
+	}
+}
@@ -0,0 +1,129 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/jaggu/vehicle-tracker-prototype/model"
+	"github.com/jaggu/vehicle-tracker-prototype/trips"
+)
+
+// tripRequest is the JSON body of POST /api/v1/trips/start and
+// POST /api/v1/trips/end.  Ending a trip only needs vehicle_id.
+type tripRequest struct {
+	VehicleID string `json:"vehicle_id"`
+	TripID    string `json:"trip_id"`
+	RouteID   string `json:"route_id"`
+}
+
+// PostTripStart handles POST /api/v1/trips/start.
+//
+// It expects {"vehicle_id", "trip_id", "route_id"}, at least one of
+// trip_id and route_id, and makes that the vehicle's active trip,
+// replacing any trip it was running.  Until the trip ends, location
+// reports from the vehicle inherit the trip and the GTFS-RT feed
+// describes the vehicle by it.  It responds with the started trip.
+//
+// The vehicle and trip are checked against v's registry and schedule,
+// when set, and a failure gets a 400 with a "fields" array.  Behind
+// RequireDriver the same assignment rules as PostLocation apply.
+func PostTripStart(t *trips.Manager, v *Validator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "Only POST is allowed")
+			return
+		}
+
+		var req tripRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid JSON body")
+			return
+		}
+		if req.VehicleID == "" {
+			writeError(w, http.StatusBadRequest, "vehicle_id is required")
+			return
+		}
+		if req.TripID == "" && req.RouteID == "" {
+			writeError(w, http.StatusBadRequest, "trip_id or route_id is required")
+			return
+		}
+		var errs model.ValidationError
+		errs = append(errs, v.checkVehicle(req.VehicleID)...)
+		errs = append(errs, v.checkTrip(req.TripID, req.RouteID)...)
+		if len(errs) > 0 {
+			writeValidationError(w, errs)
+			return
+		}
+		if status, msg := checkReporter(r, req.VehicleID); status != 0 {
+			writeError(w, status, msg)
+			return
+		}
+
+		trip, err := t.Start(trips.Trip{
+			VehicleID: req.VehicleID,
+			TripID:    req.TripID,
+			RouteID:   req.RouteID,
+			DriverID:  sessionDriverID(r),
+		})
+		if err != nil {
+			writeTripError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, trip)
+	}
+}
+
+// PostTripEnd handles POST /api/v1/trips/end.
+//
+// It expects {"vehicle_id"} and ends the vehicle's active trip, after
+// which reports carry only the trip they send themselves.  It responds
+// with the ended trip, or a 404 if the vehicle had none.
+func PostTripEnd(t *trips.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "Only POST is allowed")
+			return
+		}
+
+		var req tripRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid JSON body")
+			return
+		}
+		if req.VehicleID == "" {
+			writeError(w, http.StatusBadRequest, "vehicle_id is required")
+			return
+		}
+		if status, msg := checkReporter(r, req.VehicleID); status != 0 {
+			writeError(w, status, msg)
+			return
+		}
+
+		trip, err := t.End(req.VehicleID)
+		if err != nil {
+			writeTripError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, trip)
+	}
+}
+
+// sessionDriverID returns the ID of the request's authenticated driver,
+// or "" when driver authentication is disabled.
+func sessionDriverID(r *http.Request) string {
+	sess, _ := r.Context().Value(driverKey{}).(driverSession)
+	return sess.driver.ID
+}
+
+// writeTripError maps trips errors to HTTP statuses.
+func writeTripError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, trips.ErrNoActiveTrip):
+		writeError(w, http.StatusNotFound, "Vehicle has no active trip")
+	case errors.Is(err, trips.ErrInvalid):
+		writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, "Failed to save trip")
+	}
+}
@@ -0,0 +1,53 @@
+package handler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jaggu/vehicle-tracker-prototype/clock"
+	"github.com/jaggu/vehicle-tracker-prototype/handler"
+	"github.com/jaggu/vehicle-tracker-prototype/store"
+	"github.com/jaggu/vehicle-tracker-prototype/trips"
+)
+
+func TestTrips_ReportsInheritActiveTrip(t *testing.T) {
+	m, _ := trips.Open("", clock.NewFake(testNow), 0)
+	start, end := handler.PostTripStart(m, testValidator()), handler.PostTripEnd(m)
+
+	s := store.New()
+	defer s.Close()
+	post := handler.PostLocation(s, testValidator(), m, nil)
+
+	call := func(h http.HandlerFunc, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+		return rec
+	}
+
+	if rec := call(start, `{"vehicle_id": "bus-1"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("start without trip or route: status = %d, want 400", rec.Code)
+	}
+	if rec := call(start, `{"vehicle_id": "bus-1", "trip_id": "5_0830", "route_id": "5"}`); rec.Code != http.StatusOK {
+		t.Fatalf("start: status = %d, body %s", rec.Code, rec.Body)
+	}
+
+	if rec := call(post, `{"vehicle_id": "bus-1", "latitude": 17.3, "longitude": 78.4, "timestamp": 1752566410}`); rec.Code != http.StatusOK {
+		t.Fatalf("report: status = %d, body %s", rec.Code, rec.Body)
+	}
+	if got := s.GetAllLocations()[0]; got.TripID != "5_0830" || got.RouteID != "5" {
+		t.Errorf("stored report has trip %q/%q, want 5_0830/5", got.TripID, got.RouteID)
+	}
+
+	if rec := call(end, `{"vehicle_id": "bus-1"}`); rec.Code != http.StatusOK {
+		t.Fatalf("end: status = %d, body %s", rec.Code, rec.Body)
+	}
+	if rec := call(end, `{"vehicle_id": "bus-1"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("end without an active trip: status = %d, want 404", rec.Code)
+	}
+	call(post, `{"vehicle_id": "bus-1", "latitude": 17.3, "longitude": 78.4, "timestamp": 1752566420}`)
+	if got := s.GetAllLocations()[0]; got.TripID != "" {
+		t.Errorf("report after the trip ended has trip %q", got.TripID)
+	}
+}
@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"github.com/jaggu/vehicle-tracker-prototype/clock"
+	"github.com/jaggu/vehicle-tracker-prototype/gtfs"
+	"github.com/jaggu/vehicle-tracker-prototype/model"
+	"github.com/jaggu/vehicle-tracker-prototype/registry"
+)
+
+// Validator checks incoming location reports against physical ranges and
+// the limits configured for the reporting vehicle's agency.
+type Validator struct {
+	Config model.ValidationConfig
+
+	// Clock is the server time used for clock-skew checks.
+	Clock clock.Clock
+
+	// AgencyOf returns the agency a vehicle belongs to.  When nil, every
+	// report is checked against the default limits.
+	AgencyOf func(vehicleID string) string
+
+	// Vehicles, when set, restricts reports to registered, active
+	// vehicles.
+	Vehicles *registry.Registry
+
+	// Schedule, when set, restricts trip_id and route_id to trips and
+	// routes in the agency's GTFS schedule.
+	Schedule *gtfs.Schedule
+}
+
+// NewValidator returns a Validator applying cfg with the given clock.
+func NewValidator(cfg model.ValidationConfig, clk clock.Clock) *Validator {
+	return &Validator{Config: cfg, Clock: clk}
+}
+
+// validate returns the field errors of loc, or nil if it is valid.
+func (v *Validator) validate(loc model.Location) model.ValidationError {
+	var agency string
+	if v.AgencyOf != nil && loc.VehicleID != "" {
+		agency = v.AgencyOf(loc.VehicleID)
+	}
+	errs := v.Config.LimitsFor(agency).Validate(loc, v.Clock.Now())
+	errs = append(errs, v.checkVehicle(loc.VehicleID)...)
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// dropUnknownTrip clears the trip_id and route_id of loc that checkTrip
+// rejects and returns the errors as warnings.  A typo in the trip the
+// phone was given shouldn't lose the vehicle's position; without the
+// trip it is described by its route, or inferred.  A trip that doesn't
+// run on a known route is dropped and the route kept.
+func (v *Validator) dropUnknownTrip(loc model.Location) (model.Location, model.ValidationError) {
+	warnings := v.checkTrip(loc.TripID, loc.RouteID)
+	for _, w := range warnings {
+		switch w.Field {
+		case "trip_id":
+			loc.TripID = ""
+		case "route_id":
+			loc.RouteID = ""
+		}
+	}
+	return loc, warnings
+}
+
+// checkVehicle returns a vehicle_id error if the registry is set and
+// vehicleID is not a registered, active vehicle.
+func (v *Validator) checkVehicle(vehicleID string) model.ValidationError {
+	if v.Vehicles == nil || vehicleID == "" {
+		return nil
+	}
+	switch veh, ok := v.Vehicles.Get(vehicleID); {
+	case !ok:
+		return model.ValidationError{{Field: "vehicle_id", Message: "is not a registered vehicle"}}
+	case !veh.Active:
+		return model.ValidationError{{Field: "vehicle_id", Message: "is deactivated"}}
+	}
+	return nil
+}
+
+// checkTrip returns trip_id and route_id errors if the schedule is set
+// and they are not in it, or the trip doesn't run on the route.
+func (v *Validator) checkTrip(tripID, routeID string) model.ValidationError {
+	if v.Schedule == nil {
+		return nil
+	}
+	feed := v.Schedule.Feed()
+
+	var errs model.ValidationError
+	_, routeOK := feed.Routes[routeID]
+	if routeID != "" && !routeOK {
+		errs = append(errs, model.FieldError{Field: "route_id", Message: "is not a route in the schedule"})
+	}
+	if tripID != "" {
+		trip, ok := feed.Trips[tripID]
+		switch {
+		case !ok:
+			errs = append(errs, model.FieldError{Field: "trip_id", Message: "is not a trip in the schedule"})
+		case routeOK && trip.RouteID != routeID:
+			errs = append(errs, model.FieldError{Field: "trip_id", Message: "does not run on route " + routeID})
+		}
+	}
+	return errs
+}
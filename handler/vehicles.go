@@ -16,7 +16,7 @@ type vehiclesResponse struct {
 //
 // It returns the latest known GPS location for every vehicle
 // that has reported at least one update.
-func GetVehicles(s *store.MemoryStore) http.HandlerFunc {
+func GetVehicles(s store.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		//  Only accept GET
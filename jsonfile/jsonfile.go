@@ -0,0 +1,64 @@
+// Package jsonfile persists the small JSON state files (API keys,
+// drivers, vehicles, trips, alerts) so that a crash or power cut leaves
+// either the old file or the new one, never a torn write.
+package jsonfile
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Mode is the permission of files written by Write.  Some of them hold
+// credential hashes, so all are readable by their owner only.
+const Mode = 0o600
+
+// Write replaces the file at path with v as indented JSON.  It writes
+// path+".tmp", flushes it to disk and renames it over path, then flushes
+// the directory so the rename itself survives a crash.
+func Write(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := writeSynced(tmp, data); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("write %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("install %s: %w", path, err)
+	}
+
+	// Not every platform can sync a directory; the file is in place
+	// either way.
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
+
+// writeSynced writes data to a file at name with Mode, even if one was
+// left behind with another, and flushes it to disk.
+func writeSynced(name string, data []byte) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, Mode)
+	if err != nil {
+		return err
+	}
+	if err := f.Chmod(Mode); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,52 @@
+package jsonfile_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jaggu/vehicle-tracker-prototype/jsonfile"
+)
+
+func TestWrite_ReplacesFileWithOwnerOnlyMode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := os.WriteFile(path, []byte("old"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// A temporary file left behind by an interrupted write is replaced.
+	if err := os.WriteFile(path+".tmp", []byte("torn"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := jsonfile.Write(path, []string{"a", "b"}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	var got []string
+	if err := json.Unmarshal(data, &got); err != nil || len(got) != 2 || got[1] != "b" {
+		t.Errorf("file holds %q (%v), want [a b]", data, err)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != jsonfile.Mode {
+		t.Errorf("mode = %v, want %v", info.Mode().Perm(), os.FileMode(jsonfile.Mode))
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left behind: %v", err)
+	}
+}
+
+func TestWrite_KeepsOldFileOnFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := jsonfile.Write(path, map[string]int{"n": 1}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := jsonfile.Write(path, func() {}); err == nil {
+		t.Fatal("Write of a value JSON can't encode succeeded")
+	}
+	if data, _ := os.ReadFile(path); string(data) != "{\n  \"n\": 1\n}" {
+		t.Errorf("file after a failed write = %q, want the old contents", data)
+	}
+}
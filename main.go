@@ -5,19 +5,276 @@
 //
 // Usage:
 //
-//	go run main.go
+//	go run main.go -drivers drivers.json [-port 8081] [-db tracker.db | -wal-dir data/]
+//	go run main.go -insecure-no-auth
+//	go run main.go -hash-pin 1234
+//
+// Without -db or -wal-dir, locations are kept in memory only and are
+// lost on exit.  Drivers log in with the phone numbers and PIN hashes
+// listed in -drivers; -hash-pin prints the hash to put there.
 package main
 
 import (
+	"crypto/rand"
+	"flag"
+	"fmt"
 	"log"
+	"os"
+	"strings"
+	_ "time/tzdata" // agency time zones on hosts without zoneinfo
 
+	"github.com/jaggu/vehicle-tracker-prototype/alerts"
+	"github.com/jaggu/vehicle-tracker-prototype/apikey"
+	"github.com/jaggu/vehicle-tracker-prototype/auth"
+	"github.com/jaggu/vehicle-tracker-prototype/clock"
+	"github.com/jaggu/vehicle-tracker-prototype/gtfs"
+	"github.com/jaggu/vehicle-tracker-prototype/gtfsrt"
+	"github.com/jaggu/vehicle-tracker-prototype/handler"
+	"github.com/jaggu/vehicle-tracker-prototype/match"
+	"github.com/jaggu/vehicle-tracker-prototype/model"
+	"github.com/jaggu/vehicle-tracker-prototype/registry"
 	"github.com/jaggu/vehicle-tracker-prototype/server"
+	"github.com/jaggu/vehicle-tracker-prototype/store"
+	"github.com/jaggu/vehicle-tracker-prototype/trips"
 )
 
 const defaultPort = 8081
 
 func main() {
-	if err := server.Run(defaultPort); err != nil {
+	port := flag.Int("port", defaultPort, "HTTP port to listen on")
+	dbPath := flag.String("db", "", "path to a SQLite database for persistent storage")
+	walDir := flag.String("wal-dir", "", "directory for the in-memory store's write-ahead log and snapshots")
+	walSync := flag.String("wal-sync", "always", "write-ahead log fsync policy: always, interval or never")
+	historyPoints := flag.Int("history-points", store.DefaultHistoryConfig.MaxPoints, "trail points kept per vehicle (0 disables history)")
+	historyAge := flag.Duration("history-age", store.DefaultHistoryConfig.MaxAge, "maximum age of trail points")
+	historyTotal := flag.Int("history-max-total", store.DefaultHistoryConfig.MaxTotalPoints, "cap on trail points across all vehicles")
+	batchItems := flag.Int("batch-max-items", handler.DefaultBatchConfig.MaxItems, "maximum locations per batch request")
+	batchBytes := flag.Int64("batch-max-bytes", handler.DefaultBatchConfig.MaxBodyBytes, "maximum body size of a batch request")
+	retention := flag.Duration("retention", store.DefaultRetentionConfig.Window, "evict vehicles that haven't reported for this long (0 keeps them forever)")
+	backfillAge := flag.Duration("backfill-age", 0, "reports with a device timestamp older than this are stored as history-only backfill (0 disables; devices with slow clocks would vanish from the feed)")
+	outlierSpeed := flag.Float64("outlier-max-speed", 60, "reject fixes implying a speed above this many m/s from the previous one (0 disables)")
+	outlierAccuracy := flag.Float64("outlier-max-accuracy", 200, "reject fixes with an accuracy radius above this many meters (0 disables)")
+	quarantineSize := flag.Int("quarantine-size", store.DefaultQuarantineSize, "rejected fixes kept for inspection")
+	validationPath := flag.String("validation-config", "", "JSON file with location validation limits, optionally per agency")
+	driversPath := flag.String("drivers", "", "JSON file storing drivers, their phone numbers, PIN hashes and vehicles (created if missing)")
+	jwtSecret := flag.String("jwt-secret", os.Getenv("JWT_SECRET"), "secret for signing driver tokens (default $JWT_SECRET, else random per run)")
+	accessTTL := flag.Duration("access-ttl", auth.DefaultAccessTTL, "lifetime of driver access tokens")
+	refreshTTL := flag.Duration("refresh-ttl", auth.DefaultRefreshTTL, "lifetime of driver refresh tokens")
+	drivingLease := flag.Duration("driving-lease", auth.DefaultDrivingLease, "how long a vehicle stays claimed by a driver after their last report")
+	noAuth := flag.Bool("insecure-no-auth", false, "accept location reports without a driver token (development only)")
+	vehiclesPath := flag.String("vehicles", "", "JSON file holding the vehicle registry; when set, only registered active vehicles may report")
+	gtfsPath := flag.String("gtfs", "", "GTFS static zip (or unzipped directory); when set, trip_id and route_id must be in the schedule")
+	gtfsReload := flag.Duration("gtfs-reload", gtfs.DefaultReloadInterval, "how often to check the -gtfs file for changes (0 disables reloading)")
+	stoppedRadius := flag.Float64("stopped-radius", match.DefaultStopConfig.StoppedRadius, "meters from a stop within which a vehicle is STOPPED_AT it")
+	incomingRadius := flag.Float64("incoming-radius", match.DefaultStopConfig.IncomingRadius, "meters from its next stop within which a vehicle is INCOMING_AT it")
+	snapToShape := flag.Bool("snap-to-shape", false, "publish positions snapped onto the trip's shapes.txt polyline (needs -gtfs)")
+	snapMaxOffset := flag.Float64("snap-max-offset", match.DefaultShapeConfig.MaxOffset, "meters from the shape beyond which a fix is not snapped (e.g. detours)")
+	inferThreshold := flag.Float64("infer-threshold", match.DefaultInferConfig.Threshold, "confidence (0-1) an inferred trip needs to be published for vehicles reporting without one (needs -gtfs)")
+	inferWindow := flag.Duration("infer-window", match.DefaultInferConfig.Window, "how far back a vehicle's positions are used to infer its trip")
+	tripsPath := flag.String("trips", "", "JSON file storing active trips so they survive a restart (default: memory only)")
+	tripIdle := flag.Duration("trip-idle", trips.DefaultIdleTimeout, "end a vehicle's active trip after it hasn't reported for this long (0 keeps it until ended)")
+	alertsPath := flag.String("alerts", "", "JSON file storing service alerts (default: memory only)")
+	streamInterval := flag.Duration("stream-interval", gtfsrt.DefaultStreamInterval, "how often the /gtfs-rt/stream feed is rebuilt and changes sent")
+	apiKeysPath := flag.String("api-keys", "", "JSON file storing feed API keys; when set, the feed requires a key")
+	publicFeed := flag.String("public-feed", "", "comma-separated agencies whose feed needs no API key (\"*\" for all)")
+	adminToken := flag.String("admin-token", os.Getenv("ADMIN_TOKEN"), "bearer token for the admin API (default $ADMIN_TOKEN; empty disables it)")
+	hashPIN := flag.String("hash-pin", "", "print the hash of this PIN for a -drivers file and exit")
+	flag.Parse()
+
+	if *hashPIN != "" {
+		hash, err := auth.HashPIN(*hashPIN)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(hash)
+		return
+	}
+
+	var authenticator *auth.Authenticator
+	if !*noAuth {
+		authenticator = newAuthenticator(*driversPath, *jwtSecret, auth.Config{
+			AccessTTL:    *accessTTL,
+			RefreshTTL:   *refreshTTL,
+			DrivingLease: *drivingLease,
+		})
+	}
+
+	validation := model.DefaultValidationConfig
+	if *validationPath != "" {
+		var err error
+		if validation, err = model.LoadValidationConfig(*validationPath); err != nil {
+			log.Fatalf("Failed to load validation config: %v", err)
+		}
+	}
+
+	var vehicles *registry.Registry
+	if *vehiclesPath != "" {
+		var err error
+		if vehicles, err = registry.Open(*vehiclesPath); err != nil {
+			log.Fatalf("Failed to load vehicle registry: %v", err)
+		}
+	}
+
+	var schedule *gtfs.Schedule
+	if *gtfsPath != "" {
+		var err error
+		if schedule, err = gtfs.OpenSchedule(*gtfsPath, clock.Real, *gtfsReload); err != nil {
+			log.Fatalf("Failed to load GTFS schedule: %v", err)
+		}
+		defer schedule.Close()
+	}
+
+	activeTrips, err := trips.Open(*tripsPath, clock.Real, *tripIdle)
+	if err != nil {
+		log.Fatalf("Failed to load active trips: %v", err)
+	}
+	defer activeTrips.Close()
+	if schedule != nil {
+		endUnscheduledTrips(activeTrips, schedule.Feed())
+		schedule.OnReload(func(f *gtfs.Feed) { endUnscheduledTrips(activeTrips, f) })
+	}
+
+	serviceAlerts, err := alerts.Open(*alertsPath, clock.Real)
+	if err != nil {
+		log.Fatalf("Failed to load service alerts: %v", err)
+	}
+
+	feed := handler.FeedAccess{}
+	if *apiKeysPath != "" {
+		keys, err := apikey.Open(*apiKeysPath, clock.Real)
+		if err != nil {
+			log.Fatalf("Failed to load API keys: %v", err)
+		}
+		feed.Keys = keys
+	}
+	if *publicFeed != "" {
+		feed.PublicAgencies = strings.Split(*publicFeed, ",")
+	}
+
+	opts := []store.Option{
+		store.WithHistory(store.HistoryConfig{
+			MaxPoints:      *historyPoints,
+			MaxAge:         *historyAge,
+			MaxTotalPoints: *historyTotal,
+		}),
+		store.WithRetention(store.RetentionConfig{
+			Window:   *retention,
+			Interval: store.DefaultRetentionConfig.Interval,
+		}),
+		store.WithBackfill(store.BackfillConfig{MaxLiveAge: *backfillAge}),
+		store.WithOutlierFilter(store.OutlierConfig{
+			MaxSpeed:       *outlierSpeed,
+			MaxAccuracy:    float32(*outlierAccuracy),
+			QuarantineSize: *quarantineSize,
+		}),
+	}
+
+	var s store.Store
+	switch {
+	case *dbPath != "" && *walDir != "":
+		log.Fatal("-db and -wal-dir are mutually exclusive")
+	case *dbPath != "":
+		sqliteStore, err := store.OpenSQLite(*dbPath, opts...)
+		if err != nil {
+			log.Fatalf("Failed to open database: %v", err)
+		}
+		defer sqliteStore.Close()
+		s = sqliteStore
+	case *walDir != "":
+		cfg := store.WALConfig{Dir: *walDir}
+		switch *walSync {
+		case "always":
+			cfg.Sync = store.SyncAlways
+		case "interval":
+			cfg.Sync = store.SyncInterval
+		case "never":
+			cfg.Sync = store.SyncNever
+		default:
+			log.Fatalf("Unknown -wal-sync policy %q", *walSync)
+		}
+		memStore, err := store.OpenWAL(cfg, opts...)
+		if err != nil {
+			log.Fatalf("Failed to open write-ahead log: %v", err)
+		}
+		defer memStore.Close()
+		s = memStore
+	default:
+		memStore := store.New(opts...)
+		defer memStore.Close()
+		s = memStore
+	}
+
+	cfg := server.Config{
+		Port: *port,
+		Batch: handler.BatchConfig{
+			MaxItems:     *batchItems,
+			MaxBodyBytes: *batchBytes,
+		},
+		Validation: validation,
+		Auth:       authenticator,
+		Feed:       feed,
+		AdminToken: *adminToken,
+		Vehicles:   vehicles,
+		Trips:      activeTrips,
+		Alerts:     serviceAlerts,
+		Schedule:   schedule,
+		Match: match.Config{
+			Stops: match.StopConfig{
+				StoppedRadius:  *stoppedRadius,
+				IncomingRadius: *incomingRadius,
+			},
+			Shapes: match.ShapeConfig{MaxOffset: *snapMaxOffset},
+		},
+		SnapToShape: *snapToShape,
+		Infer: match.InferConfig{
+			Threshold: *inferThreshold,
+			Window:    *inferWindow,
+		},
+		StreamInterval: *streamInterval,
+	}
+	if err := server.Run(cfg, s); err != nil {
 		log.Fatalf("Server failed: %v", err)
 	}
 }
+
+// newAuthenticator loads the driver list and sets up token signing with
+// the lifetimes in cfg, exiting on error.
+func newAuthenticator(driversPath, secret string, cfg auth.Config) *auth.Authenticator {
+	if driversPath == "" {
+		log.Fatal("Driver authentication needs -drivers; use -insecure-no-auth to run without it")
+	}
+	drivers, err := auth.OpenDrivers(driversPath)
+	if err != nil {
+		log.Fatalf("Failed to load drivers: %v", err)
+	}
+
+	key := []byte(secret)
+	if len(key) == 0 {
+		// Tokens signed with a random key stop working on restart.
+		log.Print("No -jwt-secret or $JWT_SECRET set; using a random key, drivers must log in again after a restart")
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			log.Fatalf("Failed to generate signing key: %v", err)
+		}
+	}
+
+	cfg.Secret = key
+	a, err := auth.New(drivers, cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return a
+}
+
+// endUnscheduledTrips ends the active trips whose trip or route is not in
+// f, so a schedule change doesn't leave vehicles on trips that no longer
+// exist.
+func endUnscheduledTrips(m *trips.Manager, f *gtfs.Feed) {
+	ended, err := m.EndWhere(func(t trips.Trip) bool { return !f.Knows(t.TripID, t.RouteID) })
+	if err != nil {
+		log.Printf("Failed to end trips missing from the schedule: %v", err)
+	}
+	for _, t := range ended {
+		log.Printf("Ended trip %q on route %q of vehicle %s: not in the schedule", t.TripID, t.RouteID, t.VehicleID)
+	}
+}
@@ -0,0 +1,451 @@
+package match
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jaggu/vehicle-tracker-prototype/clock"
+	"github.com/jaggu/vehicle-tracker-prototype/geo"
+	"github.com/jaggu/vehicle-tracker-prototype/gtfs"
+	"github.com/jaggu/vehicle-tracker-prototype/model"
+)
+
+// InferConfig controls trip inference.
+type InferConfig struct {
+	// Threshold is the confidence, from 0 to 1, an inferred trip needs
+	// to be published.
+	Threshold float64
+
+	// Window is how far back the vehicle's positions are considered.
+	Window time.Duration
+
+	// MinFixes is how many positions within Window are needed.
+	MinFixes int
+
+	// MaxOffset is how far, in meters, a fix may be from a trip's stop
+	// pattern and still count as on it.
+	MaxOffset float64
+
+	// MaxDelay is the scale of schedule deviation tolerated: a run the
+	// vehicle would be MaxDelay early or late on scores poorly.
+	MaxDelay time.Duration
+}
+
+// DefaultInferConfig publishes a trip only when one run clearly fits the
+// last ten minutes of positions.
+var DefaultInferConfig = InferConfig{
+	Threshold: 0.6,
+	Window:    10 * time.Minute,
+	MinFixes:  2,
+	MaxOffset: 100,
+	MaxDelay:  20 * time.Minute,
+}
+
+// Candidate is a scheduled run scored against a vehicle's positions.
+// Score is the product of the Spatial, Timing and Direction scores,
+// each from 0 to 1.
+type Candidate struct {
+	TripID    string  `json:"trip_id"`
+	RouteID   string  `json:"route_id"`
+	StartDate string  `json:"start_date"`
+	StartTime string  `json:"start_time"`
+	Score     float64 `json:"score"`
+	Spatial   float64 `json:"spatial"`
+	Timing    float64 `json:"timing"`
+	Direction float64 `json:"direction"`
+
+	// Delay is how late the vehicle is against the run, in seconds;
+	// negative when early.
+	Delay int `json:"delay_seconds"`
+
+	// Offset is the mean distance from the fixes to the stop pattern,
+	// in meters.
+	Offset float64 `json:"offset_meters"`
+}
+
+// Inference is the trip inference decision for a vehicle's latest fix.
+type Inference struct {
+	VehicleID string    `json:"vehicle_id"`
+	At        time.Time `json:"at"`
+	Fixes     int       `json:"fixes"`
+
+	// TripID and RouteID are the best candidate's, if any.
+	TripID     string  `json:"trip_id,omitempty"`
+	RouteID    string  `json:"route_id,omitempty"`
+	Confidence float64 `json:"confidence"`
+
+	// Published is whether Confidence reached the threshold, so the
+	// trip is used in the feed.
+	Published bool   `json:"published"`
+	Reason    string `json:"reason"`
+
+	// Candidates are the best-scoring runs, best first.
+	Candidates []Candidate `json:"candidates"`
+}
+
+// maxCandidates is how many candidates an Inference keeps for debugging.
+const maxCandidates = 5
+
+// Inferrer guesses the trip of vehicles whose drivers didn't select one.
+// It keeps the latest decision per vehicle, both to skip recomputing it
+// for a fix already seen and for the admin debug endpoint.  It is safe
+// for concurrent use.
+type Inferrer struct {
+	cfg     InferConfig
+	clock   clock.Clock
+	history func(vehicleID string) []model.Location
+
+	mu        sync.Mutex
+	decisions map[string]Inference
+	index     *tripIndex // of the last feed seen; read-only once built
+}
+
+// NewInferrer returns an Inferrer that reads vehicles' recent positions
+// from history, such as store.Store.Trail.  Zero-valued config fields
+// take the DefaultInferConfig values.
+func NewInferrer(cfg InferConfig, clk clock.Clock, history func(vehicleID string) []model.Location) *Inferrer {
+	if cfg.Threshold <= 0 {
+		cfg.Threshold = DefaultInferConfig.Threshold
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = DefaultInferConfig.Window
+	}
+	if cfg.MinFixes <= 0 {
+		cfg.MinFixes = DefaultInferConfig.MinFixes
+	}
+	if cfg.MaxOffset <= 0 {
+		cfg.MaxOffset = DefaultInferConfig.MaxOffset
+	}
+	if cfg.MaxDelay <= 0 {
+		cfg.MaxDelay = DefaultInferConfig.MaxDelay
+	}
+	return &Inferrer{
+		cfg:       cfg,
+		clock:     clk,
+		history:   history,
+		decisions: make(map[string]Inference),
+	}
+}
+
+// Decisions returns the latest decision for every vehicle, ordered by
+// vehicle ID.
+func (in *Inferrer) Decisions() []Inference {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	list := make([]Inference, 0, len(in.decisions))
+	for _, d := range in.decisions {
+		list = append(list, d)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].VehicleID < list[j].VehicleID })
+	return list
+}
+
+// Infer picks the most likely trip for the vehicle reporting loc, from
+// loc and the vehicle's other positions within the window.  A route_id
+// on loc limits the search to that route.
+//
+// Every run in service around loc's time whose stop pattern passes near
+// the fixes is scored on how close the fixes are to the pattern, how far
+// off its schedule the vehicle would be, and whether the fixes move along
+// the pattern in its direction.  The confidence is the best score, scaled
+// down by how much of the total score the other runs share, so two
+// equally good runs give low confidence.  The patterns, and a grid of the
+// trips near each place, are built once per feed.
+func (in *Inferrer) Infer(f *gtfs.Feed, loc model.Location) Inference {
+	at := in.clock.Now()
+	if loc.Timestamp > 0 {
+		at = time.Unix(loc.Timestamp, 0)
+	}
+
+	// Only the cache and the index are shared; scoring runs unlocked.
+	in.mu.Lock()
+	if d, ok := in.decisions[loc.VehicleID]; ok && d.At.Equal(at) && in.index != nil && in.index.feed == f {
+		in.mu.Unlock()
+		return d
+	}
+	idx := in.indexLocked(f)
+	in.mu.Unlock()
+
+	dec := in.decide(idx, loc, at)
+	in.mu.Lock()
+	in.decisions[loc.VehicleID] = dec
+	in.mu.Unlock()
+	return dec
+}
+
+// decide makes the inference decision for loc at time at.
+func (in *Inferrer) decide(idx *tripIndex, loc model.Location, at time.Time) Inference {
+	dec := Inference{VehicleID: loc.VehicleID, At: at, Candidates: []Candidate{}}
+	fixes := in.recentFixes(loc, at)
+	dec.Fixes = len(fixes)
+	if len(fixes) < in.cfg.MinFixes {
+		dec.Reason = "not enough recent positions"
+		return dec
+	}
+
+	var total float64
+	for _, tripID := range idx.near(fixes) {
+		trip := idx.feed.Trips[tripID]
+		if loc.RouteID != "" && trip.RouteID != loc.RouteID {
+			continue
+		}
+		run, ok := idx.feed.Instance(tripID, at)
+		if !ok || !in.inService(idx.patterns[tripID], run, at) {
+			continue
+		}
+		c, ok := in.score(idx.patterns[tripID], run, fixes)
+		if !ok {
+			continue
+		}
+		total += c.Score
+		dec.Candidates = append(dec.Candidates, c)
+	}
+	sort.Slice(dec.Candidates, func(i, j int) bool {
+		a, b := dec.Candidates[i], dec.Candidates[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		return a.TripID < b.TripID
+	})
+	if len(dec.Candidates) > maxCandidates {
+		dec.Candidates = dec.Candidates[:maxCandidates]
+	}
+
+	switch {
+	case len(dec.Candidates) == 0 || total == 0:
+		dec.Reason = "no scheduled trip near the vehicle"
+	default:
+		best := dec.Candidates[0]
+		dec.TripID, dec.RouteID = best.TripID, best.RouteID
+		dec.Confidence = best.Score * best.Score / total
+		dec.Published = dec.Confidence >= in.cfg.Threshold
+		dec.Reason = "below threshold"
+		if dec.Published {
+			dec.Reason = "published"
+		}
+	}
+	return dec
+}
+
+// inService reports whether at falls within twice MaxDelay of the run's
+// scheduled span.  Runs further off would score a timing of under 0.001,
+// so they are not scored at all.
+func (in *Inferrer) inService(p *pattern, run gtfs.TripInstance, at time.Time) bool {
+	slack := 2 * in.cfg.MaxDelay
+	start := run.At(run.Start)
+	end := start.Add(time.Duration(p.secs[len(p.secs)-1]) * time.Second)
+	return !at.Before(start.Add(-slack)) && !at.After(end.Add(slack))
+}
+
+// fix is a timestamped position.
+type fix struct {
+	lat, lon float64
+	at       time.Time
+}
+
+// recentFixes returns the vehicle's live positions within the window
+// ending at, oldest first, with loc last.
+func (in *Inferrer) recentFixes(loc model.Location, at time.Time) []fix {
+	var fixes []fix
+	if in.history != nil {
+		for _, p := range in.history(loc.VehicleID) {
+			t := time.Unix(p.Timestamp, 0)
+			if p.Backfill || p.Timestamp <= 0 || !t.Before(at) || at.Sub(t) > in.cfg.Window {
+				continue
+			}
+			fixes = append(fixes, fix{p.Latitude, p.Longitude, t})
+		}
+	}
+	sort.Slice(fixes, func(i, j int) bool { return fixes[i].at.Before(fixes[j].at) })
+	return append(fixes, fix{loc.Latitude, loc.Longitude, at})
+}
+
+// pattern is a trip's stop pattern as a polyline, with the distance
+// along it and the scheduled time, relative to the first departure, at
+// each stop.
+type pattern struct {
+	lat, lon []float64
+	along    []float64
+	secs     []int
+}
+
+// newPattern builds the stop pattern of tripID.
+func newPattern(f *gtfs.Feed, tripID string) *pattern {
+	sts := f.StopTimes[tripID]
+	p := &pattern{}
+	for i, st := range sts {
+		s := f.Stops[st.StopID]
+		along := 0.0
+		if i > 0 {
+			along = p.along[i-1] + geo.Distance(p.lat[i-1], p.lon[i-1], s.Lat, s.Lon)
+		}
+		secs := 0
+		switch {
+		case st.Arrival >= 0:
+			secs = st.Arrival - sts[0].Departure
+		case i > 0:
+			secs = p.secs[i-1] // untimed stop: keep the previous time
+		}
+		p.lat, p.lon = append(p.lat, s.Lat), append(p.lon, s.Lon)
+		p.along = append(p.along, along)
+		p.secs = append(p.secs, secs)
+	}
+	return p
+}
+
+// cellDegrees is the size of a tripIndex grid cell, about 1.1 km of
+// latitude.
+const cellDegrees = 0.01
+
+// cell is a square of the tripIndex grid.
+type cell struct{ lat, lon int }
+
+// cellOf returns the grid cell holding (lat, lon).
+func cellOf(lat, lon float64) cell {
+	return cell{int(math.Floor(lat / cellDegrees)), int(math.Floor(lon / cellDegrees))}
+}
+
+// tripIndex holds the stop pattern of every trip in a feed, and a grid
+// of the trips whose pattern passes within MaxOffset of each cell, so a
+// vehicle is only scored against the trips near its fixes.  It is not
+// changed once built.
+type tripIndex struct {
+	feed     *gtfs.Feed
+	patterns map[string]*pattern // by trip ID
+	cells    map[cell][]string   // trip IDs, each at most once per cell
+}
+
+// indexLocked returns the index of f, building it when the feed has
+// changed.  in.mu must be held.
+func (in *Inferrer) indexLocked(f *gtfs.Feed) *tripIndex {
+	if in.index != nil && in.index.feed == f {
+		return in.index
+	}
+	idx := &tripIndex{feed: f, patterns: make(map[string]*pattern, len(f.Trips)), cells: make(map[cell][]string)}
+	for id := range f.Trips {
+		if len(f.StopTimes[id]) == 0 {
+			continue
+		}
+		p := newPattern(f, id)
+		idx.patterns[id] = p
+		seen := make(map[cell]bool)
+		for i := range p.lat {
+			j := min(i+1, len(p.lat)-1)
+			idx.addSegment(id, p.lat[i], p.lon[i], p.lat[j], p.lon[j], in.cfg.MaxOffset, seen)
+		}
+	}
+	in.index = idx
+	return idx
+}
+
+// addSegment adds tripID to every cell within margin meters of the
+// bounding box of the segment from a to b.
+func (idx *tripIndex) addSegment(tripID string, aLat, aLon, bLat, bLon, margin float64, seen map[cell]bool) {
+	dLat := margin / geo.EarthRadius * 180 / math.Pi
+	maxLat := math.Max(math.Abs(aLat), math.Abs(bLat)) + dLat
+	dLon := dLat / math.Max(math.Cos(math.Min(maxLat, 89)*math.Pi/180), 0.01)
+	lo := cellOf(math.Min(aLat, bLat)-dLat, math.Min(aLon, bLon)-dLon)
+	hi := cellOf(math.Max(aLat, bLat)+dLat, math.Max(aLon, bLon)+dLon)
+	for la := lo.lat; la <= hi.lat; la++ {
+		for ln := lo.lon; ln <= hi.lon; ln++ {
+			c := cell{la, ln}
+			if !seen[c] {
+				seen[c] = true
+				idx.cells[c] = append(idx.cells[c], tripID)
+			}
+		}
+	}
+}
+
+// near returns the trips whose pattern passes within MaxOffset of any of
+// the fixes, in ID order.  Other trips would get no spatial score.
+func (idx *tripIndex) near(fixes []fix) []string {
+	seen := make(map[string]bool)
+	var ids []string
+	for _, fx := range fixes {
+		for _, id := range idx.cells[cellOf(fx.lat, fx.lon)] {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// locate projects (lat, lon) onto the pattern, returning the distance
+// along it, the offset from it, and the scheduled seconds after the first
+// departure at that point.
+func (p *pattern) locate(lat, lon float64) (along, offset float64, secs float64) {
+	if len(p.lat) == 1 {
+		return 0, geo.Distance(lat, lon, p.lat[0], p.lon[0]), float64(p.secs[0])
+	}
+	offset = -1
+	for i := 0; i+1 < len(p.lat); i++ {
+		frac, d := geo.Project(lat, lon, p.lat[i], p.lon[i], p.lat[i+1], p.lon[i+1])
+		if offset >= 0 && d >= offset {
+			continue
+		}
+		offset = d
+		along = p.along[i] + frac*(p.along[i+1]-p.along[i])
+		secs = float64(p.secs[i]) + frac*float64(p.secs[i+1]-p.secs[i])
+	}
+	return along, offset, secs
+}
+
+// score scores run, whose stop pattern is p, against the fixes.  It
+// returns false if no fix is within MaxOffset of p.
+func (in *Inferrer) score(p *pattern, run gtfs.TripInstance, fixes []fix) (Candidate, bool) {
+	sigma := in.cfg.MaxOffset / 2
+
+	var spatial, offsets float64
+	var delays []float64
+	var prevAlong float64
+	var forward, moves int
+	for i, fx := range fixes {
+		along, offset, secs := p.locate(fx.lat, fx.lon)
+		offsets += offset
+		if offset <= in.cfg.MaxOffset {
+			spatial += math.Exp(-0.5 * (offset / sigma) * (offset / sigma))
+		}
+		scheduled := run.At(run.Start).Add(time.Duration(secs * float64(time.Second)))
+		delays = append(delays, fx.at.Sub(scheduled).Seconds())
+
+		// Moves of more than a few meters show the direction of travel.
+		if i > 0 && math.Abs(along-prevAlong) > 10 {
+			moves++
+			if along > prevAlong {
+				forward++
+			}
+		}
+		prevAlong = along
+	}
+	if spatial == 0 {
+		return Candidate{}, false
+	}
+	spatial /= float64(len(fixes))
+
+	sort.Float64s(delays)
+	delay := delays[len(delays)/2]
+	tsigma := in.cfg.MaxDelay.Seconds() / 2
+	timing := math.Exp(-0.5 * (delay / tsigma) * (delay / tsigma))
+
+	// With no movement the direction is unknown and scores 0.5.
+	direction := float64(forward+1) / float64(moves+2)
+
+	return Candidate{
+		TripID:    run.Trip.ID,
+		RouteID:   run.Trip.RouteID,
+		StartDate: run.StartDate(),
+		StartTime: run.StartTime(),
+		Score:     spatial * timing * direction,
+		Spatial:   spatial,
+		Timing:    timing,
+		Direction: direction,
+		Delay:     int(math.Round(delay)),
+		Offset:    offsets / float64(len(fixes)),
+	}, true
+}
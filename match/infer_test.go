@@ -0,0 +1,93 @@
+package match_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jaggu/vehicle-tracker-prototype/clock"
+	"github.com/jaggu/vehicle-tracker-prototype/gtfs"
+	"github.com/jaggu/vehicle-tracker-prototype/match"
+	"github.com/jaggu/vehicle-tracker-prototype/model"
+)
+
+func TestInferrer(t *testing.T) {
+	f, err := gtfs.Load("../gtfs/testdata/kbs")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	nairobi := f.Location("5")
+	at := func(hhmm string) int64 {
+		tod, _ := time.Parse("15:04", hhmm)
+		return time.Date(2026, 7, 15, tod.Hour(), tod.Minute(), 0, 0, nairobi).Unix()
+	}
+
+	trail := map[string][]model.Location{
+		// Heading out along route 5 on time for the 08:00 run.
+		"bus-out": {
+			{VehicleID: "bus-out", Latitude: -1.29, Longitude: 36.806, Timestamp: at("08:03")},
+			{VehicleID: "bus-out", Latitude: -1.29, Longitude: 36.808, Timestamp: at("08:04")},
+		},
+		// Waiting at Westlands between the 08:00 out and 08:30 back runs.
+		"bus-layover": {
+			{VehicleID: "bus-layover", Latitude: -1.29, Longitude: 36.83, Timestamp: at("08:21")},
+		},
+	}
+	in := match.NewInferrer(match.InferConfig{}, clock.NewFake(time.Unix(at("08:30"), 0)),
+		func(id string) []model.Location { return trail[id] })
+
+	dec := in.Infer(f, model.Location{VehicleID: "bus-out", Latitude: -1.29, Longitude: 36.81, Timestamp: at("08:05")})
+	if dec.TripID != "5_0800_out" || !dec.Published || dec.Fixes != 3 {
+		t.Errorf("bus-out: %+v, want 5_0800_out published from 3 fixes", dec)
+	}
+	for _, c := range dec.Candidates {
+		if c.TripID == "5_0900_out" || c.TripID == "5_1000_loop" {
+			t.Errorf("bus-out: scored %s, which isn't in service until after 08:20", c.TripID)
+		}
+	}
+
+	dec = in.Infer(f, model.Location{VehicleID: "bus-layover", Latitude: -1.29, Longitude: 36.83, Timestamp: at("08:22")})
+	if dec.Published || len(dec.Candidates) < 2 {
+		t.Errorf("bus-layover: %+v, want an unpublished decision between several runs", dec)
+	}
+
+	dec = in.Infer(f, model.Location{VehicleID: "bus-new", Latitude: -1.29, Longitude: 36.81, Timestamp: at("08:05")})
+	if dec.Published || dec.Reason != "not enough recent positions" {
+		t.Errorf("bus-new: %+v, want not enough positions", dec)
+	}
+
+	if got := in.Decisions(); len(got) != 3 || got[0].VehicleID != "bus-layover" {
+		t.Errorf("Decisions = %+v, want the three vehicles in ID order", got)
+	}
+}
+
+func TestInferrer_OnlyScoresTripsNearTheFixes(t *testing.T) {
+	f, err := gtfs.Load("../gtfs/testdata/kbs")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	start := time.Date(2026, 7, 15, 5, 10, 0, 0, time.UTC) // 08:10 in Nairobi
+	trail := map[string][]model.Location{
+		"bus-42": {{VehicleID: "bus-42", Latitude: -1.29, Longitude: 36.812, Timestamp: start.Unix() - 60}},
+		"bus-7":  {{VehicleID: "bus-7", Latitude: -1.31, Longitude: 36.812, Timestamp: start.Unix() - 60}},
+	}
+	in := match.NewInferrer(match.InferConfig{}, clock.NewFake(start), func(id string) []model.Location { return trail[id] })
+
+	// Midway between S2 and S3, 550 m from either, the outbound and
+	// inbound runs are both near.
+	dec := in.Infer(f, model.Location{VehicleID: "bus-42", Latitude: -1.29, Longitude: 36.815, Timestamp: start.Unix()})
+	var ids []string
+	for _, c := range dec.Candidates {
+		ids = append(ids, c.TripID)
+	}
+	if dec.TripID != "5_0800_out" || len(ids) < 2 {
+		t.Errorf("between stops: best %q of %v, want 5_0800_out among several runs", dec.TripID, ids)
+	}
+
+	// 2 km off the route nothing is near, with or without a route_id.
+	for _, route := range []string{"", "5"} {
+		dec = in.Infer(f, model.Location{VehicleID: "bus-7", RouteID: route, Latitude: -1.31, Longitude: 36.815, Timestamp: start.Unix() + int64(len(route))})
+		if len(dec.Candidates) != 0 || dec.Reason != "no scheduled trip near the vehicle" {
+			t.Errorf("off the route %q: %+v, want no candidates", route, dec)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package match
+
+import (
+	"time"
+
+	"github.com/jaggu/vehicle-tracker-prototype/clock"
+	"github.com/jaggu/vehicle-tracker-prototype/gtfs"
+	"github.com/jaggu/vehicle-tracker-prototype/model"
+	"github.com/jaggu/vehicle-tracker-prototype/trips"
+)
+
+// Placer places fixes on their trips as they are accepted, so that the
+// work, and the Matcher's progress along each run, follows the reports
+// rather than feed requests.  The feeds only read the result from
+// model.Location.Match.  The zero value, or a nil Placer, places
+// nothing.
+type Placer struct {
+	// Clock dates fixes without a timestamp; nil means clock.Real.
+	Clock clock.Clock
+
+	// Schedule is the static schedule trips are placed on.  Without it
+	// Place returns nil.
+	Schedule *gtfs.Schedule
+
+	// Trips supplies the active trip of each vehicle, which takes
+	// precedence over the trip on the report.
+	Trips *trips.Manager
+
+	// Matcher finds the stop the vehicle is at or heading to and snaps
+	// the fix onto the trip's shape.
+	Matcher *Matcher
+
+	// Inferrer guesses the trip of vehicles with neither an active trip
+	// nor a trip_id on their report.  The guess is used only when it is
+	// confident enough.
+	Inferrer *Inferrer
+}
+
+// Place works out where the fix loc, just accepted by the store, puts
+// the vehicle: its trip, the run of that trip in the schedule, the stop
+// it is at or heading to, and its position snapped onto the shape.  It
+// returns nil when the vehicle has no trip.  Fixes must be placed in the
+// order they were accepted.
+func (p *Placer) Place(loc model.Location) *model.Match {
+	if p == nil || p.Schedule == nil {
+		return nil
+	}
+	f := p.Schedule.Feed()
+
+	// The trip started at the active trip's start, or, for a trip sent
+	// with the report or inferred, at the vehicle's first fix on it.
+	at := time.Unix(loc.Timestamp, 0)
+	if loc.Timestamp <= 0 {
+		at = p.now()
+	}
+	m := &model.Match{TripID: loc.TripID, RouteID: loc.RouteID}
+	startedAt, active := at, false
+	if p.Trips != nil {
+		if t, ok := p.Trips.Active(loc.VehicleID); ok {
+			m.TripID, m.RouteID = t.TripID, t.RouteID
+			startedAt, active = t.StartedAt, true
+		}
+	}
+	if m.TripID == "" && p.Inferrer != nil {
+		if dec := p.Inferrer.Infer(f, loc); dec.Published {
+			m.TripID, m.RouteID, m.Inferred = dec.TripID, dec.RouteID, true
+		}
+	}
+	if m.TripID == "" {
+		return nil
+	}
+	if !active && p.Matcher != nil {
+		startedAt = p.Matcher.Started(loc.VehicleID, m.TripID, startedAt)
+	}
+	m.StartedAt = startedAt.Unix()
+
+	run, ok := f.Instance(m.TripID, time.Unix(m.StartedAt, 0))
+	if !ok || p.Matcher == nil {
+		return m
+	}
+	if sm, ok := p.Matcher.Snap(f, loc.VehicleID, run, loc.Latitude, loc.Longitude); ok {
+		m.Snapped, m.SnappedLatitude, m.SnappedLongitude, m.Along = true, sm.Lat, sm.Lon, sm.Along
+	}
+	if stop, ok := p.Matcher.Stop(f, loc.VehicleID, run, loc.Latitude, loc.Longitude); ok {
+		m.StopID, m.StopSequence, m.StopIndex = stop.StopID, stop.StopSequence, stop.Index
+		m.StopStatus = stop.Status.String()
+	}
+	return m
+}
+
+// now returns the current time according to the placer's clock.
+func (p *Placer) now() time.Time {
+	if p.Clock == nil {
+		return clock.Real.Now()
+	}
+	return p.Clock.Now()
+}
+
+// PlacedStop returns the stop m places the vehicle at or heading to on
+// its run in f, or false if it has none, or f no longer has the stop
+// there.
+func PlacedStop(f *gtfs.Feed, m *model.Match) (StopMatch, bool) {
+	if m == nil || m.StopID == "" {
+		return StopMatch{}, false
+	}
+	sts := f.StopTimes[m.TripID]
+	if m.StopIndex < 0 || m.StopIndex >= len(sts) || sts[m.StopIndex].StopID != m.StopID {
+		return StopMatch{}, false
+	}
+	sm := StopMatch{StopID: m.StopID, StopSequence: m.StopSequence, Index: m.StopIndex}
+	switch m.StopStatus {
+	case IncomingAt.String():
+		sm.Status = IncomingAt
+	case StoppedAt.String():
+		sm.Status = StoppedAt
+	}
+	return sm, true
+}
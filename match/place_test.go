@@ -0,0 +1,60 @@
+package match_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/jaggu/vehicle-tracker-prototype/clock"
+	"github.com/jaggu/vehicle-tracker-prototype/gtfs"
+	"github.com/jaggu/vehicle-tracker-prototype/match"
+	"github.com/jaggu/vehicle-tracker-prototype/model"
+	"github.com/jaggu/vehicle-tracker-prototype/trips"
+)
+
+func TestPlacer_PlacesFixesOnTheirTrip(t *testing.T) {
+	start := time.Date(2026, 7, 15, 5, 2, 0, 0, time.UTC) // 08:02 in Nairobi
+	clk := clock.NewFake(start)
+	sched, err := gtfs.OpenSchedule("../gtfs/testdata/kbs", clk, 0)
+	if err != nil {
+		t.Fatalf("OpenSchedule: %v", err)
+	}
+	active, _ := trips.Open("", clk, 0)
+	active.Start(trips.Trip{VehicleID: "bus-7", TripID: "5_0800_out", RouteID: "5"})
+	p := &match.Placer{Clock: clk, Schedule: sched, Trips: active, Matcher: match.NewMatcher(match.Config{})}
+
+	// A trip sent with the report runs from the first fix on it; the fix
+	// 22 m off the road is snapped onto the shape, 556 m from S1.
+	first := p.Place(model.Location{VehicleID: "bus-42", TripID: "5_0800_out", RouteID: "5", Latitude: -1.2902, Longitude: 36.8050, Timestamp: start.Unix()})
+	if first == nil {
+		t.Fatal("report with a trip was not placed")
+	}
+	if first.TripID != "5_0800_out" || first.RouteID != "5" || first.Inferred || first.StartedAt != start.Unix() {
+		t.Errorf("first fix placed on %+v, want 5_0800_out/5 started at the fix", first)
+	}
+	if !first.Snapped || first.SnappedLatitude != -1.29 || math.Abs(first.Along-556) > 5 {
+		t.Errorf("first fix snapped to %v, %v, %.0f m along; want -1.29, 556 m", first.SnappedLatitude, first.SnappedLongitude, first.Along)
+	}
+	if first.StopID != "S2" || first.StopSequence != 2 || first.StopIndex != 1 || first.StopStatus != "IN_TRANSIT_TO" {
+		t.Errorf("first fix stop = %+v, want in transit to S2", first)
+	}
+
+	// A later fix keeps the run's start and moves along the shape.
+	clk.Advance(4 * time.Minute)
+	second := p.Place(model.Location{VehicleID: "bus-42", TripID: "5_0800_out", Latitude: -1.29, Longitude: 36.8150, Timestamp: clk.Now().Unix()})
+	if second == nil || second.StartedAt != start.Unix() || second.Along <= first.Along || second.StopID != "S3" {
+		t.Errorf("second fix placed on %+v, want the same run further along, heading to S3", second)
+	}
+
+	// The active trip takes precedence and starts when it was started.
+	if m := p.Place(model.Location{VehicleID: "bus-7", Latitude: -1.29, Longitude: 36.8150, Timestamp: clk.Now().Unix()}); m == nil || m.TripID != "5_0800_out" || m.StartedAt != start.Unix() {
+		t.Errorf("vehicle on an active trip placed on %+v, want 5_0800_out from its start", m)
+	}
+	if m := p.Place(model.Location{VehicleID: "bus-9", Latitude: -1.29, Longitude: 36.8150, Timestamp: clk.Now().Unix()}); m != nil {
+		t.Errorf("vehicle without a trip placed on %+v", m)
+	}
+	var none *match.Placer
+	if m := none.Place(model.Location{VehicleID: "bus-42", TripID: "5_0800_out"}); m != nil {
+		t.Errorf("nil placer placed a fix on %+v", m)
+	}
+}
@@ -0,0 +1,139 @@
+package match
+
+import (
+	"math"
+	"time"
+
+	"github.com/jaggu/vehicle-tracker-prototype/geo"
+	"github.com/jaggu/vehicle-tracker-prototype/gtfs"
+)
+
+// Prediction is a run's predicted times at the stops it has still to
+// serve.
+type Prediction struct {
+	// Delay is how late the vehicle is against the run, in seconds;
+	// negative when early.
+	Delay int
+
+	// Stops are the stop the vehicle is at or heading to and every stop
+	// after it, in order.
+	Stops []StopPrediction
+}
+
+// StopPrediction is the predicted arrival and departure at a stop.
+type StopPrediction struct {
+	StopID       string
+	StopSequence int
+
+	// ScheduledArrival and ScheduledDeparture are from stop_times.txt,
+	// with untimed stops interpolated by distance.
+	ScheduledArrival   time.Time
+	ScheduledDeparture time.Time
+
+	// Arrival is zero at a stop the vehicle is already standing at.
+	Arrival   time.Time
+	Departure time.Time
+}
+
+// Predict predicts run's remaining stop times for a vehicle at (lat, lon)
+// at time at, matched to its stops as m.
+//
+// The vehicle's delay is how far it is behind where the schedule puts it:
+// against the arrival at a stop it is standing at, or, between stops, the
+// scheduled time interpolated along the segment it is on.  That delay is
+// carried unchanged to every later stop.  A vehicle waiting to start its
+// run is not early; it is expected to leave on time.
+//
+// It returns false if the run's trip has no stop times.
+func Predict(f *gtfs.Feed, run gtfs.TripInstance, m StopMatch, lat, lon float64, at time.Time) (Prediction, bool) {
+	sts := f.StopTimes[run.Trip.ID]
+	if len(sts) == 0 || m.Index < 0 || m.Index >= len(sts) {
+		return Prediction{}, false
+	}
+	arr, dep := scheduleTimes(f, sts)
+	base := run.Start - dep[0]
+	scheduled := func(secs float64) time.Time {
+		return run.At(base).Add(time.Duration(secs * float64(time.Second)))
+	}
+
+	i := m.Index
+	var expected time.Time
+	switch {
+	case m.Status == StoppedAt:
+		expected = scheduled(float64(arr[i]))
+	case i == 0:
+		expected = scheduled(float64(dep[0]))
+	default:
+		a, b := f.Stops[sts[i-1].StopID], f.Stops[sts[i].StopID]
+		frac, _ := geo.Project(lat, lon, a.Lat, a.Lon, b.Lat, b.Lon)
+		expected = scheduled(float64(dep[i-1]) + frac*float64(arr[i]-dep[i-1]))
+	}
+	delay := int(math.Round(at.Sub(expected).Seconds()))
+	if i == 0 && m.Status != StoppedAt {
+		delay = max(delay, 0)
+	}
+
+	p := Prediction{Delay: delay, Stops: make([]StopPrediction, 0, len(sts)-i)}
+	shift := time.Duration(delay) * time.Second
+	for k := i; k < len(sts); k++ {
+		sp := StopPrediction{
+			StopID:             sts[k].StopID,
+			StopSequence:       sts[k].StopSequence,
+			ScheduledArrival:   scheduled(float64(arr[k])),
+			ScheduledDeparture: scheduled(float64(dep[k])),
+		}
+		sp.Arrival = sp.ScheduledArrival.Add(shift)
+		sp.Departure = sp.ScheduledDeparture.Add(shift)
+		if k == i && m.Status == StoppedAt {
+			sp.Arrival = time.Time{}
+			if sp.Departure.Before(at) {
+				sp.Departure = at
+			}
+		}
+		p.Stops = append(p.Stops, sp)
+	}
+	return p, true
+}
+
+// scheduleTimes returns the arrival and departure of each stop time, in
+// seconds since the service day.  Untimed stops get times interpolated
+// by distance between the timed stops either side; GTFS requires the
+// first and last stops to be timed.
+func scheduleTimes(f *gtfs.Feed, sts []gtfs.StopTime) (arr, dep []int) {
+	n := len(sts)
+	arr, dep = make([]int, n), make([]int, n)
+	along := make([]float64, n)
+	for i, st := range sts {
+		if i > 0 {
+			a, b := f.Stops[sts[i-1].StopID], f.Stops[st.StopID]
+			along[i] = along[i-1] + geo.Distance(a.Lat, a.Lon, b.Lat, b.Lon)
+		}
+		arr[i], dep[i] = st.Arrival, st.Departure
+		if arr[i] < 0 {
+			arr[i] = dep[i]
+		}
+		if dep[i] < 0 {
+			dep[i] = arr[i]
+		}
+	}
+
+	prev := 0 // last timed stop
+	for i := 1; i < n; i++ {
+		if arr[i] < 0 {
+			continue
+		}
+		for k := prev + 1; k < i; k++ {
+			frac := 0.0
+			if span := along[i] - along[prev]; span > 0 {
+				frac = (along[k] - along[prev]) / span
+			}
+			arr[k] = dep[prev] + int(math.Round(frac*float64(arr[i]-dep[prev])))
+			dep[k] = arr[k]
+		}
+		prev = i
+	}
+	for k := prev + 1; k < n; k++ {
+		arr[k], dep[k] = dep[prev], dep[prev]
+	}
+	return arr, dep
+}
@@ -0,0 +1,63 @@
+package match_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jaggu/vehicle-tracker-prototype/match"
+)
+
+func TestPredict(t *testing.T) {
+	f, run := loadRun(t, "5_0800_out")
+	nairobi := f.Location("5")
+	at := func(hh, mm, ss int) time.Time { return time.Date(2026, 7, 15, hh, mm, ss, 0, nairobi) }
+
+	for _, tc := range []struct {
+		name    string
+		m       match.StopMatch
+		lon     float64
+		at      time.Time
+		delay   int
+		stops   int
+		arrival time.Time // predicted at the first stop returned
+		last    time.Time // predicted arrival at S4
+	}{
+		{"halfway to S2, late", match.StopMatch{StopID: "S2", Index: 1, Status: match.InTransitTo}, 36.805, at(8, 4, 30), 120, 3, at(8, 7, 0), at(8, 17, 0)},
+		{"stopped at S3, early", match.StopMatch{StopID: "S3", Index: 2, Status: match.StoppedAt}, 36.82, at(8, 9, 0), -60, 2, time.Time{}, at(8, 14, 0)},
+		{"waiting to start", match.StopMatch{StopID: "S1", Index: 0, Status: match.IncomingAt}, 36.7995, at(7, 55, 0), 0, 4, at(8, 0, 0), at(8, 15, 0)},
+	} {
+		p, ok := match.Predict(f, run, tc.m, -1.29, tc.lon, tc.at)
+		if !ok {
+			t.Fatalf("%s: no prediction", tc.name)
+		}
+		if p.Delay != tc.delay || len(p.Stops) != tc.stops {
+			t.Fatalf("%s: delay %d with %d stops, want %d with %d", tc.name, p.Delay, len(p.Stops), tc.delay, tc.stops)
+		}
+		first, last := p.Stops[0], p.Stops[len(p.Stops)-1]
+		if first.StopID != tc.m.StopID || !first.Arrival.Equal(tc.arrival) || !last.Arrival.Equal(tc.last) {
+			t.Errorf("%s: first %s arriving %v, last arriving %v; want %s %v, %v",
+				tc.name, first.StopID, first.Arrival, last.Arrival, tc.m.StopID, tc.arrival, tc.last)
+		}
+	}
+}
+
+func TestPredict_InterpolatesUntimedStops(t *testing.T) {
+	f, run := loadRun(t, "5_0800_out")
+	sts := append(f.StopTimes["5_0800_out"][:0:0], f.StopTimes["5_0800_out"]...)
+	sts[1].Arrival, sts[1].Departure = -1, -1
+	sts[2].Arrival, sts[2].Departure = -1, -1
+	f.StopTimes["5_0800_out"] = sts
+
+	at := time.Date(2026, 7, 15, 8, 0, 0, 0, f.Location("5"))
+	p, ok := match.Predict(f, run, match.StopMatch{StopID: "S1", Index: 0, Status: match.StoppedAt}, -1.29, 36.80, at)
+	if !ok {
+		t.Fatal("no prediction")
+	}
+	// S2 and S3 are evenly spaced between S1 at 08:00 and S4 at 08:15.
+	if got := p.Stops[1].ScheduledArrival.Format("15:04"); got != "08:05" {
+		t.Errorf("untimed S2 scheduled at %s, want 08:05", got)
+	}
+	if got := p.Stops[2].ScheduledArrival.Format("15:04"); got != "08:10" {
+		t.Errorf("untimed S3 scheduled at %s, want 08:10", got)
+	}
+}
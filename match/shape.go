@@ -0,0 +1,120 @@
+package match
+
+import (
+	"github.com/jaggu/vehicle-tracker-prototype/geo"
+	"github.com/jaggu/vehicle-tracker-prototype/gtfs"
+)
+
+// ShapeConfig controls snapping positions onto trip shapes.  Distances
+// are in meters.
+type ShapeConfig struct {
+	// MaxOffset is the furthest a fix may be from the shape and still be
+	// snapped to it.  Fixes further away, such as on a detour, are left
+	// where they are.
+	MaxOffset float64
+
+	// Backtrack is how far behind its furthest point so far a vehicle
+	// may match, to absorb GPS noise.
+	Backtrack float64
+
+	// Lookahead is how far ahead of its furthest point a vehicle is
+	// first looked for.  Only when nothing there is within MaxOffset is
+	// the rest of the shape searched, as after a long gap in reports.
+	Lookahead float64
+}
+
+// DefaultShapeConfig suits city buses reporting every few seconds.
+var DefaultShapeConfig = ShapeConfig{
+	MaxOffset: 75,
+	Backtrack: 50,
+	Lookahead: 3000,
+}
+
+// ShapeMatch is a position snapped onto a trip's shape.
+type ShapeMatch struct {
+	Lat float64
+	Lon float64
+
+	// Along is the distance along the shape from its first point, in
+	// meters.
+	Along float64
+
+	// Offset is the distance from the reported position to the snapped
+	// one, in meters.
+	Offset float64
+}
+
+// Snap snaps the vehicle at (lat, lon) onto the shape of run's trip.
+//
+// Direction of travel is honoured by only matching the part of the shape
+// from a little behind the vehicle's furthest point so far.  Where the
+// shape passes the same place twice, as loops and out-and-back routes
+// do, the earlier pass wins, so a vehicle works its way along the shape
+// in order rather than jumping to the return leg.
+//
+// It returns false if the trip has no shape or the fix is more than
+// MaxOffset from the part of the shape ahead of the vehicle.
+func (m *Matcher) Snap(f *gtfs.Feed, vehicleID string, run gtfs.TripInstance, lat, lon float64) (ShapeMatch, bool) {
+	pts := f.Shapes[run.Trip.ShapeID]
+	if len(pts) < 2 {
+		return ShapeMatch{}, false
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	prog := m.runProgress(vehicleID, run)
+	from, to := pts[0].Along, pts[len(pts)-1].Along
+	if prog.onShape {
+		from = prog.along - m.shapes.Backtrack
+	}
+
+	sm, ok := m.nearest(pts, lat, lon, from, min(to, from+m.shapes.Backtrack+m.shapes.Lookahead))
+	if !ok && prog.onShape {
+		sm, ok = m.nearest(pts, lat, lon, from, to)
+	}
+	if !ok {
+		return ShapeMatch{}, false
+	}
+
+	if !prog.onShape || sm.Along > prog.along {
+		prog.along, prog.onShape = sm.Along, true
+	}
+	m.progress[vehicleID] = prog
+	return sm, true
+}
+
+// nearest returns the point of the shape between from and to meters
+// along it that is closest to (lat, lon), if it is within MaxOffset.
+// Ties go to the point furthest back.
+func (m *Matcher) nearest(pts []gtfs.ShapePoint, lat, lon, from, to float64) (ShapeMatch, bool) {
+	best := ShapeMatch{Offset: -1}
+	for i := 0; i+1 < len(pts); i++ {
+		a, b := pts[i], pts[i+1]
+		if b.Along < from || a.Along > to {
+			continue
+		}
+		frac, d := geo.Project(lat, lon, a.Lat, a.Lon, b.Lat, b.Lon)
+		along := a.Along + frac*(b.Along-a.Along)
+
+		// Keep the projection inside the window.
+		if along < from || along > to {
+			clamped := max(from, min(to, along))
+			if b.Along > a.Along {
+				frac = (clamped - a.Along) / (b.Along - a.Along)
+			}
+			along = clamped
+			d = geo.Distance(lat, lon, a.Lat+frac*(b.Lat-a.Lat), a.Lon+frac*(b.Lon-a.Lon))
+		}
+		if best.Offset >= 0 && d >= best.Offset {
+			continue
+		}
+		best = ShapeMatch{
+			Lat:    a.Lat + frac*(b.Lat-a.Lat),
+			Lon:    a.Lon + frac*(b.Lon-a.Lon),
+			Along:  along,
+			Offset: d,
+		}
+	}
+	return best, best.Offset >= 0 && best.Offset <= m.shapes.MaxOffset
+}
@@ -0,0 +1,43 @@
+package match_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/jaggu/vehicle-tracker-prototype/match"
+)
+
+func TestMatcher_SnapHonoursDirection(t *testing.T) {
+	// shp_5_loop runs east from S1 to S4 along latitude -1.2900 and back
+	// west 11 m further south, along -1.2901.
+	f, run := loadRun(t, "5_1000_loop")
+	m := match.NewMatcher(match.Config{})
+	turnaround := f.Shapes["shp_5_loop"][3].Along
+
+	for _, tc := range []struct {
+		name     string
+		lat, lon float64
+		snapLat  float64
+		leg      string // "out" or "back"
+	}{
+		{"between the legs, at the start", -1.29005, 36.8050, -1.2900, "out"},
+		{"outbound", -1.29001, 36.8250, -1.2900, "out"},
+		{"on the way back", -1.29009, 36.8150, -1.2901, "back"},
+		{"a noisy fix nearer the outbound leg", -1.29003, 36.8120, -1.2901, "back"},
+	} {
+		sm, ok := m.Snap(f, "bus-42", run, tc.lat, tc.lon)
+		if !ok {
+			t.Fatalf("%s: not snapped", tc.name)
+		}
+		if leg := map[bool]string{true: "back", false: "out"}[sm.Along > turnaround]; leg != tc.leg {
+			t.Errorf("%s: matched the %s leg (along %.0f m), want %s", tc.name, leg, sm.Along, tc.leg)
+		}
+		if math.Abs(sm.Lat-tc.snapLat) > 1e-7 || math.Abs(sm.Lon-tc.lon) > 1e-6 {
+			t.Errorf("%s: snapped to %.5f, %.5f, want %.5f, %.5f", tc.name, sm.Lat, sm.Lon, tc.snapLat, tc.lon)
+		}
+	}
+
+	if _, ok := m.Snap(f, "bus-42", run, -1.2950, 36.8100); ok {
+		t.Error("a fix 550 m off the shape was snapped")
+	}
+}
@@ -0,0 +1,275 @@
+// Package match places vehicles on their GTFS trip: the stop a vehicle
+// is at or heading to, and its position snapped onto the trip's shape.
+//
+// Matching is stateful.  A Matcher remembers how far each vehicle has
+// got along its current run, and only ever moves it forward, so a noisy
+// fix near an earlier stop, or a loop or out-and-back route passing the
+// same place twice, doesn't send the vehicle back down the pattern.  A
+// Placer runs it on each fix as the fix is accepted, and stores the
+// result with the location for the feeds to read.
+package match
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jaggu/vehicle-tracker-prototype/geo"
+	"github.com/jaggu/vehicle-tracker-prototype/gtfs"
+)
+
+// Status is where a vehicle is relative to its stop, following the
+// GTFS-RT VehicleStopStatus values.
+type Status int
+
+const (
+	// InTransitTo: the vehicle has left the previous stop and is on its
+	// way to the stop.
+	InTransitTo Status = iota
+
+	// IncomingAt: the vehicle is about to arrive at the stop.
+	IncomingAt
+
+	// StoppedAt: the vehicle is standing at the stop.
+	StoppedAt
+)
+
+// String returns the GTFS-RT name of s.
+func (s Status) String() string {
+	switch s {
+	case IncomingAt:
+		return "INCOMING_AT"
+	case StoppedAt:
+		return "STOPPED_AT"
+	}
+	return "IN_TRANSIT_TO"
+}
+
+// StopConfig sets the stop radii and search window, in meters.
+type StopConfig struct {
+	// StoppedRadius is how close to a stop a vehicle must be to count
+	// as stopped at it.
+	StoppedRadius float64
+
+	// IncomingRadius is how close to its next stop a vehicle must be to
+	// count as arriving there.
+	IncomingRadius float64
+
+	// Lookahead is how far along the stop pattern, from where the
+	// vehicle last was, a fix is matched.  A fix drifting towards a
+	// stop or stretch of road further on, such as across the street on
+	// the way back, can't move the vehicle past it; after a long gap in
+	// reports the vehicle catches up over a few fixes.
+	Lookahead float64
+}
+
+// DefaultStopConfig suits city buses with GPS accurate to a few tens of
+// meters.
+var DefaultStopConfig = StopConfig{
+	StoppedRadius:  30,
+	IncomingRadius: 150,
+	Lookahead:      2000,
+}
+
+// StopMatch is a vehicle's position along its run's stop pattern.
+type StopMatch struct {
+	StopID       string
+	StopSequence int
+
+	// Index is the stop's position in the trip's stop times.
+	Index int
+
+	Status Status
+
+	// Distance is from the vehicle to the stop, in meters.
+	Distance float64
+}
+
+// Config configures a Matcher.
+type Config struct {
+	Stops  StopConfig
+	Shapes ShapeConfig
+}
+
+// Matcher matches vehicle positions to their runs.  It is safe for
+// concurrent use.
+type Matcher struct {
+	stops  StopConfig
+	shapes ShapeConfig
+
+	mu       sync.Mutex
+	progress map[string]progress // by vehicle ID
+	starts   map[string]runStart // by vehicle ID
+}
+
+// progress is how far a vehicle has got along a run.
+type progress struct {
+	run    string
+	next   int  // index of the stop the vehicle is at or heading to
+	atStop bool // the vehicle was stopped at next
+
+	along   float64 // furthest distance along the shape, in meters
+	onShape bool    // along has been set
+}
+
+// NewMatcher returns a Matcher.  Zero-valued config fields take the
+// DefaultStopConfig and DefaultShapeConfig values.
+func NewMatcher(cfg Config) *Matcher {
+	if cfg.Stops.StoppedRadius <= 0 {
+		cfg.Stops.StoppedRadius = DefaultStopConfig.StoppedRadius
+	}
+	if cfg.Stops.IncomingRadius <= 0 {
+		cfg.Stops.IncomingRadius = DefaultStopConfig.IncomingRadius
+	}
+	if cfg.Stops.Lookahead <= 0 {
+		cfg.Stops.Lookahead = DefaultStopConfig.Lookahead
+	}
+	if cfg.Shapes.MaxOffset <= 0 {
+		cfg.Shapes.MaxOffset = DefaultShapeConfig.MaxOffset
+	}
+	if cfg.Shapes.Backtrack <= 0 {
+		cfg.Shapes.Backtrack = DefaultShapeConfig.Backtrack
+	}
+	if cfg.Shapes.Lookahead <= 0 {
+		cfg.Shapes.Lookahead = DefaultShapeConfig.Lookahead
+	}
+	return &Matcher{
+		stops:    cfg.Stops,
+		shapes:   cfg.Shapes,
+		progress: make(map[string]progress),
+		starts:   make(map[string]runStart),
+	}
+}
+
+// RunGap is how long a vehicle may go without a fix on a trip before its
+// next fix on that trip is taken to begin a new run.
+const RunGap = 30 * time.Minute
+
+// runStart is when a vehicle was first seen on a trip.
+type runStart struct {
+	tripID string
+	first  time.Time // the first fix on the trip
+	last   time.Time // the latest fix on the trip
+}
+
+// Started returns when the vehicle, with a fix at at, started its run of
+// tripID: the time of its first fix on the trip.  A trip carried on each
+// report, or inferred, has no start of its own, and locating the run
+// around every fix would move it: an exact_times trip would jump to the
+// run nearest each fix, and one without exact times would get a new
+// start every time.  The start is kept until the vehicle changes trip or
+// has no fix on it for RunGap.
+func (m *Matcher) Started(vehicleID, tripID string, at time.Time) time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rs, ok := m.starts[vehicleID]
+	if !ok || rs.tripID != tripID || at.Sub(rs.last) > RunGap || at.Before(rs.first.Add(-RunGap)) {
+		rs = runStart{tripID: tripID, first: at, last: at}
+	}
+	if at.After(rs.last) {
+		rs.last = at
+	}
+	m.starts[vehicleID] = rs
+	return rs.first
+}
+
+// runProgress returns the vehicle's progress along run, starting afresh
+// when the vehicle was on another run.  m.mu must be held.
+func (m *Matcher) runProgress(vehicleID string, run gtfs.TripInstance) progress {
+	key := runKey(run)
+	prog, ok := m.progress[vehicleID]
+	if !ok || prog.run != key {
+		prog = progress{run: key}
+	}
+	return prog
+}
+
+// runKey identifies a run: the trip and the day and time it started.
+func runKey(run gtfs.TripInstance) string {
+	return run.Trip.ID + "@" + run.StartDate() + " " + run.StartTime()
+}
+
+// Stop finds where the vehicle at (lat, lon) is along run's stops.
+//
+// A vehicle within StoppedRadius of a stop it hasn't yet passed is
+// STOPPED_AT the first such stop.  Otherwise the position is projected
+// onto the segments between consecutive stops, and the vehicle is
+// heading to the end of the first one within StoppedRadius, or else of
+// the nearest one: INCOMING_AT it within IncomingRadius, else
+// IN_TRANSIT_TO.  Only stops at or after the vehicle's last match on the
+// same run, and within Lookahead of it along the pattern, are considered.
+// Taking the first match rather than the nearest, and looking no further
+// ahead, keeps a vehicle on an out-and-back run from jumping to the stop
+// across the road, for the way back, when a fix drifts towards it.
+func (m *Matcher) Stop(f *gtfs.Feed, vehicleID string, run gtfs.TripInstance, lat, lon float64) (StopMatch, bool) {
+	sts := f.StopTimes[run.Trip.ID]
+	if len(sts) == 0 {
+		return StopMatch{}, false
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	prog := m.runProgress(vehicleID, run)
+
+	dist := func(i int) float64 {
+		s := f.Stops[sts[i].StopID]
+		return geo.Distance(lat, lon, s.Lat, s.Lon)
+	}
+	hop := func(i int) float64 { // from stop i to the next
+		a, b := f.Stops[sts[i].StopID], f.Stops[sts[i+1].StopID]
+		return geo.Distance(a.Lat, a.Lon, b.Lat, b.Lon)
+	}
+
+	// Standing at a stop?
+	match := StopMatch{Index: -1}
+	for i, ahead := prog.next, 0.0; i < len(sts) && ahead <= m.stops.Lookahead; i++ {
+		if d := dist(i); d <= m.stops.StoppedRadius {
+			match = StopMatch{Index: i, Status: StoppedAt, Distance: d}
+			break
+		}
+		if i+1 < len(sts) {
+			ahead += hop(i)
+		}
+	}
+
+	// Otherwise heading to the end of the nearest segment.  Having left
+	// a stop, the segment leading to it is behind the vehicle.
+	if match.Index < 0 {
+		first := prog.next - 1
+		if prog.atStop {
+			first = prog.next
+		}
+		best := -1.0
+		for i, ahead := max(first, 0), 0.0; i+1 < len(sts) && ahead <= m.stops.Lookahead; i++ {
+			ahead += hop(i)
+			a, b := f.Stops[sts[i].StopID], f.Stops[sts[i+1].StopID]
+			frac, d := geo.Project(lat, lon, a.Lat, a.Lon, b.Lat, b.Lon)
+			if best >= 0 && d >= best {
+				continue
+			}
+			best = d
+			match.Index = i + 1
+			if i == 0 && frac == 0 {
+				match.Index = 0 // not yet at the first stop
+			}
+			if d <= m.stops.StoppedRadius {
+				break
+			}
+		}
+		if match.Index < 0 {
+			match.Index = len(sts) - 1 // past the last stop
+		}
+		match.Distance = dist(match.Index)
+		match.Status = InTransitTo
+		if match.Distance <= m.stops.IncomingRadius {
+			match.Status = IncomingAt
+		}
+	}
+
+	match.StopID = sts[match.Index].StopID
+	match.StopSequence = sts[match.Index].StopSequence
+	prog.next, prog.atStop = match.Index, match.Status == StoppedAt
+	m.progress[vehicleID] = prog
+	return match, true
+}
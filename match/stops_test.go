@@ -0,0 +1,159 @@
+package match_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jaggu/vehicle-tracker-prototype/gtfs"
+	"github.com/jaggu/vehicle-tracker-prototype/match"
+)
+
+// loadRun loads the test feed and returns the 08:00 run of tripID on
+// Wednesday 15 July 2026.
+func loadRun(t *testing.T, tripID string) (*gtfs.Feed, gtfs.TripInstance) {
+	t.Helper()
+	f, err := gtfs.Load("../gtfs/testdata/kbs")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	run, ok := f.Instance(tripID, time.Date(2026, 7, 15, 5, 0, 0, 0, time.UTC))
+	if !ok {
+		t.Fatalf("no run of %s", tripID)
+	}
+	return f, run
+}
+
+func TestMatcher_StopProgress(t *testing.T) {
+	f, run := loadRun(t, "5_0800_out")
+	m := match.NewMatcher(match.Config{})
+
+	// Stops S1..S4 lie 0.01° (about 1.1 km) apart along latitude -1.29.
+	for _, tc := range []struct {
+		name   string
+		lon    float64
+		stop   string
+		status match.Status
+	}{
+		{"approaching the first stop", 36.7990, "S1", match.IncomingAt},
+		{"at the first stop", 36.8001, "S1", match.StoppedAt},
+		{"halfway to S2", 36.8050, "S2", match.InTransitTo},
+		{"a noisy fix back near S1", 36.8002, "S2", match.InTransitTo},
+		{"arriving at S2", 36.8090, "S2", match.IncomingAt},
+		{"at S2", 36.8100, "S2", match.StoppedAt},
+		{"just left S2", 36.8105, "S3", match.InTransitTo},
+		{"at the last stop", 36.8300, "S4", match.StoppedAt},
+	} {
+		got, ok := m.Stop(f, "bus-42", run, -1.29, tc.lon)
+		if !ok || got.StopID != tc.stop || got.Status != tc.status {
+			t.Errorf("%s: %s %v, want %s %v", tc.name, got.StopID, got.Status, tc.stop, tc.status)
+		}
+	}
+}
+
+func TestMatcher_NewRunStartsOver(t *testing.T) {
+	f, run := loadRun(t, "5_0800_out")
+	m := match.NewMatcher(match.Config{Stops: match.StopConfig{StoppedRadius: 50, IncomingRadius: 200}})
+
+	m.Stop(f, "bus-42", run, -1.29, 36.83) // at the last stop
+	next, _ := f.Instance("5_0900_out", time.Date(2026, 7, 15, 6, 0, 0, 0, time.UTC))
+	if got, _ := m.Stop(f, "bus-42", next, -1.29, 36.8003); got.StopID != "S1" || got.Status != match.StoppedAt {
+		t.Errorf("first stop of the next run: %s %v, want S1 STOPPED_AT", got.StopID, got.Status)
+	}
+}
+
+func TestMatcher_StartedKeepsFirstFixOnTrip(t *testing.T) {
+	m := match.NewMatcher(match.Config{})
+	first := time.Date(2026, 7, 15, 3, 1, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		name string
+		trip string
+		at   time.Time
+		want time.Time
+	}{
+		{"first fix", "5_freq_out", first, first},
+		{"later fix", "5_freq_out", first.Add(10 * time.Minute), first},
+		{"another trip", "5_0830_in", first.Add(20 * time.Minute), first.Add(20 * time.Minute)},
+		{"back on the first trip", "5_freq_out", first.Add(40 * time.Minute), first.Add(40 * time.Minute)},
+		{"after a gap", "5_freq_out", first.Add(40*time.Minute + match.RunGap + time.Second), first.Add(40*time.Minute + match.RunGap + time.Second)},
+	} {
+		if got := m.Started("bus-42", tc.trip, tc.at); !got.Equal(tc.want) {
+			t.Errorf("%s: Started = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+// outAndBack loads an out-and-back trip stopping at A and B on the way
+// out and at B2 and A2, 20 m across the road from them, on the way back,
+// 1.1 km apart.
+func outAndBack(t *testing.T) (*gtfs.Feed, gtfs.TripInstance) {
+	t.Helper()
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"routes.txt": "route_id,route_type\n9,3\n",
+		"trips.txt":  "route_id,trip_id\n9,9_out_back\n",
+		"stops.txt": "stop_id,stop_lat,stop_lon\n" +
+			"A,-1.29000,36.800\nB,-1.29000,36.810\nC,-1.29009,36.820\nB2,-1.29018,36.810\nA2,-1.29018,36.800\n",
+		"stop_times.txt": "trip_id,arrival_time,departure_time,stop_id,stop_sequence\n" +
+			"9_out_back,08:00:00,08:00:00,A,1\n9_out_back,08:05:00,08:05:00,B,2\n9_out_back,08:10:00,08:10:00,C,3\n" +
+			"9_out_back,08:15:00,08:15:00,B2,4\n9_out_back,08:20:00,08:20:00,A2,5\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f, err := gtfs.Load(dir)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	return f, gtfs.TripInstance{Trip: f.Trips["9_out_back"], Start: 8 * 3600}
+}
+
+func TestMatcher_StopOnTheNearSideOfTheRoad(t *testing.T) {
+	f, run := outAndBack(t)
+	m := match.NewMatcher(match.Config{})
+
+	m.Stop(f, "bus-42", run, -1.29, 36.800)
+	// Standing at B, with a fix drifted towards B2 across the road.
+	if got, _ := m.Stop(f, "bus-42", run, -1.29010, 36.810); got.StopID != "B" || got.Status != match.StoppedAt {
+		t.Errorf("at B: %s %v, want B STOPPED_AT", got.StopID, got.Status)
+	}
+	if got, _ := m.Stop(f, "bus-42", run, -1.29, 36.815); got.StopID != "C" {
+		t.Errorf("after B: heading to %s, want C", got.StopID)
+	}
+
+	// Between A and B, drifted towards the road back.
+	m = match.NewMatcher(match.Config{})
+	m.Stop(f, "bus-42", run, -1.29, 36.800)
+	if got, _ := m.Stop(f, "bus-42", run, -1.29010, 36.805); got.StopID != "B" {
+		t.Errorf("between A and B: heading to %s, want B", got.StopID)
+	}
+}
+
+func TestMatcher_DriftingFixDoesNotSkipAhead(t *testing.T) {
+	f, run := outAndBack(t)
+	m := match.NewMatcher(match.Config{})
+
+	// Between A and B, a fix drifts 44 m off the road out, past the road
+	// back, which is more than 2 km further along the pattern.
+	m.Stop(f, "bus-42", run, -1.29, 36.800)
+	if got, _ := m.Stop(f, "bus-42", run, -1.29040, 36.803); got.StopID != "B" {
+		t.Errorf("drifting fix: heading to %s, want B", got.StopID)
+	}
+	if got, _ := m.Stop(f, "bus-42", run, -1.29, 36.806); got.StopID != "B" || got.Index != 1 {
+		t.Errorf("after the drifting fix: heading to %s (index %d), want B", got.StopID, got.Index)
+	}
+
+	// After a gap in reports, the vehicle catches up a window at a time.
+	m = match.NewMatcher(match.Config{})
+	m.Stop(f, "bus-42", run, -1.29, 36.800)
+	if got, _ := m.Stop(f, "bus-42", run, -1.29018, 36.810); got.StopID != "B" {
+		t.Errorf("first fix after the gap: at %s, want B, within reach", got.StopID)
+	}
+	m.Stop(f, "bus-42", run, -1.29009, 36.820)
+	if got, _ := m.Stop(f, "bus-42", run, -1.29018, 36.810); got.StopID != "B2" {
+		t.Errorf("on the way back: at %s, want B2", got.StopID)
+	}
+}
@@ -0,0 +1,225 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+)
+
+// Limits bounds the physically plausible values of a location report.
+//
+// Ranges that are fixed by definition (WGS84 latitude/longitude, a
+// 0–360° bearing, non-negative speed and accuracy) are always enforced;
+// Limits holds the parts an agency may want to tune.
+type Limits struct {
+	// MaxSpeed is the highest plausible speed in meters/second.
+	MaxSpeed float32
+
+	// MaxFutureSkew is how far ahead of the server clock a report's
+	// timestamp may be, to tolerate devices with fast clocks.
+	MaxFutureSkew time.Duration
+
+	// MaxAge is how far behind the server clock a report's timestamp may
+	// be.  It must cover the longest offline period whose points are
+	// still accepted as backfill.
+	MaxAge time.Duration
+}
+
+// DefaultLimits suits road vehicles: up to 50 m/s (180 km/h), device
+// clocks up to two minutes fast, and backfill up to two days old.
+var DefaultLimits = Limits{
+	MaxSpeed:      50,
+	MaxFutureSkew: 2 * time.Minute,
+	MaxAge:        48 * time.Hour,
+}
+
+// limitsJSON is the on-disk form of Limits, with durations written as
+// Go duration strings ("2m", "48h").
+type limitsJSON struct {
+	MaxSpeed      *float32 `json:"max_speed"`
+	MaxFutureSkew *string  `json:"max_future_skew"`
+	MaxAge        *string  `json:"max_age"`
+}
+
+// UnmarshalJSON decodes Limits, leaving fields absent from the input
+// unchanged so that an agency entry only needs to list its overrides.
+func (l *Limits) UnmarshalJSON(data []byte) error {
+	var raw limitsJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if raw.MaxSpeed != nil {
+		l.MaxSpeed = *raw.MaxSpeed
+	}
+	for _, f := range []struct {
+		src *string
+		dst *time.Duration
+		key string
+	}{
+		{raw.MaxFutureSkew, &l.MaxFutureSkew, "max_future_skew"},
+		{raw.MaxAge, &l.MaxAge, "max_age"},
+	} {
+		if f.src == nil {
+			continue
+		}
+		d, err := time.ParseDuration(*f.src)
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.key, err)
+		}
+		*f.dst = d
+	}
+	return nil
+}
+
+// ValidationConfig holds the default Limits and per-agency overrides.
+//
+// Its JSON form is:
+//
+//	{
+//	  "default":  {"max_speed": 50, "max_future_skew": "2m", "max_age": "48h"},
+//	  "agencies": {"kbs": {"max_speed": 30}}
+//	}
+//
+// Agency entries start from the default limits and override only the
+// fields they set.
+type ValidationConfig struct {
+	Default  Limits
+	Agencies map[string]Limits
+}
+
+// DefaultValidationConfig applies DefaultLimits to every agency.
+var DefaultValidationConfig = ValidationConfig{Default: DefaultLimits}
+
+// UnmarshalJSON decodes a ValidationConfig, layering each agency's
+// overrides on top of the default limits.
+func (c *ValidationConfig) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Default  json.RawMessage            `json:"default"`
+		Agencies map[string]json.RawMessage `json:"agencies"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	c.Default = DefaultLimits
+	if raw.Default != nil {
+		if err := json.Unmarshal(raw.Default, &c.Default); err != nil {
+			return fmt.Errorf("default: %w", err)
+		}
+	}
+	c.Agencies = make(map[string]Limits, len(raw.Agencies))
+	for id, msg := range raw.Agencies {
+		limits := c.Default
+		if err := json.Unmarshal(msg, &limits); err != nil {
+			return fmt.Errorf("agency %q: %w", id, err)
+		}
+		c.Agencies[id] = limits
+	}
+	return nil
+}
+
+// LoadValidationConfig reads a ValidationConfig from a JSON file.
+func LoadValidationConfig(path string) (ValidationConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ValidationConfig{}, err
+	}
+	var cfg ValidationConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return ValidationConfig{}, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// LimitsFor returns the limits that apply to an agency, falling back to
+// the default for unknown or empty agency IDs.
+func (c ValidationConfig) LimitsFor(agencyID string) Limits {
+	if l, ok := c.Agencies[agencyID]; ok {
+		return l
+	}
+	return c.Default
+}
+
+// FieldError describes why one field of a report was rejected.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every invalid field of a report.
+type ValidationError []FieldError
+
+// Error joins the field errors into one message.
+func (e ValidationError) Error() string {
+	parts := make([]string, len(e))
+	for i, f := range e {
+		parts[i] = f.Field + ": " + f.Message
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Validate checks loc against WGS84 ranges and the limits, with now as
+// the server time for clock-skew checks.  It returns nil for a valid
+// report and otherwise one FieldError per invalid field.
+func (l Limits) Validate(loc Location, now time.Time) ValidationError {
+	var errs ValidationError
+	add := func(field, format string, args ...any) {
+		errs = append(errs, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if loc.VehicleID == "" {
+		add("vehicle_id", "is required")
+	}
+
+	latOK := !math.IsNaN(loc.Latitude) && loc.Latitude >= -90 && loc.Latitude <= 90
+	lonOK := !math.IsNaN(loc.Longitude) && loc.Longitude >= -180 && loc.Longitude <= 180
+	if !latOK {
+		add("latitude", "must be between -90 and 90")
+	}
+	if !lonOK {
+		add("longitude", "must be between -180 and 180")
+	}
+	// 0,0 is what an unset position decodes to, not a real fix.
+	if latOK && lonOK && loc.Latitude == 0 && loc.Longitude == 0 {
+		add("latitude", "is required")
+		add("longitude", "is required")
+	}
+
+	if isNaN32(loc.Bearing) || loc.Bearing < 0 || loc.Bearing > 360 {
+		add("bearing", "must be between 0 and 360")
+	}
+	if isNaN32(loc.Speed) || loc.Speed < 0 {
+		add("speed", "must not be negative")
+	} else if l.MaxSpeed > 0 && loc.Speed > l.MaxSpeed {
+		add("speed", "must not exceed %g m/s", l.MaxSpeed)
+	}
+	if isNaN32(loc.Accuracy) || loc.Accuracy < 0 {
+		add("accuracy", "must not be negative")
+	}
+
+	switch {
+	case loc.Timestamp < 0:
+		add("timestamp", "must not be negative")
+	case loc.Timestamp == 0:
+		if loc.Backfill {
+			add("timestamp", "is required for backfill points")
+		}
+	default:
+		ts := time.Unix(loc.Timestamp, 0)
+		if l.MaxFutureSkew > 0 && ts.After(now.Add(l.MaxFutureSkew)) {
+			add("timestamp", "is more than %s ahead of server time", l.MaxFutureSkew)
+		}
+		if l.MaxAge > 0 && ts.Before(now.Add(-l.MaxAge)) {
+			add("timestamp", "is more than %s behind server time", l.MaxAge)
+		}
+	}
+
+	return errs
+}
+
+func isNaN32(f float32) bool {
+	return math.IsNaN(float64(f))
+}
@@ -0,0 +1,57 @@
+package model_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/jaggu/vehicle-tracker-prototype/model"
+)
+
+func TestLimits_ValidateClockSkew(t *testing.T) {
+	now := time.Unix(1752566400, 0)
+	loc := model.Location{VehicleID: "bus-1", Latitude: 17.3, Longitude: 78.4}
+
+	for _, tc := range []struct {
+		name  string
+		ts    int64
+		valid bool
+	}{
+		{"no timestamp", 0, true},
+		{"one minute fast", now.Add(time.Minute).Unix(), true},
+		{"next year", now.AddDate(1, 0, 0).Unix(), false},
+		{"one day old", now.Add(-24 * time.Hour).Unix(), true},
+		{"1970", 1000, false},
+	} {
+		loc.Timestamp = tc.ts
+		errs := model.DefaultLimits.Validate(loc, now)
+		if (errs == nil) != tc.valid {
+			t.Errorf("%s: errors = %v, want valid=%v", tc.name, errs, tc.valid)
+		}
+	}
+}
+
+func TestValidationConfig_AgencyOverridesInheritDefault(t *testing.T) {
+	var cfg model.ValidationConfig
+	err := json.Unmarshal([]byte(`{
+		"default":  {"max_speed": 40, "max_age": "1h"},
+		"agencies": {"brt": {"max_speed": 25}}
+	}`), &cfg)
+	if err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+
+	brt := cfg.LimitsFor("brt")
+	if brt.MaxSpeed != 25 {
+		t.Errorf("brt max speed = %v, want 25", brt.MaxSpeed)
+	}
+	if brt.MaxAge != time.Hour {
+		t.Errorf("brt max age = %v, want inherited 1h", brt.MaxAge)
+	}
+	if brt.MaxFutureSkew != model.DefaultLimits.MaxFutureSkew {
+		t.Errorf("brt max future skew = %v, want built-in default", brt.MaxFutureSkew)
+	}
+	if got := cfg.LimitsFor("unknown").MaxSpeed; got != 40 {
+		t.Errorf("unknown agency max speed = %v, want default 40", got)
+	}
+}
@@ -17,6 +17,47 @@ type Location struct {
 	Speed     float32 `json:"speed,omitempty"`
 	Accuracy  float32 `json:"accuracy,omitempty"`
 	Timestamp int64   `json:"timestamp"`
+
+	// Backfill marks a point that was queued on the device while it was
+	// offline.  Backfill points go into history only and never replace the
+	// vehicle's live position.
+	Backfill bool `json:"backfill,omitempty"`
+
+	// Match is where the server placed the fix on its trip when it
+	// accepted it.  Reports can't set it.
+	Match *Match `json:"match,omitempty"`
+}
+
+// Match is a location placed on its trip by map matching at ingest: the
+// trip, the run, the stop the vehicle was at or heading to, and the
+// position snapped onto the trip's shape.  A Match is never modified
+// once set, so Locations sharing one may be copied freely.
+type Match struct {
+	// TripID and RouteID are the trip the vehicle was on: its active
+	// trip, the one on the report, or an inferred one.
+	TripID   string `json:"trip_id"`
+	RouteID  string `json:"route_id,omitempty"`
+	Inferred bool   `json:"inferred,omitempty"`
+
+	// StartedAt is when the vehicle started the run, in Unix seconds.
+	// The run is the trip's scheduled run closest to it.
+	StartedAt int64 `json:"started_at"`
+
+	// StopID is the stop the vehicle was at or heading to, empty when it
+	// wasn't on a scheduled run.  StopIndex is the stop's position in the
+	// trip's stop times, and StopStatus a GTFS-RT VehicleStopStatus name.
+	StopID       string `json:"stop_id,omitempty"`
+	StopSequence int    `json:"stop_sequence,omitempty"`
+	StopIndex    int    `json:"stop_index,omitempty"`
+	StopStatus   string `json:"stop_status,omitempty"`
+
+	// Snapped is set when the fix was close enough to the trip's shape
+	// to be snapped onto it, at (SnappedLatitude, SnappedLongitude),
+	// Along meters from the shape's first point.
+	Snapped          bool    `json:"snapped,omitempty"`
+	SnappedLatitude  float64 `json:"snapped_latitude,omitempty"`
+	SnappedLongitude float64 `json:"snapped_longitude,omitempty"`
+	Along            float64 `json:"along,omitempty"`
 }
 
 // DefaultStalenessThreshold is the maximum age of a location report before
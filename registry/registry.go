@@ -0,0 +1,187 @@
+// Package registry keeps the list of vehicles an agency operates, so that
+// location reports can be checked against it and the GTFS-RT feed can
+// carry human-readable labels and license plates.
+//
+// Design decisions:
+//
+//	The registry is small (hundreds of vehicles) and read on every
+//	report, so it lives in memory behind a RWMutex and is written back
+//	to a JSON file after every change.
+//	Deactivating a vehicle keeps its record, so it can be reactivated
+//	with the same label and plate; deleting it forgets it entirely.
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/jaggu/vehicle-tracker-prototype/jsonfile"
+)
+
+// Errors returned by Registry.
+var (
+	ErrNotFound = errors.New("vehicle not found")
+	ErrExists   = errors.New("vehicle already exists")
+	ErrInvalid  = errors.New("invalid vehicle")
+)
+
+// Vehicle is a registered vehicle.
+type Vehicle struct {
+	ID           string `json:"id"`
+	Label        string `json:"label"`
+	LicensePlate string `json:"license_plate"`
+	AgencyID     string `json:"agency_id"`
+	Active       bool   `json:"active"`
+	Capacity     int    `json:"capacity"` // seated + standing passengers, 0 if unknown
+}
+
+// validate checks the fields every stored vehicle must satisfy.
+func (v Vehicle) validate() error {
+	switch {
+	case v.ID == "":
+		return fmt.Errorf("%w: id is required", ErrInvalid)
+	case v.Capacity < 0:
+		return fmt.Errorf("%w: capacity must not be negative", ErrInvalid)
+	}
+	return nil
+}
+
+// Registry is the set of registered vehicles.  It is safe for concurrent
+// use.
+type Registry struct {
+	path string
+
+	mu       sync.RWMutex
+	vehicles map[string]Vehicle
+}
+
+// Open returns a Registry stored in the JSON file at path, loading the
+// vehicles already there.  A missing file is an empty registry; an empty
+// path keeps the registry in memory only.
+func Open(path string) (*Registry, error) {
+	r := &Registry{path: path, vehicles: make(map[string]Vehicle)}
+	if path == "" {
+		return r, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []Vehicle
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for _, v := range list {
+		if err := v.validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		r.vehicles[v.ID] = v
+	}
+	return r, nil
+}
+
+// List returns every vehicle, ordered by ID.
+func (r *Registry) List() []Vehicle {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.listLocked()
+}
+
+func (r *Registry) listLocked() []Vehicle {
+	list := make([]Vehicle, 0, len(r.vehicles))
+	for _, v := range r.vehicles {
+		list = append(list, v)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// Get returns the vehicle with the given ID.
+func (r *Registry) Get(id string) (Vehicle, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	v, ok := r.vehicles[id]
+	return v, ok
+}
+
+// AgencyOf returns the agency of a vehicle, or "" if it isn't registered.
+func (r *Registry) AgencyOf(id string) string {
+	v, _ := r.Get(id)
+	return v.AgencyID
+}
+
+// Create adds a new vehicle.
+func (r *Registry) Create(v Vehicle) error {
+	if err := v.validate(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.vehicles[v.ID]; ok {
+		return ErrExists
+	}
+	return r.commitLocked(v.ID, &v)
+}
+
+// Update replaces an existing vehicle.
+func (r *Registry) Update(v Vehicle) error {
+	if err := v.validate(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.vehicles[v.ID]; !ok {
+		return ErrNotFound
+	}
+	return r.commitLocked(v.ID, &v)
+}
+
+// Delete removes a vehicle.
+func (r *Registry) Delete(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.vehicles[id]; !ok {
+		return ErrNotFound
+	}
+	return r.commitLocked(id, nil)
+}
+
+// commitLocked sets (or, with a nil v, deletes) a vehicle and saves the
+// registry, undoing the change if saving fails.  r.mu must be held.
+func (r *Registry) commitLocked(id string, v *Vehicle) error {
+	old, existed := r.vehicles[id]
+	if v != nil {
+		r.vehicles[id] = *v
+	} else {
+		delete(r.vehicles, id)
+	}
+
+	if err := r.saveLocked(); err != nil {
+		if existed {
+			r.vehicles[id] = old
+		} else {
+			delete(r.vehicles, id)
+		}
+		return err
+	}
+	return nil
+}
+
+// saveLocked rewrites the registry file atomically.  r.mu must be held.
+func (r *Registry) saveLocked() error {
+	if r.path == "" {
+		return nil
+	}
+	if err := jsonfile.Write(r.path, r.listLocked()); err != nil {
+		return fmt.Errorf("registry: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package registry_test
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/jaggu/vehicle-tracker-prototype/registry"
+)
+
+func TestRegistry_CRUDPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vehicles.json")
+	r, err := registry.Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	bus := registry.Vehicle{ID: "bus-42", Label: "Route 5 Express", LicensePlate: "KCA 123A", AgencyID: "kbs", Active: true, Capacity: 60}
+	if err := r.Create(bus); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if err := r.Create(bus); !errors.Is(err, registry.ErrExists) {
+		t.Errorf("duplicate Create: err = %v, want ErrExists", err)
+	}
+	if err := r.Create(registry.Vehicle{ID: "bad", Capacity: -1}); !errors.Is(err, registry.ErrInvalid) {
+		t.Errorf("negative capacity: err = %v, want ErrInvalid", err)
+	}
+
+	bus.Active = false
+	if err := r.Update(bus); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if err := r.Update(registry.Vehicle{ID: "ghost"}); !errors.Is(err, registry.ErrNotFound) {
+		t.Errorf("Update unknown: err = %v, want ErrNotFound", err)
+	}
+
+	r, err = registry.Open(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	got, ok := r.Get("bus-42")
+	if !ok || got != bus {
+		t.Fatalf("after reopen Get = %+v, %v; want %+v", got, ok, bus)
+	}
+	if agency := r.AgencyOf("bus-42"); agency != "kbs" {
+		t.Errorf("AgencyOf = %q, want kbs", agency)
+	}
+
+	if err := r.Delete("bus-42"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if len(r.List()) != 0 {
+		t.Errorf("List after delete = %v, want empty", r.List())
+	}
+}
@@ -4,41 +4,212 @@ package server
 import (
 	"fmt"
 	"net/http"
+	"time"
 
+	"github.com/jaggu/vehicle-tracker-prototype/alerts"
+	"github.com/jaggu/vehicle-tracker-prototype/auth"
+	"github.com/jaggu/vehicle-tracker-prototype/clock"
+	"github.com/jaggu/vehicle-tracker-prototype/gtfs"
+	"github.com/jaggu/vehicle-tracker-prototype/gtfsrt"
 	"github.com/jaggu/vehicle-tracker-prototype/handler"
+	"github.com/jaggu/vehicle-tracker-prototype/match"
+	"github.com/jaggu/vehicle-tracker-prototype/model"
+	"github.com/jaggu/vehicle-tracker-prototype/registry"
 	"github.com/jaggu/vehicle-tracker-prototype/store"
+	"github.com/jaggu/vehicle-tracker-prototype/trips"
 )
 
-// Run starts the HTTP server on the given port.
+// Config holds the server's tunable settings.
+type Config struct {
+	Port       int
+	Batch      handler.BatchConfig
+	Validation model.ValidationConfig
+
+	// Auth authenticates drivers.  When nil, the driver-facing endpoints
+	// accept unauthenticated reports for any vehicle.
+	Auth *auth.Authenticator
+
+	// Feed controls API-key access to the GTFS-RT feed.
+	Feed handler.FeedAccess
+
+	// AdminToken guards the /api/v1/admin endpoints; empty disables them.
+	AdminToken string
+
+	// AgencyOf maps a vehicle to its agency for per-agency validation
+	// limits and feed filtering.  It defaults to the registry's agency
+	// when Vehicles is set, and may be nil otherwise.
+	AgencyOf func(vehicleID string) string
+
+	// Vehicles is the vehicle registry.  When set, only registered,
+	// active vehicles may report, and the feed uses their labels.
+	Vehicles *registry.Registry
+
+	// Trips holds the trip each vehicle is running.  When set, drivers
+	// can start and end trips, and reports and the feed inherit them.
+	Trips *trips.Manager
+
+	// Schedule is the agency's GTFS static schedule.  When set, reports
+	// and trips may only name trips and routes in it, and the feed
+	// describes trips by their scheduled start.
+	Schedule *gtfs.Schedule
+
+	// Match configures placing vehicles on scheduled trips: the stop
+	// radii and snapping onto shapes.  It has no effect without Schedule.
+	Match match.Config
+
+	// SnapToShape publishes positions snapped onto the trip's shape.
+	SnapToShape bool
+
+	// Infer configures guessing the trip of vehicles reporting without
+	// one.  It has no effect without Schedule.
+	Infer match.InferConfig
+
+	// Alerts holds the service alerts.  When set, admins can publish
+	// alerts and the Alerts feed is served.
+	Alerts *alerts.Manager
+
+	// StreamInterval is how often the DIFFERENTIAL stream is rebuilt;
+	// zero means gtfsrt.DefaultStreamInterval.
+	StreamInterval time.Duration
+}
+
+// Run starts the HTTP server on the configured port, backed by the given
+// store.
 //
-// It creates the in-memory store, registers routes, and blocks
-// until the server is shut down or encounters a fatal error.
-func Run(port int) error {
+// It registers routes and blocks until the server is shut down or
+// encounters a fatal error.  Any store.Store implementation can be used;
+// main picks one based on its flags.
+func Run(cfg Config, s store.Store) error {
 
-	// Create shared in-memory store
-	s := store.New()
+	// Production code reads the real clock; tests construct handlers
+	// with a fake one.
+	clk := clock.Real
+	if cfg.AgencyOf == nil && cfg.Vehicles != nil {
+		cfg.AgencyOf = cfg.Vehicles.AgencyOf
+	}
+	builder := &gtfsrt.Builder{Clock: clk, Vehicles: cfg.Vehicles, Trips: cfg.Trips, Schedule: cfg.Schedule, Alerts: cfg.Alerts}
+	// Fixes are placed on their trips as they are accepted; the feeds
+	// only read the result.
+	placer := &match.Placer{Clock: clk, Schedule: cfg.Schedule, Trips: cfg.Trips}
+	if cfg.Schedule != nil {
+		placer.Matcher = match.NewMatcher(cfg.Match)
+		placer.Inferrer = match.NewInferrer(cfg.Infer, clk, s.Trail)
+		builder.Snap = cfg.SnapToShape
+	}
+	streamer := gtfsrt.NewStreamer(clk, cfg.StreamInterval)
+	defer streamer.Close()
+	validator := handler.NewValidator(cfg.Validation, clk)
+	validator.AgencyOf = cfg.AgencyOf
+	validator.Vehicles = cfg.Vehicles
+	validator.Schedule = cfg.Schedule
 
 	// Register routes
 	mux := http.NewServeMux()
 
 	// --- Driver-facing endpoints ---
-	mux.HandleFunc("/location", handler.PostLocation(s))        // legacy endpoint
-	mux.HandleFunc("/api/v1/locations", handler.PostLocation(s)) // matches mentor spec
+	postLocation := handler.RequireDriver(cfg.Auth, handler.PostLocation(s, validator, cfg.Trips, placer))
+	mux.HandleFunc("/location", postLocation)         // legacy endpoint
+	mux.HandleFunc("/api/v1/locations", postLocation) // matches mentor spec
+	mux.HandleFunc("/api/v1/locations/batch", handler.RequireDriver(cfg.Auth, handler.PostLocationBatch(s, validator, cfg.Trips, placer, cfg.Batch)))
+	if cfg.Auth != nil {
+		mux.HandleFunc("/api/v1/auth/login", handler.PostLogin(cfg.Auth))
+		mux.HandleFunc("/api/v1/auth/refresh", handler.PostRefresh(cfg.Auth))
+		mux.HandleFunc("/api/v1/auth/logout", handler.PostLogout(cfg.Auth, cfg.Trips))
+	}
+	if cfg.Trips != nil {
+		mux.HandleFunc("/api/v1/trips/start", handler.RequireDriver(cfg.Auth, handler.PostTripStart(cfg.Trips, validator)))
+		mux.HandleFunc("/api/v1/trips/end", handler.RequireDriver(cfg.Auth, handler.PostTripEnd(cfg.Trips)))
+	}
 
 	// --- GTFS-RT feed ---
-	mux.HandleFunc("/gtfs-rt/vehicle-positions", handler.GetGTFSRT(s))
+	mux.HandleFunc("/gtfs-rt/vehicle-positions", handler.RequireAPIKey(cfg.Feed, handler.GetGTFSRT(s, builder, cfg.AgencyOf)))
+	if cfg.Schedule != nil {
+		mux.HandleFunc("/gtfs-rt/trip-updates", handler.RequireAPIKey(cfg.Feed, handler.GetTripUpdates(s, builder, cfg.AgencyOf)))
+	}
+	mux.HandleFunc("/gtfs-rt/feed", handler.RequireAPIKey(cfg.Feed, handler.GetCombinedFeed(s, builder, cfg.AgencyOf)))
+	mux.HandleFunc("/gtfs-rt/stream", handler.RequireAPIKey(cfg.Feed, handler.GetFeedStream(s, builder, cfg.AgencyOf, streamer)))
+	if cfg.Alerts != nil {
+		mux.HandleFunc("/gtfs-rt/alerts", handler.RequireAPIKey(cfg.Feed, handler.GetAlerts(s, builder, cfg.AgencyOf)))
+	}
 
 	// --- Operational endpoints ---
 	mux.HandleFunc("/vehicles", handler.GetVehicles(s))
-	mux.HandleFunc("/api/v1/status", handler.GetStatus(s))
+	mux.HandleFunc("/api/v1/status", handler.GetStatus(s, clk))
+
+	// --- Admin endpoints ---
+	admin := func(h http.HandlerFunc) http.HandlerFunc { return handler.RequireAdmin(cfg.AdminToken, h) }
+	mux.HandleFunc("/api/v1/vehicles/{id}/trail", admin(handler.GetTrail(s)))
+	mux.HandleFunc("/api/v1/admin/quarantine", admin(handler.GetQuarantine(s)))
+	if cfg.Auth != nil {
+		mux.HandleFunc("/api/v1/admin/drivers", admin(handler.AdminDrivers(cfg.Auth, cfg.Vehicles, cfg.Trips)))
+	}
+	if cfg.Vehicles != nil {
+		mux.HandleFunc("/api/v1/admin/vehicles", admin(handler.AdminVehicles(cfg.Vehicles)))
+		mux.HandleFunc("/api/v1/admin/vehicles/{id}", admin(handler.AdminVehicle(cfg.Vehicles)))
+	}
+	if cfg.Alerts != nil {
+		mux.HandleFunc("/api/v1/admin/alerts", admin(handler.AdminAlerts(cfg.Alerts, cfg.Schedule)))
+		mux.HandleFunc("/api/v1/admin/alerts/{id}", admin(handler.AdminAlert(cfg.Alerts, cfg.Schedule)))
+		mux.HandleFunc("/api/v1/admin/alerts/{id}/expire", admin(handler.ExpireAlert(cfg.Alerts)))
+	}
+	if placer.Inferrer != nil {
+		mux.HandleFunc("/api/v1/admin/inference", admin(handler.GetInference(placer.Inferrer)))
+	}
+	if cfg.Feed.Keys != nil {
+		mux.HandleFunc("/api/v1/admin/api-keys", admin(handler.AdminAPIKeys(cfg.Feed.Keys)))
+		mux.HandleFunc("/api/v1/admin/api-keys/{id}", admin(handler.DeleteAPIKey(cfg.Feed.Keys)))
+	}
 
 	// Start listening
-	addr := fmt.Sprintf(":%d", port)
+	addr := fmt.Sprintf(":%d", cfg.Port)
 	fmt.Printf("Vehicle Tracker server listening on http://localhost%s\n", addr)
+	if cfg.Auth != nil {
+		fmt.Printf("  POST /api/v1/auth/login          — driver login (phone + PIN → JWT)\n")
+		fmt.Printf("  POST /api/v1/auth/refresh        — exchange a refresh token\n")
+		fmt.Printf("  POST /api/v1/auth/logout         — revoke tokens\n")
+	} else {
+		fmt.Printf("  WARNING: driver authentication is disabled\n")
+	}
 	fmt.Printf("  POST /api/v1/locations           — submit vehicle GPS data\n")
+	fmt.Printf("  POST /api/v1/locations/batch     — submit many GPS fixes at once\n")
+	if cfg.Trips != nil {
+		fmt.Printf("  POST /api/v1/trips/start         — start a trip for a vehicle\n")
+		fmt.Printf("  POST /api/v1/trips/end           — end the vehicle's trip\n")
+	}
 	fmt.Printf("  GET  /gtfs-rt/vehicle-positions   — GTFS-RT protobuf feed\n")
 	fmt.Printf("  GET  /gtfs-rt/vehicle-positions?format=json — feed as JSON\n")
+	if cfg.Schedule != nil {
+		fmt.Printf("  GET  /gtfs-rt/trip-updates        — GTFS-RT predicted arrivals\n")
+	}
+	fmt.Printf("  GET  /gtfs-rt/feed                — combined feed (?include=, ?exclude=, ?route_id=)\n")
+	fmt.Printf("  GET  /gtfs-rt/stream              — combined feed as a DIFFERENTIAL event stream\n")
+	if cfg.Alerts != nil {
+		fmt.Printf("  GET  /gtfs-rt/alerts              — GTFS-RT service alerts\n")
+	}
 	fmt.Printf("  GET  /vehicles                    — all vehicle locations\n")
 	fmt.Printf("  GET  /api/v1/status               — system health\n")
+	fmt.Printf("  GET  /api/v1/vehicles/{id}/trail  — recent trail (JSON or ?format=geojson)\n")
+	fmt.Printf("  GET  /api/v1/admin/quarantine     — reports rejected as GPS jumps\n")
+	if cfg.Auth != nil {
+		fmt.Printf("  GET/POST /api/v1/admin/drivers    — list, create and update drivers\n")
+	}
+	if cfg.Vehicles != nil {
+		fmt.Printf("  GET/POST /api/v1/admin/vehicles   — list and register vehicles\n")
+		fmt.Printf("  GET/PUT/DELETE /api/v1/admin/vehicles/{id} — manage a vehicle\n")
+	}
+	if cfg.Alerts != nil {
+		fmt.Printf("  GET/POST /api/v1/admin/alerts     — list and create service alerts\n")
+		fmt.Printf("  GET/PUT/DELETE /api/v1/admin/alerts/{id} — manage an alert\n")
+		fmt.Printf("  POST /api/v1/admin/alerts/{id}/expire — end an alert now\n")
+	}
+	if placer.Inferrer != nil {
+		fmt.Printf("  GET  /api/v1/admin/inference      — trip inference decisions\n")
+	}
+	if cfg.Feed.Keys != nil {
+		fmt.Printf("  GET/POST /api/v1/admin/api-keys   — list and create feed API keys\n")
+		fmt.Printf("  DELETE /api/v1/admin/api-keys/{id} — revoke a feed API key\n")
+	} else {
+		fmt.Printf("  NOTE: the GTFS-RT feed is public (no -api-keys file)\n")
+	}
 	return http.ListenAndServe(addr, mux)
 }
@@ -0,0 +1,86 @@
+package store_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jaggu/vehicle-tracker-prototype/clock"
+	"github.com/jaggu/vehicle-tracker-prototype/model"
+	"github.com/jaggu/vehicle-tracker-prototype/store"
+)
+
+func TestBackfill_FlaggedPointsGoToHistoryOnly(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1752566400, 0))
+	s := store.New(store.WithClock(clk))
+	defer s.Close()
+
+	s.UpdateLocation(model.Location{VehicleID: "bus-1", Latitude: 17.3, Longitude: 78.3, Timestamp: 1752566400})
+
+	// A queued point from before the live one, flagged by the app.
+	res, err := s.UpdateLocation(model.Location{VehicleID: "bus-1", Latitude: 17.1, Longitude: 78.1, Timestamp: 1752566100, Backfill: true})
+	if err != nil || res != store.Backfilled {
+		t.Fatalf("flagged point: result = %v, %v; want Backfilled", res, err)
+	}
+
+	// Even a flagged point newer than the live one must not replace it.
+	res, _ = s.UpdateLocation(model.Location{VehicleID: "bus-1", Latitude: 17.5, Longitude: 78.5, Timestamp: 1752566410, Backfill: true})
+	if res != store.Backfilled {
+		t.Fatalf("newer flagged point: result = %v, want Backfilled", res)
+	}
+
+	if live := s.GetActiveLocations(5 * time.Minute); len(live) != 1 || live[0].Latitude != 17.3 {
+		t.Errorf("live location = %v, want the unchanged point at latitude 17.3", live)
+	}
+
+	// A retried upload of the same points is not recorded twice.
+	for _, ts := range []int64{1752566100, 1752566400} {
+		if res, _ := s.UpdateLocation(model.Location{VehicleID: "bus-1", Latitude: 17.1, Longitude: 78.1, Timestamp: ts, Backfill: true}); res != store.IgnoredDuplicate {
+			t.Errorf("retried point at %d: result = %v, want IgnoredDuplicate", ts, res)
+		}
+	}
+
+	trail := s.Trail("bus-1")
+	if len(trail) != 3 {
+		t.Fatalf("trail length = %d, want 3", len(trail))
+	}
+	for i, want := range []int64{1752566100, 1752566400, 1752566410} {
+		if trail[i].Timestamp != want {
+			t.Errorf("trail[%d].Timestamp = %d, want %d (timestamp order)", i, trail[i].Timestamp, want)
+		}
+	}
+}
+
+func TestBackfill_DetectedByAgeAndReported(t *testing.T) {
+	now := time.Unix(1752566400, 0)
+	clk := clock.NewFake(now)
+	s := store.New(
+		store.WithClock(clk),
+		store.WithBackfill(store.BackfillConfig{MaxLiveAge: 5 * time.Minute}),
+	)
+	defer s.Close()
+
+	// Ten minutes old and unflagged: detected as backfill.
+	res, _ := s.UpdateLocation(model.Location{VehicleID: "bus-1", Latitude: 17.1, Longitude: 78.1, Timestamp: now.Add(-10 * time.Minute).Unix()})
+	if res != store.Backfilled {
+		t.Fatalf("old point: result = %v, want Backfilled", res)
+	}
+	if got := s.TotalVehicleCount(); got != 0 {
+		t.Errorf("backfill created a live vehicle: total = %d, want 0", got)
+	}
+
+	res, _ = s.UpdateLocation(model.Location{VehicleID: "bus-1", Latitude: 17.2, Longitude: 78.2, Timestamp: now.Add(-20 * time.Minute).Unix()})
+	if res != store.Backfilled {
+		t.Fatalf("older point: result = %v, want Backfilled", res)
+	}
+
+	stats := s.Stats()
+	if stats.BackfillPoints != 2 {
+		t.Errorf("backfill points = %d, want 2", stats.BackfillPoints)
+	}
+	if stats.BackfillLatency != 15*time.Minute {
+		t.Errorf("average backfill latency = %v, want 15m", stats.BackfillLatency)
+	}
+	if stats.BackfillLatencyMax != 20*time.Minute {
+		t.Errorf("max backfill latency = %v, want 20m", stats.BackfillLatencyMax)
+	}
+}
@@ -0,0 +1,57 @@
+package store
+
+import "time"
+
+// RetentionConfig controls eviction of vehicles that have stopped
+// reporting.  Every Interval, vehicles whose last report is older than
+// Window are removed along with their history.
+type RetentionConfig struct {
+	Window   time.Duration
+	Interval time.Duration
+}
+
+// DefaultRetentionConfig forgets vehicles a day after their last report.
+var DefaultRetentionConfig = RetentionConfig{
+	Window:   24 * time.Hour,
+	Interval: time.Minute,
+}
+
+// EvictDead removes every vehicle that hasn't reported within the
+// retention window and returns how many were removed.  The janitor calls
+// it once per RetentionConfig.Interval; it is exported so operators and
+// tests can force a pass.
+func (s *MemoryStore) EvictDead() int {
+	if s.retention.Window <= 0 {
+		return 0
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cutoff := s.clock.Now().Add(-s.retention.Window)
+	evicted := 0
+	for id, at := range s.receivedAt {
+		if at.After(cutoff) {
+			continue
+		}
+		delete(s.locations, id)
+		delete(s.receivedAt, id)
+		delete(s.jumped, id)
+		s.dropTrailLocked(id)
+		evicted++
+	}
+
+	// Vehicles known only through backfill have a trail but no live
+	// position; drop their trail once its newest point has expired.
+	for id, r := range s.trails {
+		if _, live := s.locations[id]; live {
+			continue
+		}
+		if r.n == 0 || !r.at(r.n-1).receivedAt.After(cutoff) {
+			s.dropTrailLocked(id)
+		}
+	}
+
+	s.evictions += evicted
+	return evicted
+}
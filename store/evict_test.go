@@ -0,0 +1,93 @@
+package store_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jaggu/vehicle-tracker-prototype/clock"
+	"github.com/jaggu/vehicle-tracker-prototype/model"
+	"github.com/jaggu/vehicle-tracker-prototype/store"
+)
+
+// waitFor polls cond until it holds; the janitor runs on its own goroutine,
+// so a tick delivered by the fake clock is processed asynchronously.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met before deadline")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestEvictDead_RemovesVehiclesPastRetention(t *testing.T) {
+	clk := clock.NewFake(time.Date(2026, 7, 15, 8, 0, 0, 0, time.UTC))
+	s := store.New(
+		store.WithClock(clk),
+		store.WithRetention(store.RetentionConfig{Window: time.Hour, Interval: time.Minute}),
+	)
+	defer s.Close()
+
+	s.UpdateLocation(model.Location{VehicleID: "bus-old", Latitude: 17.0, Longitude: 78.0})
+	clk.Advance(40 * time.Minute)
+	s.UpdateLocation(model.Location{VehicleID: "bus-new", Latitude: 17.1, Longitude: 78.1})
+	clk.Advance(30 * time.Minute)
+
+	if n := s.EvictDead(); n != 1 {
+		t.Fatalf("evicted %d vehicles, want 1", n)
+	}
+	all := s.GetAllLocations()
+	if len(all) != 1 || all[0].VehicleID != "bus-new" {
+		t.Errorf("remaining vehicles = %v, want only bus-new", all)
+	}
+	if s.Trail("bus-old") != nil {
+		t.Error("evicted vehicle's trail should be dropped")
+	}
+	if got := s.Stats().Evictions; got != 1 {
+		t.Errorf("eviction counter = %d, want 1", got)
+	}
+}
+
+func TestEvictDead_JanitorRunsOnTick(t *testing.T) {
+	clk := clock.NewFake(time.Date(2026, 7, 15, 8, 0, 0, 0, time.UTC))
+	s := store.New(
+		store.WithClock(clk),
+		store.WithRetention(store.RetentionConfig{Window: time.Hour, Interval: time.Minute}),
+	)
+	defer s.Close()
+
+	s.UpdateLocation(model.Location{VehicleID: "bus-1", Latitude: 17.0, Longitude: 78.0})
+
+	// Within retention nothing is evicted.  The janitor's ticks are
+	// handled asynchronously, so check with a pass of our own.
+	clk.Advance(59 * time.Minute)
+	if n := s.EvictDead(); n != 0 || s.TotalVehicleCount() != 1 {
+		t.Fatalf("vehicle evicted before retention elapsed")
+	}
+
+	clk.Advance(2 * time.Minute)
+	waitFor(t, func() bool { return s.TotalVehicleCount() == 0 })
+}
+
+func TestClose_StopsJanitor(t *testing.T) {
+	clk := clock.NewFake(time.Date(2026, 7, 15, 8, 0, 0, 0, time.UTC))
+	s := store.New(
+		store.WithClock(clk),
+		store.WithRetention(store.RetentionConfig{Window: time.Hour, Interval: time.Minute}),
+	)
+	s.UpdateLocation(model.Location{VehicleID: "bus-1", Latitude: 17.0, Longitude: 78.0})
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("second Close: %v", err)
+	}
+
+	clk.Advance(2 * time.Hour)
+	if got := s.TotalVehicleCount(); got != 1 {
+		t.Errorf("janitor evicted after Close: vehicle count = %d, want 1", got)
+	}
+}
@@ -0,0 +1,318 @@
+package store
+
+import (
+	"sort"
+	"time"
+	"unsafe"
+
+	"github.com/jaggu/vehicle-tracker-prototype/model"
+)
+
+// HistoryConfig bounds the per-vehicle location history kept by MemoryStore.
+//
+// Each vehicle keeps at most MaxPoints points, none older than MaxAge, and
+// the store as a whole never holds more than MaxTotalPoints; when that cap
+// is reached the oldest point across all vehicles is dropped first.
+type HistoryConfig struct {
+	MaxPoints      int
+	MaxAge         time.Duration
+	MaxTotalPoints int
+}
+
+// BackfillConfig controls which reports are treated as backfill besides
+// those explicitly flagged by the client.
+type BackfillConfig struct {
+	// MaxLiveAge is the oldest a report's client timestamp may be, relative
+	// to the server clock, for it to become the live position.  Older
+	// reports are recorded as backfill.  Zero disables age detection.
+	MaxLiveAge time.Duration
+}
+
+// DefaultHistoryConfig keeps roughly the last half hour of a vehicle
+// reporting every 10 seconds, for up to 500 such vehicles.
+var DefaultHistoryConfig = HistoryConfig{
+	MaxPoints:      180,
+	MaxAge:         30 * time.Minute,
+	MaxTotalPoints: 90_000,
+}
+
+// historyPoint is one entry of a vehicle's trail.
+type historyPoint struct {
+	loc        model.Location
+	receivedAt time.Time
+}
+
+// fixTime orders points in a trail: the client timestamp when present,
+// otherwise the server receive time.
+func (p historyPoint) fixTime() int64 {
+	if p.loc.Timestamp > 0 {
+		return p.loc.Timestamp
+	}
+	return p.receivedAt.Unix()
+}
+
+// slotSize is the memory taken by one slot of a ring, used or not.
+const slotSize = int64(unsafe.Sizeof(historyPoint{}))
+
+// pointSize estimates the memory held by the string fields and match of
+// a history point, beyond its slot.
+func pointSize(p historyPoint) int64 {
+	return int64(len(p.loc.VehicleID)+len(p.loc.TripID)+len(p.loc.RouteID)) + matchSize(p.loc.Match)
+}
+
+// matchSize estimates the memory held by m.
+func matchSize(m *model.Match) int64 {
+	if m == nil {
+		return 0
+	}
+	return int64(unsafe.Sizeof(*m)) + int64(len(m.TripID)+len(m.RouteID)+len(m.StopID)+len(m.StopStatus))
+}
+
+// minRingSlots is the capacity a ring starts with.
+const minRingSlots = 8
+
+// ring is a circular buffer of history points, oldest first.  It holds at
+// most max points, and grows and shrinks with the number it holds so
+// that quiet vehicles don't reserve room for a full trail.
+type ring struct {
+	buf   []historyPoint
+	start int
+	n     int
+	max   int
+}
+
+func newRing(max int) *ring {
+	return &ring{max: max}
+}
+
+// resize moves the points into a buffer of the given capacity, which
+// must hold them all.
+func (r *ring) resize(capacity int) {
+	buf := make([]historyPoint, capacity)
+	for i := 0; i < r.n; i++ {
+		buf[i] = r.at(i)
+	}
+	r.buf, r.start = buf, 0
+}
+
+// at returns the i-th oldest point.
+func (r *ring) at(i int) historyPoint {
+	return r.buf[(r.start+i)%len(r.buf)]
+}
+
+// push appends p, overwriting the oldest point when the ring is full.
+// It reports the overwritten point, if any.
+func (r *ring) push(p historyPoint) (historyPoint, bool) {
+	if r.n == len(r.buf) {
+		old := r.buf[r.start]
+		r.buf[r.start] = p
+		r.start = (r.start + 1) % len(r.buf)
+		return old, true
+	}
+	r.buf[(r.start+r.n)%len(r.buf)] = p
+	r.n++
+	return historyPoint{}, false
+}
+
+// insert places p in timestamp order, so that late-arriving backfill
+// points land where they belong in the trail.  When the ring is full the
+// oldest point is dropped to make room; a point older than everything in
+// a full ring is not inserted.  It reports the dropped point, if any, and
+// whether p was inserted.
+func (r *ring) insert(p historyPoint) (dropped historyPoint, wasDropped, inserted bool) {
+	if r.n == len(r.buf) && len(r.buf) < r.max {
+		r.resize(min(max(2*len(r.buf), minRingSlots), r.max))
+	}
+	pos := r.n
+	for pos > 0 && r.at(pos-1).fixTime() > p.fixTime() {
+		pos--
+	}
+	if pos == r.n {
+		dropped, wasDropped = r.push(p)
+		return dropped, wasDropped, true
+	}
+	if r.n == len(r.buf) {
+		if pos == 0 {
+			return historyPoint{}, false, false
+		}
+		dropped, wasDropped = r.popOldest(), true
+		pos--
+	}
+	for i := r.n; i > pos; i-- {
+		r.buf[(r.start+i)%len(r.buf)] = r.at(i - 1)
+	}
+	r.buf[(r.start+pos)%len(r.buf)] = p
+	r.n++
+	return dropped, wasDropped, true
+}
+
+// hasFix reports whether r holds a point with the client timestamp ts.
+// r may be nil.
+func (r *ring) hasFix(ts int64) bool {
+	if r == nil {
+		return false
+	}
+	i := sort.Search(r.n, func(i int) bool { return r.at(i).fixTime() >= ts })
+	for ; i < r.n && r.at(i).fixTime() == ts; i++ {
+		if r.at(i).loc.Timestamp == ts {
+			return true
+		}
+	}
+	return false
+}
+
+// setMatch sets the match of the newest point with the client timestamp
+// ts and returns the match it replaces.  It reports false if r holds no
+// such point.  r may be nil.
+func (r *ring) setMatch(ts int64, m *model.Match) (*model.Match, bool) {
+	if r == nil {
+		return nil, false
+	}
+	for i := r.n - 1; i >= 0; i-- {
+		j := (r.start + i) % len(r.buf)
+		if r.buf[j].loc.Timestamp == ts {
+			old := r.buf[j].loc.Match
+			r.buf[j].loc.Match = m
+			return old, true
+		}
+		if r.buf[j].fixTime() < ts {
+			break
+		}
+	}
+	return nil, false
+}
+
+// popOldest removes and returns the oldest point, shrinking the ring once
+// it is mostly empty.  The ring must not be empty.
+func (r *ring) popOldest() historyPoint {
+	p := r.buf[r.start]
+	r.buf[r.start] = historyPoint{}
+	r.start = (r.start + 1) % len(r.buf)
+	r.n--
+	if len(r.buf) > minRingSlots && r.n <= len(r.buf)/4 {
+		r.resize(max(len(r.buf)/2, minRingSlots))
+	}
+	return p
+}
+
+// recordHistoryLocked adds a point to the vehicle's trail in timestamp
+// order and enforces the per-vehicle and store-wide limits.  Age limits
+// use the server receive time, so the store-wide limit is approximate for
+// backfilled points.  s.mu must be held for writing.
+func (s *MemoryStore) recordHistoryLocked(p historyPoint) {
+	if s.history.MaxPoints <= 0 {
+		return
+	}
+
+	r, ok := s.trails[p.loc.VehicleID]
+	if !ok {
+		r = newRing(s.history.MaxPoints)
+		s.trails[p.loc.VehicleID] = r
+	}
+	slots := len(r.buf)
+	old, dropped, inserted := r.insert(p)
+	s.historyBytes += int64(len(r.buf)-slots) * slotSize
+	if !inserted {
+		return
+	}
+	if dropped {
+		s.historyBytes -= pointSize(old)
+	} else {
+		s.historyPoints++
+	}
+	s.historyBytes += pointSize(p)
+
+	// Age limit for this vehicle.
+	if s.history.MaxAge > 0 {
+		cutoff := p.receivedAt.Add(-s.history.MaxAge)
+		for r.n > 1 && r.at(0).receivedAt.Before(cutoff) {
+			s.dropOldestLocked(p.loc.VehicleID, r)
+		}
+	}
+
+	// Store-wide limit: drop the globally oldest points first.
+	for s.history.MaxTotalPoints > 0 && s.historyPoints > s.history.MaxTotalPoints {
+		var oldest *ring
+		var oldestID string
+		for id, candidate := range s.trails {
+			if oldest == nil || candidate.at(0).receivedAt.Before(oldest.at(0).receivedAt) {
+				oldest, oldestID = candidate, id
+			}
+		}
+		if oldest == nil {
+			break
+		}
+		s.dropOldestLocked(oldestID, oldest)
+	}
+}
+
+// dropOldestLocked removes the oldest point of the vehicle's trail r and
+// updates the counters.  A trail left empty is removed.
+func (s *MemoryStore) dropOldestLocked(vehicleID string, r *ring) {
+	slots := len(r.buf)
+	p := r.popOldest()
+	s.historyPoints--
+	s.historyBytes -= pointSize(p) + int64(slots-len(r.buf))*slotSize
+	if r.n == 0 {
+		s.dropTrailLocked(vehicleID)
+	}
+}
+
+// dropTrailLocked removes a vehicle's whole trail and updates the
+// counters.
+func (s *MemoryStore) dropTrailLocked(vehicleID string) {
+	r, ok := s.trails[vehicleID]
+	if !ok {
+		return
+	}
+	for i := 0; i < r.n; i++ {
+		s.historyBytes -= pointSize(r.at(i))
+	}
+	s.historyPoints -= r.n
+	s.historyBytes -= int64(len(r.buf)) * slotSize
+	delete(s.trails, vehicleID)
+}
+
+// SetMatch records m as the match of the vehicle's fix with the client
+// timestamp ts, on its latest location and its history point.  A fix no
+// longer stored is left alone.  Matches are kept in memory only, and not
+// written to the write-ahead log.
+func (s *MemoryStore) SetMatch(vehicleID string, ts int64, m *model.Match) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if cur, ok := s.locations[vehicleID]; ok && cur.Timestamp == ts {
+		cur.Match = m
+		s.locations[vehicleID] = cur
+	}
+	if old, ok := s.trails[vehicleID].setMatch(ts, m); ok {
+		s.historyBytes += matchSize(m) - matchSize(old)
+	}
+}
+
+// Trail returns the recorded history of a vehicle, oldest first, limited
+// to points received within the configured MaxAge.  It returns nil for
+// vehicles without history.
+func (s *MemoryStore) Trail(vehicleID string) []model.Location {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	r, ok := s.trails[vehicleID]
+	if !ok || r.n == 0 {
+		return nil
+	}
+
+	var cutoff time.Time
+	if s.history.MaxAge > 0 {
+		cutoff = s.clock.Now().Add(-s.history.MaxAge)
+	}
+	result := make([]model.Location, 0, r.n)
+	for i := 0; i < r.n; i++ {
+		p := r.at(i)
+		if p.receivedAt.Before(cutoff) {
+			continue
+		}
+		result = append(result, p.loc)
+	}
+	return result
+}
@@ -0,0 +1,142 @@
+package store_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jaggu/vehicle-tracker-prototype/clock"
+	"github.com/jaggu/vehicle-tracker-prototype/model"
+	"github.com/jaggu/vehicle-tracker-prototype/store"
+)
+
+func TestHistory_TrailKeepsLastNPoints(t *testing.T) {
+	s := store.New(store.WithHistory(store.HistoryConfig{MaxPoints: 3, MaxAge: time.Hour}))
+	defer s.Close()
+
+	for i := 1; i <= 5; i++ {
+		s.UpdateLocation(model.Location{VehicleID: "bus-1", Latitude: float64(i), Longitude: 78.0, Timestamp: int64(i)})
+	}
+
+	trail := s.Trail("bus-1")
+	if len(trail) != 3 {
+		t.Fatalf("trail length = %d, want 3", len(trail))
+	}
+	for i, want := range []float64{3, 4, 5} {
+		if trail[i].Latitude != want {
+			t.Errorf("trail[%d].Latitude = %v, want %v (oldest first)", i, trail[i].Latitude, want)
+		}
+	}
+	if got := s.Trail("bus-unknown"); got != nil {
+		t.Errorf("trail of unknown vehicle = %v, want nil", got)
+	}
+}
+
+func TestHistory_TotalCapEvictsOldestAcrossVehicles(t *testing.T) {
+	clk := clock.NewFake(time.Date(2026, 7, 15, 8, 0, 0, 0, time.UTC))
+	s := store.New(
+		store.WithClock(clk),
+		store.WithHistory(store.HistoryConfig{MaxPoints: 10, MaxTotalPoints: 4}),
+	)
+	defer s.Close()
+
+	for i, id := range []string{"bus-1", "bus-1", "bus-2", "bus-2", "bus-2"} {
+		s.UpdateLocation(model.Location{VehicleID: id, Latitude: float64(i + 1), Longitude: 1})
+		clk.Advance(time.Second)
+	}
+
+	stats := s.Stats()
+	if stats.HistoryPoints != 4 {
+		t.Errorf("history points = %d, want 4 (capped)", stats.HistoryPoints)
+	}
+	if stats.HistoryBytes <= 0 {
+		t.Errorf("history bytes = %d, want > 0", stats.HistoryBytes)
+	}
+	if trail := s.Trail("bus-1"); len(trail) != 1 || trail[0].Latitude != 2 {
+		t.Errorf("bus-1 trail = %v, want only the point at latitude 2", trail)
+	}
+	if trail := s.Trail("bus-2"); len(trail) != 3 {
+		t.Errorf("bus-2 trail length = %d, want 3", len(trail))
+	}
+}
+
+func TestHistory_Disabled(t *testing.T) {
+	s := store.New(store.WithHistory(store.HistoryConfig{}))
+	s.UpdateLocation(model.Location{VehicleID: "bus-1", Latitude: 1, Longitude: 1})
+
+	if got := s.Trail("bus-1"); got != nil {
+		t.Errorf("trail with history disabled = %v, want nil", got)
+	}
+	if got := s.Stats().HistoryPoints; got != 0 {
+		t.Errorf("history points with history disabled = %d, want 0", got)
+	}
+}
+
+func TestHistory_MaxAgeDropsOldPoints(t *testing.T) {
+	clk := clock.NewFake(time.Date(2026, 7, 15, 8, 0, 0, 0, time.UTC))
+	s := store.New(
+		store.WithClock(clk),
+		store.WithHistory(store.HistoryConfig{MaxPoints: 100, MaxAge: 10 * time.Minute}),
+	)
+	defer s.Close()
+
+	s.UpdateLocation(model.Location{VehicleID: "bus-1", Latitude: 1, Longitude: 1})
+	clk.Advance(8 * time.Minute)
+	s.UpdateLocation(model.Location{VehicleID: "bus-1", Latitude: 2, Longitude: 1})
+	clk.Advance(4 * time.Minute)
+
+	trail := s.Trail("bus-1")
+	if len(trail) != 1 || trail[0].Latitude != 2 {
+		t.Errorf("trail = %v, want only the point received 4 minutes ago", trail)
+	}
+}
+
+func TestHistory_MemoryFollowsPointsHeld(t *testing.T) {
+	clk := clock.NewFake(time.Date(2026, 7, 15, 8, 0, 0, 0, time.UTC))
+	s := store.New(
+		store.WithClock(clk),
+		store.WithHistory(store.HistoryConfig{MaxPoints: 10_000, MaxTotalPoints: 2}),
+	)
+	defer s.Close()
+
+	s.UpdateLocation(model.Location{VehicleID: "bus-1", Latitude: 1, Longitude: 1})
+	clk.Advance(time.Second)
+	s.UpdateLocation(model.Location{VehicleID: "bus-2", Latitude: 1, Longitude: 1})
+	clk.Advance(time.Second)
+	two := s.Stats().HistoryBytes
+	if two <= 0 || two >= 10_000 {
+		t.Errorf("history bytes for two one-point trails = %d, want a small positive number", two)
+	}
+
+	// The cap empties bus-1's trail, which is then freed.
+	s.UpdateLocation(model.Location{VehicleID: "bus-3", Latitude: 1, Longitude: 1})
+	if got := s.Stats().HistoryBytes; got != two {
+		t.Errorf("history bytes after bus-1's trail was emptied = %d, want %d", got, two)
+	}
+	if got := s.Trail("bus-1"); got != nil {
+		t.Errorf("bus-1 trail = %v, want nil", got)
+	}
+}
+
+func TestHistory_SetMatchOnLatestAndTrail(t *testing.T) {
+	s := store.New(store.WithHistory(store.HistoryConfig{MaxPoints: 10, MaxAge: time.Hour}))
+	defer s.Close()
+
+	s.UpdateLocation(model.Location{VehicleID: "bus-1", Latitude: 1, Longitude: 78, Timestamp: 1})
+	s.UpdateLocation(model.Location{VehicleID: "bus-1", Latitude: 2, Longitude: 78, Timestamp: 2})
+	before := s.Stats().HistoryBytes
+
+	m := &model.Match{TripID: "5_0800_out", Snapped: true, SnappedLatitude: 2, SnappedLongitude: 78, Along: 556}
+	s.SetMatch("bus-1", 2, m)
+	s.SetMatch("bus-1", 3, &model.Match{TripID: "gone"}) // not stored
+
+	if got := s.GetAllLocations()[0].Match; got == nil || got.Along != 556 {
+		t.Errorf("latest location match = %+v, want along 556", got)
+	}
+	trail := s.Trail("bus-1")
+	if trail[0].Match != nil || trail[1].Match == nil || trail[1].Match.TripID != "5_0800_out" {
+		t.Errorf("trail matches = %+v, %+v; want only the second point matched", trail[0].Match, trail[1].Match)
+	}
+	if got := s.Stats().HistoryBytes; got <= before {
+		t.Errorf("history bytes with a match = %d, want more than %d", got, before)
+	}
+}
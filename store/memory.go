@@ -1,17 +1,23 @@
-// Package store provides in-memory storage for vehicle locations.
+// Package store provides storage for vehicle locations.
 //
 // Design decisions:
 //
-//	Uses a sync.RWMutex for safe concurrent access.
-//	Each vehicle's entry is overwritten on every update (latest-only).
+//	MemoryStore uses a sync.RWMutex for safe concurrent access.
+//	Each vehicle's entry is overwritten on every update (latest-only);
+//	a bounded ring buffer per vehicle keeps its recent trail.
 //	Supports staleness filtering for the GTFS-RT feed.
-//	No persistence — data is lost when the process exits.
+//	MemoryStore keeps nothing on disk by default; OpenWAL adds an
+//	append-only log plus periodic snapshots that are replayed on startup.
+//	SQLiteStore wraps a MemoryStore and additionally writes every point
+//	to a SQLite database, rebuilding the latest state on startup.
 package store
 
 import (
+	"log"
 	"sync"
 	"time"
 
+	"github.com/jaggu/vehicle-tracker-prototype/clock"
 	"github.com/jaggu/vehicle-tracker-prototype/model"
 )
 
@@ -24,23 +30,294 @@ type MemoryStore struct {
 	// stored, so we can apply staleness filtering independently of the
 	// client-supplied timestamp.
 	receivedAt map[string]time.Time
+
+	// trails holds each vehicle's recent points, bounded by history.
+	// historyPoints and historyBytes track the totals across vehicles.
+	trails        map[string]*ring
+	history       HistoryConfig
+	historyPoints int
+	historyBytes  int64
+
+	// retention controls the janitor that evicts long-dead vehicles;
+	// evictions counts the vehicles it has removed.
+	retention RetentionConfig
+	evictions int
+
+	// Reports rejected by UpdateLocation for carrying an older or the
+	// same timestamp as the stored point.
+	staleReports     int
+	duplicateReports int
+
+	// backfill controls detection of late-arriving points, which go into
+	// history only; the counters feed the status endpoint.
+	backfill           BackfillConfig
+	backfillPoints     int
+	backfillLatencySum time.Duration
+	backfillLatencyMax time.Duration
+
+	// outlier configures the GPS-jump filter; rejected reports are
+	// counted and kept in quarantine.
+	outlier        OutlierConfig
+	outlierReports int
+	quarantine     []QuarantinedPoint
+	jumped         map[string]QuarantinedPoint // last jump rejected, by vehicle ID
+
+	clock clock.Clock
+
+	// wal is the optional write-ahead log (nil for a purely in-memory
+	// store).  Background goroutines are stopped by closing stop.
+	wal       *wal
+	stop      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
 }
 
-// New creates and returns an empty MemoryStore.
-func New() *MemoryStore {
-	return &MemoryStore{
+// New creates and returns an empty MemoryStore.  Without options it keeps
+// history according to DefaultHistoryConfig, evicts vehicles according to
+// DefaultRetentionConfig and uses the real clock.
+//
+// Call Close to stop the eviction janitor.
+func New(opts ...Option) *MemoryStore {
+	s := &MemoryStore{
 		locations:  make(map[string]model.Location),
 		receivedAt: make(map[string]time.Time),
+		trails:     make(map[string]*ring),
+		jumped:     make(map[string]QuarantinedPoint),
+		history:    DefaultHistoryConfig,
+		retention:  DefaultRetentionConfig,
+		clock:      clock.Real,
+		stop:       make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.retention.Window > 0 {
+		s.every(s.retention.Interval, func() { s.EvictDead() })
+	}
+	return s
+}
+
+// OpenWAL creates a MemoryStore backed by an append-only log in cfg.Dir.
+//
+// The last snapshot and any later log segments are replayed before the
+// store is returned, so a crash loses at most what the sync policy allows.
+// Zero-valued config fields take the Default* values.  Call Close to
+// flush the log and write a final snapshot.
+func OpenWAL(cfg WALConfig, opts ...Option) (*MemoryStore, error) {
+	if cfg.SyncInterval <= 0 {
+		cfg.SyncInterval = DefaultSyncInterval
+	}
+	if cfg.MaxSegmentBytes <= 0 {
+		cfg.MaxSegmentBytes = DefaultMaxSegmentBytes
+	}
+	if cfg.SnapshotInterval <= 0 {
+		cfg.SnapshotInterval = DefaultSnapshotInterval
+	}
+
+	s := New(opts...)
+	w, err := openWAL(cfg, func(rec walRecord) {
+		res := Accepted
+		if rec.Backfill {
+			res = Backfilled
+		}
+		s.apply(rec.Location, time.Unix(0, rec.ReceivedAt), res)
+	})
+	if err != nil {
+		s.Close() // stop the janitor New started
+		return nil, err
+	}
+	s.wal = w
+
+	if cfg.Sync == SyncInterval {
+		s.every(cfg.SyncInterval, func() {
+			if err := w.sync(); err != nil {
+				log.Printf("wal: background sync: %v", err)
+			}
+		})
+	}
+	s.every(cfg.SnapshotInterval, func() {
+		if err := s.snapshot(); err != nil {
+			log.Printf("wal: snapshot: %v", err)
+		}
+	})
+	return s, nil
+}
+
+// every runs fn in a background goroutine once per interval until Close.
+func (s *MemoryStore) every(interval time.Duration, fn func()) {
+	// Create the ticker before starting the goroutine so that its
+	// schedule is anchored at the time of the call.
+	ticker := s.clock.NewTicker(interval)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C():
+				fn()
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// UpdateLocation stores the location if it is newer than the vehicle's
+// current one, or records it as backfill; see UpdateResult for the rules.
+// When a write-ahead log is configured an applied update is logged first,
+// and an error means it was not applied.
+func (s *MemoryStore) UpdateLocation(loc model.Location) (UpdateResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	res := s.classifyLocked(loc, now)
+	if res != Accepted && res != Backfilled {
+		return res, nil
+	}
+
+	if s.wal != nil {
+		rec := walRecord{Location: loc, ReceivedAt: now.UnixNano(), Backfill: res == Backfilled}
+		if err := s.wal.append(rec); err != nil {
+			return res, err
+		}
+	}
+	s.applyLocked(loc, now, res)
+	return res, nil
+}
+
+// classify decides what UpdateLocation would do with loc, counting it if
+// it is ignored.  Persistent stores call it before writing a point and
+// then call apply.
+func (s *MemoryStore) classify(loc model.Location, now time.Time) UpdateResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.classifyLocked(loc, now)
+}
+
+// classifyLocked applies the UpdateResult rules.  Reports without a
+// timestamp, or for a vehicle with no timestamped report yet, are
+// accepted unless flagged as backfill or rejected by the outlier filter.
+func (s *MemoryStore) classifyLocked(loc model.Location, now time.Time) UpdateResult {
+	cur, ok := s.locations[loc.VehicleID]
+	if loc.Backfill || s.backfill.MaxLiveAge > 0 && loc.Timestamp > 0 &&
+		time.Unix(loc.Timestamp, 0).Before(now.Add(-s.backfill.MaxLiveAge)) {
+		// A retried upload of a queued point is already in the trail.
+		if loc.Timestamp > 0 && (ok && cur.Timestamp == loc.Timestamp || s.trails[loc.VehicleID].hasFix(loc.Timestamp)) {
+			s.duplicateReports++
+			return IgnoredDuplicate
+		}
+		return Backfilled
+	}
+
+	if ok && loc.Timestamp > 0 && cur.Timestamp > 0 {
+		switch {
+		case loc.Timestamp < cur.Timestamp:
+			s.staleReports++
+			return IgnoredStale
+		case loc.Timestamp == cur.Timestamp:
+			s.duplicateReports++
+			return IgnoredDuplicate
+		}
+	}
+	if !s.checkOutlierLocked(loc, now) {
+		return RejectedOutlier
+	}
+	return Accepted
+}
+
+// countDuplicate counts a duplicate report that a persistent store
+// detected.
+func (s *MemoryStore) countDuplicate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.duplicateReports++
+}
+
+// apply records a location that classify accepted or marked as backfill.
+func (s *MemoryStore) apply(loc model.Location, receivedAt time.Time, res UpdateResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.applyLocked(loc, receivedAt, res)
+}
+
+func (s *MemoryStore) applyLocked(loc model.Location, receivedAt time.Time, res UpdateResult) {
+	switch res {
+	case Accepted:
+		s.putLocked(loc, receivedAt)
+	case Backfilled:
+		s.backfillLocked(loc, receivedAt)
 	}
 }
 
-// UpdateLocation stores (or overwrites) the latest location for a vehicle.
-func (s *MemoryStore) UpdateLocation(loc model.Location) {
+// put records loc as the latest location for its vehicle, received at the
+// given server time.  Persistent stores use it to restore state on startup.
+func (s *MemoryStore) put(loc model.Location, receivedAt time.Time) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.putLocked(loc, receivedAt)
+}
 
+func (s *MemoryStore) putLocked(loc model.Location, receivedAt time.Time) {
 	s.locations[loc.VehicleID] = loc
-	s.receivedAt[loc.VehicleID] = time.Now()
+	s.receivedAt[loc.VehicleID] = receivedAt
+	s.recordHistoryLocked(historyPoint{loc: loc, receivedAt: receivedAt})
+}
+
+// backfillLocked adds a late-arriving point to the vehicle's history
+// without touching its live position, and updates the backfill counters.
+// Latency is the gap between the client fix time and receipt.
+func (s *MemoryStore) backfillLocked(loc model.Location, receivedAt time.Time) {
+	s.recordHistoryLocked(historyPoint{loc: loc, receivedAt: receivedAt})
+
+	s.backfillPoints++
+	if loc.Timestamp > 0 {
+		latency := receivedAt.Sub(time.Unix(loc.Timestamp, 0))
+		s.backfillLatencySum += latency
+		s.backfillLatencyMax = max(s.backfillLatencyMax, latency)
+	}
+}
+
+// snapshot compacts the current maps into the WAL snapshot.  The active
+// segment is rotated while the maps are copied, so every later update
+// lands in a segment the snapshot doesn't cover.
+func (s *MemoryStore) snapshot() error {
+	s.mu.Lock()
+	snap := walSnapshot{Records: make([]walRecord, 0, len(s.locations))}
+	for id, loc := range s.locations {
+		loc.Match = nil // placed again as reports arrive
+		snap.Records = append(snap.Records, walRecord{Location: loc, ReceivedAt: s.receivedAt[id].UnixNano()})
+	}
+	next, err := s.wal.rotate()
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	snap.NextSegment = next
+	return s.wal.writeSnapshot(snap)
+}
+
+// Close stops background work (the eviction janitor and log syncing).
+// With a write-ahead log it also writes a final snapshot and closes the
+// log.  Close is safe to call more than once; only the first call does
+// anything.
+func (s *MemoryStore) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.stop)
+		s.wg.Wait()
+		if s.wal == nil {
+			return
+		}
+		if err = s.snapshot(); err != nil {
+			s.wal.close()
+			return
+		}
+		err = s.wal.close()
+	})
+	return err
 }
 
 // GetAllLocations returns a snapshot of all known vehicle locations.
@@ -62,7 +339,7 @@ func (s *MemoryStore) GetActiveLocations(threshold time.Duration) []model.Locati
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	cutoff := time.Now().Add(-threshold)
+	cutoff := s.clock.Now().Add(-threshold)
 	result := make([]model.Location, 0, len(s.locations))
 	for id, loc := range s.locations {
 		if s.receivedAt[id].After(cutoff) {
@@ -78,7 +355,7 @@ func (s *MemoryStore) ActiveVehicleCount(threshold time.Duration) int {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	cutoff := time.Now().Add(-threshold)
+	cutoff := s.clock.Now().Add(-threshold)
 	count := 0
 	for id := range s.locations {
 		if s.receivedAt[id].After(cutoff) {
@@ -95,3 +372,27 @@ func (s *MemoryStore) TotalVehicleCount() int {
 	defer s.mu.RUnlock()
 	return len(s.locations)
 }
+
+// Stats returns the store's operational counters.
+func (s *MemoryStore) Stats() Stats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var avgLatency time.Duration
+	if s.backfillPoints > 0 {
+		avgLatency = s.backfillLatencySum / time.Duration(s.backfillPoints)
+	}
+	return Stats{
+		HistoryPoints:      s.historyPoints,
+		HistoryBytes:       s.historyBytes,
+		HistoryMaxPoints:   s.history.MaxTotalPoints,
+		Evictions:          s.evictions,
+		StaleReports:       s.staleReports,
+		DuplicateReports:   s.duplicateReports,
+		BackfillPoints:     s.backfillPoints,
+		BackfillLatency:    avgLatency,
+		BackfillLatencyMax: s.backfillLatencyMax,
+		OutlierReports:     s.outlierReports,
+		QuarantinedPoints:  len(s.quarantine),
+	}
+}
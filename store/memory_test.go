@@ -4,6 +4,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/jaggu/vehicle-tracker-prototype/clock"
 	"github.com/jaggu/vehicle-tracker-prototype/model"
 	"github.com/jaggu/vehicle-tracker-prototype/store"
 )
@@ -45,20 +46,31 @@ func TestMemoryStore_OverwriteLocation(t *testing.T) {
 }
 
 func TestMemoryStore_ActiveLocations(t *testing.T) {
-	s := store.New()
+	clk := clock.NewFake(time.Date(2026, 7, 15, 8, 0, 0, 0, time.UTC))
+	s := store.New(store.WithClock(clk))
+	defer s.Close()
 
-	// This vehicle was just reported — it should be active
 	s.UpdateLocation(model.Location{VehicleID: "bus-fresh", Latitude: 17.3, Longitude: 78.4})
 
+	// Four minutes later the vehicle is still inside the 5-minute window
+	clk.Advance(4 * time.Minute)
 	active := s.GetActiveLocations(5 * time.Minute)
 	if len(active) != 1 {
-		t.Fatalf("expected 1 active location, got %d", len(active))
+		t.Fatalf("expected 1 active location after 4m, got %d", len(active))
 	}
 
-	// With a zero threshold, even a just-reported vehicle is stale
-	stale := s.GetActiveLocations(0)
-	if len(stale) != 0 {
-		t.Errorf("expected 0 locations with zero threshold, got %d", len(stale))
+	// Past the window it is stale and excluded
+	clk.Advance(90 * time.Second)
+	if stale := s.GetActiveLocations(5 * time.Minute); len(stale) != 0 {
+		t.Errorf("expected 0 active locations after 5m30s, got %d", len(stale))
+	}
+	if got := s.ActiveVehicleCount(5 * time.Minute); got != 0 {
+		t.Errorf("active vehicle count after 5m30s = %d, want 0", got)
+	}
+
+	// It is still known, just not active
+	if got := s.TotalVehicleCount(); got != 1 {
+		t.Errorf("total vehicle count = %d, want 1", got)
 	}
 }
 
@@ -104,3 +116,40 @@ func TestMemoryStore_ExpandedFields(t *testing.T) {
 		t.Errorf("speed = %f, want 8.5", all[0].Speed)
 	}
 }
+
+func TestMemoryStore_RejectsOutOfOrderAndDuplicates(t *testing.T) {
+	s := store.New()
+	defer s.Close()
+
+	if res, _ := s.UpdateLocation(model.Location{VehicleID: "bus-1", Latitude: 17.2, Longitude: 78.0, Timestamp: 1000}); res != store.Accepted {
+		t.Fatalf("first report: result = %v, want Accepted", res)
+	}
+
+	// A delayed retry carrying an older timestamp must not roll back.
+	res, _ := s.UpdateLocation(model.Location{VehicleID: "bus-1", Latitude: 17.1, Longitude: 78.0, Timestamp: 990})
+	if res != store.IgnoredStale {
+		t.Errorf("older report: result = %v, want IgnoredStale", res)
+	}
+
+	res, _ = s.UpdateLocation(model.Location{VehicleID: "bus-1", Latitude: 17.2, Longitude: 78.0, Timestamp: 1000})
+	if res != store.IgnoredDuplicate {
+		t.Errorf("repeated report: result = %v, want IgnoredDuplicate", res)
+	}
+
+	if all := s.GetAllLocations(); all[0].Timestamp != 1000 || all[0].Latitude != 17.2 {
+		t.Errorf("stored location = %+v, want the report at timestamp 1000", all[0])
+	}
+
+	res, _ = s.UpdateLocation(model.Location{VehicleID: "bus-1", Latitude: 17.3, Longitude: 78.0, Timestamp: 1010})
+	if res != store.Accepted {
+		t.Errorf("newer report: result = %v, want Accepted", res)
+	}
+
+	stats := s.Stats()
+	if stats.StaleReports != 1 || stats.DuplicateReports != 1 {
+		t.Errorf("stale/duplicate counters = %d/%d, want 1/1", stats.StaleReports, stats.DuplicateReports)
+	}
+	if got := len(s.Trail("bus-1")); got != 2 {
+		t.Errorf("trail length = %d, want 2 (ignored reports are not recorded)", got)
+	}
+}
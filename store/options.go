@@ -0,0 +1,45 @@
+package store
+
+import "github.com/jaggu/vehicle-tracker-prototype/clock"
+
+// Option configures a MemoryStore at construction time.  Options are
+// accepted by New, OpenWAL and OpenSQLite.
+type Option func(*MemoryStore)
+
+// WithHistory sets the limits of the per-vehicle location history.  A
+// MaxPoints of zero disables history entirely.
+func WithHistory(cfg HistoryConfig) Option {
+	return func(s *MemoryStore) {
+		s.history = cfg
+	}
+}
+
+// WithRetention configures the janitor that evicts vehicles which haven't
+// reported within cfg.Window.  A zero Window disables eviction.
+func WithRetention(cfg RetentionConfig) Option {
+	return func(s *MemoryStore) {
+		s.retention = cfg
+	}
+}
+
+// WithBackfill configures detection of late-arriving points.
+func WithBackfill(cfg BackfillConfig) Option {
+	return func(s *MemoryStore) {
+		s.backfill = cfg
+	}
+}
+
+// WithOutlierFilter enables rejection of GPS jumps; see OutlierConfig.
+func WithOutlierFilter(cfg OutlierConfig) Option {
+	return func(s *MemoryStore) {
+		s.outlier = cfg
+	}
+}
+
+// WithClock replaces the real clock, so tests can control staleness,
+// history age and eviction without sleeping.
+func WithClock(c clock.Clock) Option {
+	return func(s *MemoryStore) {
+		s.clock = c
+	}
+}
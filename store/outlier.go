@@ -0,0 +1,132 @@
+package store
+
+import (
+	"time"
+
+	"github.com/jaggu/vehicle-tracker-prototype/geo"
+	"github.com/jaggu/vehicle-tracker-prototype/model"
+)
+
+// OutlierConfig configures the filter that rejects GPS jumps before they
+// reach the live position.
+//
+// A report is rejected when its accuracy radius exceeds MaxAccuracy, or
+// when reaching it from the vehicle's stored point would need a speed
+// above MaxSpeed.  Zero disables the corresponding check.  A report
+// within MaxSpeed of the previous rejected jump is accepted all the same:
+// two fixes in a row that agree with each other outvote a stored point
+// that was itself bad, such as a first fix, which would otherwise freeze
+// the vehicle there.  Rejected
+// reports are kept in a quarantine of at most QuarantineSize entries,
+// newest last, for inspection.
+type OutlierConfig struct {
+	MaxSpeed       float64 // meters/second
+	MaxAccuracy    float32 // meters
+	QuarantineSize int
+}
+
+// DefaultQuarantineSize is used when OutlierConfig.QuarantineSize is zero.
+const DefaultQuarantineSize = 1000
+
+// minOutlierInterval is the shortest time span used to compute implied
+// speed, so that two fixes reported in the same second are not treated
+// as infinitely fast.
+const minOutlierInterval = time.Second
+
+// Reasons recorded with quarantined points.
+const (
+	ReasonImpliedSpeed = "implied_speed"
+	ReasonPoorAccuracy = "poor_accuracy"
+)
+
+// QuarantinedPoint is a report rejected by the outlier filter.
+type QuarantinedPoint struct {
+	Location   model.Location `json:"location"`
+	ReceivedAt time.Time      `json:"received_at"`
+	Reason     string         `json:"reason"`
+
+	// Distance and ImpliedSpeed describe the jump from the vehicle's
+	// stored point; they are zero for accuracy rejections.
+	Distance     float64 `json:"distance_m,omitempty"`
+	ImpliedSpeed float64 `json:"implied_speed_mps,omitempty"`
+}
+
+// checkOutlierLocked applies the outlier filter to a report that would
+// otherwise become the live position.  It quarantines and counts the
+// report and returns false if it is rejected.  s.mu must be held for
+// writing.
+func (s *MemoryStore) checkOutlierLocked(loc model.Location, now time.Time) bool {
+	cfg := s.outlier
+	if cfg.MaxAccuracy > 0 && loc.Accuracy > cfg.MaxAccuracy {
+		s.quarantineLocked(QuarantinedPoint{Location: loc, ReceivedAt: now, Reason: ReasonPoorAccuracy})
+		return false
+	}
+
+	cur, ok := s.locations[loc.VehicleID]
+	if cfg.MaxSpeed <= 0 || !ok {
+		return true
+	}
+
+	dist, speed := impliedSpeed(cur, s.receivedAt[loc.VehicleID], loc, now)
+	if speed <= cfg.MaxSpeed {
+		delete(s.jumped, loc.VehicleID)
+		return true
+	}
+	if prev, ok := s.jumped[loc.VehicleID]; ok {
+		if _, speed := impliedSpeed(prev.Location, prev.ReceivedAt, loc, now); speed <= cfg.MaxSpeed {
+			delete(s.jumped, loc.VehicleID)
+			return true
+		}
+	}
+	p := QuarantinedPoint{
+		Location:     loc,
+		ReceivedAt:   now,
+		Reason:       ReasonImpliedSpeed,
+		Distance:     dist,
+		ImpliedSpeed: speed,
+	}
+	s.jumped[loc.VehicleID] = p
+	s.quarantineLocked(p)
+	return false
+}
+
+// impliedSpeed returns the distance from fix a, received at aAt, to fix
+// b, received at bAt, and the speed needed to cover it.  Client fix times
+// are compared when both fixes carry one, otherwise the times the server
+// received them.
+func impliedSpeed(a model.Location, aAt time.Time, b model.Location, bAt time.Time) (dist, speed float64) {
+	var elapsed time.Duration
+	if a.Timestamp > 0 && b.Timestamp > 0 {
+		elapsed = time.Duration(b.Timestamp-a.Timestamp) * time.Second
+	} else {
+		elapsed = bAt.Sub(aAt)
+	}
+	elapsed = max(elapsed, minOutlierInterval)
+
+	dist = geo.Distance(a.Latitude, a.Longitude, b.Latitude, b.Longitude)
+	return dist, dist / elapsed.Seconds()
+}
+
+// quarantineLocked counts a rejected report and keeps it, dropping the
+// oldest quarantined report when full.
+func (s *MemoryStore) quarantineLocked(p QuarantinedPoint) {
+	s.outlierReports++
+
+	size := s.outlier.QuarantineSize
+	if size <= 0 {
+		size = DefaultQuarantineSize
+	}
+	if len(s.quarantine) >= size {
+		n := copy(s.quarantine, s.quarantine[len(s.quarantine)-size+1:])
+		s.quarantine = s.quarantine[:n]
+	}
+	s.quarantine = append(s.quarantine, p)
+}
+
+// Quarantine returns the reports rejected by the outlier filter, oldest
+// first.
+func (s *MemoryStore) Quarantine() []QuarantinedPoint {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]QuarantinedPoint(nil), s.quarantine...)
+}
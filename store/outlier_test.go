@@ -0,0 +1,109 @@
+package store_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jaggu/vehicle-tracker-prototype/clock"
+	"github.com/jaggu/vehicle-tracker-prototype/model"
+	"github.com/jaggu/vehicle-tracker-prototype/store"
+)
+
+func TestOutlierFilter_RejectsJumpsAndPoorAccuracy(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1752566400, 0))
+	s := store.New(
+		store.WithClock(clk),
+		store.WithOutlierFilter(store.OutlierConfig{MaxSpeed: 40, MaxAccuracy: 100, QuarantineSize: 2}),
+	)
+	defer s.Close()
+
+	base := model.Location{VehicleID: "bus-1", Latitude: 17.3850, Longitude: 78.4867, Accuracy: 10, Timestamp: 1752566400}
+	if res, _ := s.UpdateLocation(base); res != store.Accepted {
+		t.Fatalf("first fix: result = %v, want Accepted", res)
+	}
+
+	// ~110 m in 10 s (11 m/s): plausible.
+	next := base
+	next.Latitude, next.Timestamp = 17.3860, 1752566410
+	if res, _ := s.UpdateLocation(next); res != store.Accepted {
+		t.Fatalf("plausible move: result = %v, want Accepted", res)
+	}
+
+	// ~1.1 km in 10 s (110 m/s): a GPS jump.
+	jump := next
+	jump.Latitude, jump.Timestamp = 17.3960, 1752566420
+	if res, _ := s.UpdateLocation(jump); res != store.RejectedOutlier {
+		t.Fatalf("jump: result = %v, want RejectedOutlier", res)
+	}
+
+	// Accuracy radius beyond the limit, even without moving.
+	fuzzy := next
+	fuzzy.Accuracy, fuzzy.Timestamp = 250, 1752566430
+	if res, _ := s.UpdateLocation(fuzzy); res != store.RejectedOutlier {
+		t.Fatalf("poor accuracy: result = %v, want RejectedOutlier", res)
+	}
+
+	if live := s.GetAllLocations(); len(live) != 1 || live[0].Latitude != 17.3860 {
+		t.Errorf("live location = %v, want the last plausible fix", live)
+	}
+
+	q := s.Quarantine()
+	if len(q) != 2 {
+		t.Fatalf("quarantine length = %d, want 2", len(q))
+	}
+	if q[0].Reason != store.ReasonImpliedSpeed || q[0].ImpliedSpeed < 100 {
+		t.Errorf("quarantine[0] = %+v, want an implied-speed rejection above 100 m/s", q[0])
+	}
+	if q[1].Reason != store.ReasonPoorAccuracy {
+		t.Errorf("quarantine[1].Reason = %q, want %q", q[1].Reason, store.ReasonPoorAccuracy)
+	}
+
+	// The quarantine is bounded; the oldest entry goes first.
+	fuzzy.Timestamp = 1752566440
+	s.UpdateLocation(fuzzy)
+	if q := s.Quarantine(); len(q) != 2 || q[0].Reason != store.ReasonPoorAccuracy {
+		t.Errorf("quarantine after overflow = %+v, want the two accuracy rejections", q)
+	}
+	if got := s.Stats().OutlierReports; got != 3 {
+		t.Errorf("OutlierReports = %d, want 3", got)
+	}
+}
+
+func TestOutlierFilter_RecoversFromBadStoredPoint(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1752566400, 0))
+	s := store.New(
+		store.WithClock(clk),
+		store.WithOutlierFilter(store.OutlierConfig{MaxSpeed: 40, MaxAccuracy: 100}),
+	)
+	defer s.Close()
+
+	// The first fix is itself a jump, ~11 km from where the bus really is,
+	// and there is nothing to check it against.
+	bad := model.Location{VehicleID: "bus-1", Latitude: 17.4850, Longitude: 78.4867, Accuracy: 10, Timestamp: 1752566400}
+	if res, _ := s.UpdateLocation(bad); res != store.Accepted {
+		t.Fatalf("first fix: result = %v, want Accepted", res)
+	}
+
+	// The first good fix is implausible from the bad one.
+	good := bad
+	good.Latitude, good.Timestamp = 17.3850, 1752566410
+	if res, _ := s.UpdateLocation(good); res != store.RejectedOutlier {
+		t.Fatalf("first good fix: result = %v, want RejectedOutlier", res)
+	}
+
+	// A second that agrees with it outvotes the stored point.
+	good.Latitude, good.Timestamp = 17.3860, 1752566420
+	if res, _ := s.UpdateLocation(good); res != store.Accepted {
+		t.Fatalf("second good fix: result = %v, want Accepted", res)
+	}
+	if live := s.GetAllLocations(); len(live) != 1 || live[0].Latitude != 17.3860 {
+		t.Errorf("live location = %v, want the second good fix", live)
+	}
+
+	// Later fixes are checked against the new stored point again.
+	jump := good
+	jump.Latitude, jump.Timestamp = 17.3960, 1752566430
+	if res, _ := s.UpdateLocation(jump); res != store.RejectedOutlier {
+		t.Errorf("later jump: result = %v, want RejectedOutlier", res)
+	}
+}
@@ -0,0 +1,282 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jaggu/vehicle-tracker-prototype/model"
+
+	_ "modernc.org/sqlite" // pure-Go SQLite driver, registers "sqlite"
+)
+
+// migrations holds the schema changes applied by OpenSQLite, in order.
+// The database's PRAGMA user_version records how many have been applied,
+// so new schema changes must be appended, never edited in place.
+//
+// The tables follow the schema in README_by_mentor.md (Milestone 1).
+var migrations = []string{
+	`CREATE TABLE vehicles (
+		id        TEXT PRIMARY KEY,
+		label     TEXT NOT NULL DEFAULT '',
+		agency_id TEXT NOT NULL DEFAULT '',
+		active    INTEGER NOT NULL DEFAULT 1
+	);
+	CREATE TABLE drivers (
+		id         TEXT PRIMARY KEY,
+		name       TEXT NOT NULL,
+		phone      TEXT NOT NULL UNIQUE,
+		pin_hash   TEXT NOT NULL,
+		vehicle_id TEXT REFERENCES vehicles(id)
+	);
+	CREATE TABLE trips (
+		id         INTEGER PRIMARY KEY AUTOINCREMENT,
+		vehicle_id TEXT NOT NULL REFERENCES vehicles(id),
+		route_id   TEXT NOT NULL DEFAULT '',
+		trip_id    TEXT NOT NULL DEFAULT '',
+		start_time INTEGER NOT NULL,
+		end_time   INTEGER,
+		status     TEXT NOT NULL
+	);
+	CREATE TABLE location_points (
+		id          INTEGER PRIMARY KEY AUTOINCREMENT,
+		vehicle_id  TEXT NOT NULL REFERENCES vehicles(id),
+		trip_id     TEXT NOT NULL DEFAULT '',
+		route_id    TEXT NOT NULL DEFAULT '',
+		lat         REAL NOT NULL,
+		lon         REAL NOT NULL,
+		bearing     REAL NOT NULL DEFAULT 0,
+		speed       REAL NOT NULL DEFAULT 0,
+		accuracy    REAL NOT NULL DEFAULT 0,
+		timestamp   INTEGER NOT NULL DEFAULT 0,
+		received_at INTEGER NOT NULL
+	);
+	CREATE INDEX idx_location_points_vehicle ON location_points(vehicle_id, id);`,
+
+	// Backfilled points are history only and must not be restored as a
+	// vehicle's live position.
+	`ALTER TABLE location_points ADD COLUMN backfill INTEGER NOT NULL DEFAULT 0;`,
+
+	// Retried backfill uploads are found by vehicle and client timestamp.
+	`CREATE INDEX idx_location_points_vehicle_timestamp ON location_points(vehicle_id, timestamp);`,
+}
+
+// SQLiteStore persists every accepted location to a SQLite database and
+// keeps the latest position per vehicle in an embedded MemoryStore, which
+// serves all reads.  On startup the in-memory state is rebuilt from the
+// most recent point of each vehicle.
+//
+// received_at is stored as Unix milliseconds.
+type SQLiteStore struct {
+	mu  sync.Mutex
+	mem *MemoryStore
+	db  *sql.DB
+}
+
+// OpenSQLite opens (or creates) the SQLite database at path, applies any
+// pending schema migrations, and loads the latest location of every
+// vehicle into memory.
+func OpenSQLite(path string, opts ...Option) (*SQLiteStore, error) {
+	dsn := fmt.Sprintf("file:%s?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)", path)
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open sqlite %s: %w", path, err)
+	}
+
+	// SQLite allows a single writer; serialising access through one
+	// connection avoids SQLITE_BUSY errors under concurrent requests.
+	db.SetMaxOpenConns(1)
+
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	s := &SQLiteStore{mem: New(opts...), db: db}
+	if err := s.load(); err != nil {
+		s.mem.Close()
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// migrate applies the migrations the database hasn't seen yet.
+func migrate(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return fmt.Errorf("read schema version: %w", err)
+	}
+
+	for i := version; i < len(migrations); i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		// PRAGMA does not accept bound parameters.
+		if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, i+1)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// load rebuilds the in-memory latest state from the newest live (not
+// backfilled) point of each vehicle.
+func (s *SQLiteStore) load() error {
+	rows, err := s.db.Query(`
+		SELECT vehicle_id, trip_id, route_id, lat, lon, bearing, speed,
+		       accuracy, timestamp, received_at
+		FROM location_points
+		WHERE id IN (SELECT MAX(id) FROM location_points
+		             WHERE backfill = 0 GROUP BY vehicle_id)`)
+	if err != nil {
+		return fmt.Errorf("load latest locations: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var loc model.Location
+		var receivedAt int64
+		if err := rows.Scan(&loc.VehicleID, &loc.TripID, &loc.RouteID,
+			&loc.Latitude, &loc.Longitude, &loc.Bearing, &loc.Speed,
+			&loc.Accuracy, &loc.Timestamp, &receivedAt); err != nil {
+			return fmt.Errorf("load latest locations: %w", err)
+		}
+		s.mem.put(loc, time.UnixMilli(receivedAt))
+	}
+	return rows.Err()
+}
+
+// UpdateLocation writes an accepted or backfilled location to
+// location_points and, once it is durably recorded, applies it in memory.
+// Stale and duplicate reports are neither written nor applied.  Vehicles
+// are registered in the vehicles table on first report.
+func (s *SQLiteStore) UpdateLocation(loc model.Location) (UpdateResult, error) {
+	// Serialise updates so that no other report for the vehicle lands
+	// between the timestamp check and the in-memory update.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.mem.clock.Now()
+	res := s.mem.classify(loc, now)
+	if res != Accepted && res != Backfilled {
+		return res, nil
+	}
+	if res == Backfilled && loc.Timestamp > 0 {
+		// The point may have left the in-memory trail but still be in
+		// the database from an earlier upload.
+		dup, err := s.hasPoint(loc.VehicleID, loc.Timestamp)
+		if err != nil {
+			return res, err
+		}
+		if dup {
+			s.mem.countDuplicate()
+			return IgnoredDuplicate, nil
+		}
+	}
+	if err := s.insert(loc, now, res == Backfilled); err != nil {
+		return res, err
+	}
+	s.mem.apply(loc, now, res)
+	return res, nil
+}
+
+// hasPoint reports whether location_points holds a point of the vehicle
+// with the client timestamp ts.
+func (s *SQLiteStore) hasPoint(vehicleID string, ts int64) (bool, error) {
+	var found bool
+	err := s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM location_points WHERE vehicle_id = ? AND timestamp = ?)`,
+		vehicleID, ts).Scan(&found)
+	if err != nil {
+		return false, fmt.Errorf("look up location: %w", err)
+	}
+	return found, nil
+}
+
+// insert writes loc to location_points.
+func (s *SQLiteStore) insert(loc model.Location, receivedAt time.Time, backfill bool) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("insert location: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`INSERT OR IGNORE INTO vehicles (id, label) VALUES (?, ?)`,
+		loc.VehicleID, loc.VehicleID); err != nil {
+		return fmt.Errorf("insert vehicle: %w", err)
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO location_points (vehicle_id, trip_id, route_id, lat, lon,
+		                             bearing, speed, accuracy, timestamp, received_at,
+		                             backfill)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		loc.VehicleID, loc.TripID, loc.RouteID, loc.Latitude, loc.Longitude,
+		loc.Bearing, loc.Speed, loc.Accuracy, loc.Timestamp, receivedAt.UnixMilli(),
+		backfill); err != nil {
+		return fmt.Errorf("insert location: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("insert location: %w", err)
+	}
+	return nil
+}
+
+// GetAllLocations returns a snapshot of all known vehicle locations.
+func (s *SQLiteStore) GetAllLocations() []model.Location {
+	return s.mem.GetAllLocations()
+}
+
+// GetActiveLocations returns locations received within the staleness window.
+func (s *SQLiteStore) GetActiveLocations(threshold time.Duration) []model.Location {
+	return s.mem.GetActiveLocations(threshold)
+}
+
+// ActiveVehicleCount returns the number of vehicles that have reported
+// within the given staleness window.
+func (s *SQLiteStore) ActiveVehicleCount(threshold time.Duration) int {
+	return s.mem.ActiveVehicleCount(threshold)
+}
+
+// TotalVehicleCount returns the total number of vehicles that have ever
+// reported a location.
+func (s *SQLiteStore) TotalVehicleCount() int {
+	return s.mem.TotalVehicleCount()
+}
+
+// Trail returns the recent in-memory history of a vehicle.
+func (s *SQLiteStore) Trail(vehicleID string) []model.Location {
+	return s.mem.Trail(vehicleID)
+}
+
+// SetMatch records the match of a fix in memory.  Matches are not
+// written to the database.
+func (s *SQLiteStore) SetMatch(vehicleID string, ts int64, m *model.Match) {
+	s.mem.SetMatch(vehicleID, ts, m)
+}
+
+// Stats returns the store's operational counters.
+func (s *SQLiteStore) Stats() Stats {
+	return s.mem.Stats()
+}
+
+// Quarantine returns the reports rejected by the outlier filter.  They are
+// kept in memory only.
+func (s *SQLiteStore) Quarantine() []QuarantinedPoint {
+	return s.mem.Quarantine()
+}
+
+// Close stops the in-memory store and closes the underlying database.
+func (s *SQLiteStore) Close() error {
+	s.mem.Close()
+	return s.db.Close()
+}
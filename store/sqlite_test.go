@@ -0,0 +1,98 @@
+package store_test
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jaggu/vehicle-tracker-prototype/model"
+	"github.com/jaggu/vehicle-tracker-prototype/store"
+)
+
+func TestSQLiteStore_PersistsHistoryAndRestoresLatest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tracker.db")
+
+	s, err := store.OpenSQLite(path)
+	if err != nil {
+		t.Fatalf("OpenSQLite: %v", err)
+	}
+	s.UpdateLocation(model.Location{VehicleID: "bus-1", Latitude: 17.0, Longitude: 78.0, Timestamp: 1000})
+	s.UpdateLocation(model.Location{VehicleID: "bus-1", Latitude: 18.0, Longitude: 79.0, Timestamp: 1010})
+	s.UpdateLocation(model.Location{VehicleID: "bus-2", TripID: "t1", RouteID: "5", Latitude: 17.1, Longitude: 78.1, Timestamp: 1005})
+	// Out of order: neither applied nor written to location_points.
+	if res, _ := s.UpdateLocation(model.Location{VehicleID: "bus-1", Latitude: 16.0, Longitude: 77.0, Timestamp: 990}); res != store.IgnoredStale {
+		t.Errorf("older report: result = %v, want IgnoredStale", res)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// Reopening must rebuild the latest position of every vehicle.
+	s, err = store.OpenSQLite(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer s.Close()
+
+	if got := s.TotalVehicleCount(); got != 2 {
+		t.Fatalf("total vehicle count after reopen = %d, want 2", got)
+	}
+	byID := make(map[string]model.Location)
+	for _, loc := range s.GetAllLocations() {
+		byID[loc.VehicleID] = loc
+	}
+	if got := byID["bus-1"].Latitude; got != 18.0 {
+		t.Errorf("bus-1 latitude = %f, want 18.0 (latest point)", got)
+	}
+	if got := byID["bus-2"].TripID; got != "t1" {
+		t.Errorf("bus-2 trip_id = %q, want %q", got, "t1")
+	}
+	if got := s.ActiveVehicleCount(5 * time.Minute); got != 2 {
+		t.Errorf("active vehicle count after reopen = %d, want 2", got)
+	}
+
+	// Every accepted point is kept in location_points.
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	defer db.Close()
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM location_points`).Scan(&n); err != nil {
+		t.Fatalf("count location_points: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("location_points rows = %d, want 3", n)
+	}
+}
+
+func TestSQLiteStore_BackfillIsNotRestoredAsLive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tracker.db")
+
+	s, err := store.OpenSQLite(path)
+	if err != nil {
+		t.Fatalf("OpenSQLite: %v", err)
+	}
+	s.UpdateLocation(model.Location{VehicleID: "bus-1", Latitude: 17.0, Longitude: 78.0, Timestamp: 1000})
+	if res, _ := s.UpdateLocation(model.Location{VehicleID: "bus-1", Latitude: 16.0, Longitude: 77.0, Timestamp: 2000, Backfill: true}); res != store.Backfilled {
+		t.Fatalf("flagged point: result = %v, want Backfilled", res)
+	}
+	s.Close()
+
+	s, err = store.OpenSQLite(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer s.Close()
+
+	if all := s.GetAllLocations(); len(all) != 1 || all[0].Latitude != 17.0 {
+		t.Errorf("restored locations = %v, want the live point at latitude 17.0", all)
+	}
+
+	// A retried upload is recognised from the database, though the point
+	// is no longer in memory.
+	if res, _ := s.UpdateLocation(model.Location{VehicleID: "bus-1", Latitude: 16.0, Longitude: 77.0, Timestamp: 2000, Backfill: true}); res != store.IgnoredDuplicate {
+		t.Errorf("retried backfill: result = %v, want IgnoredDuplicate", res)
+	}
+}
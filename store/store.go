@@ -0,0 +1,141 @@
+package store
+
+import (
+	"time"
+
+	"github.com/jaggu/vehicle-tracker-prototype/model"
+)
+
+// Store is the set of operations the HTTP handlers and the server need
+// from a location store.  MemoryStore is the default implementation;
+// anything else (a database-backed store, a test double) only has to
+// satisfy this interface to be plugged into server.Run.
+type Store interface {
+	// UpdateLocation stores the latest location for a vehicle unless it
+	// is older than, or a duplicate of, the one already stored.  An error
+	// means the location could not be recorded.
+	UpdateLocation(loc model.Location) (UpdateResult, error)
+
+	// GetAllLocations returns a snapshot of all known vehicle locations.
+	GetAllLocations() []model.Location
+
+	// GetActiveLocations returns locations received within the given
+	// staleness window.
+	GetActiveLocations(threshold time.Duration) []model.Location
+
+	// ActiveVehicleCount returns the number of vehicles that have reported
+	// within the given staleness window.
+	ActiveVehicleCount(threshold time.Duration) int
+
+	// TotalVehicleCount returns the total number of vehicles that have ever
+	// reported a location.
+	TotalVehicleCount() int
+
+	// Trail returns the recent location history of a vehicle, oldest
+	// first, or nil if there is none.
+	Trail(vehicleID string) []model.Location
+
+	// SetMatch records where the vehicle's fix with the client
+	// timestamp ts was placed on its trip, once UpdateLocation has
+	// accepted it.  It does nothing if the fix is no longer stored.
+	SetMatch(vehicleID string, ts int64, m *model.Match)
+
+	// Stats returns operational counters for the status endpoint.
+	Stats() Stats
+
+	// Quarantine returns the reports rejected by the outlier filter,
+	// oldest first.
+	Quarantine() []QuarantinedPoint
+}
+
+// Stats holds store counters reported by GET /api/v1/status.
+type Stats struct {
+	// HistoryPoints is the number of points held across all vehicle
+	// trails, HistoryBytes their estimated memory use, including room
+	// allocated for points not yet recorded, and HistoryMaxPoints the
+	// configured cap (0 means unbounded).
+	HistoryPoints    int
+	HistoryBytes     int64
+	HistoryMaxPoints int
+
+	// Evictions is the number of vehicles removed by the janitor after
+	// exceeding the retention window.
+	Evictions int
+
+	// StaleReports and DuplicateReports count updates ignored for
+	// carrying an older or the same timestamp as the stored point.
+	StaleReports     int
+	DuplicateReports int
+
+	// BackfillPoints counts late-arriving points recorded in history only.
+	// BackfillLatency is their average and BackfillLatencyMax their
+	// largest delay between client fix time and receipt.
+	BackfillPoints     int
+	BackfillLatency    time.Duration
+	BackfillLatencyMax time.Duration
+
+	// OutlierReports counts reports rejected by the outlier filter;
+	// QuarantinedPoints is how many of them are still kept.
+	OutlierReports    int
+	QuarantinedPoints int
+}
+
+// UpdateResult describes what UpdateLocation did with a report.
+//
+// A report replaces the stored point only if its client Timestamp is newer.
+// This keeps delayed HTTP retries from rolling a vehicle backwards.
+// Reports without a timestamp are always accepted, as there is nothing
+// to compare.
+//
+// Reports flagged as backfill, or whose timestamp is older than
+// BackfillConfig.MaxLiveAge, are late-arriving points from a device that
+// was offline.  They are added to history (and persisted) but never
+// replace the live position shown in the GTFS-RT feed.  A backfill point
+// the vehicle already has a point for at the same timestamp, as from a
+// retried upload, is IgnoredDuplicate.
+//
+// Reports that would become the live position are also checked against
+// the OutlierConfig, and GPS jumps are quarantined instead of stored.
+type UpdateResult int
+
+const (
+	// Accepted means the report is now the vehicle's latest location.
+	Accepted UpdateResult = iota
+
+	// IgnoredStale means the report is older than the stored point.
+	IgnoredStale
+
+	// IgnoredDuplicate means the report has the same timestamp as the
+	// stored point.
+	IgnoredDuplicate
+
+	// Backfilled means the report was recorded in history only.
+	Backfilled
+
+	// RejectedOutlier means the report looked like a GPS jump and was
+	// quarantined.
+	RejectedOutlier
+)
+
+// String returns the status value reported to clients.
+func (r UpdateResult) String() string {
+	switch r {
+	case Accepted:
+		return "ok"
+	case IgnoredStale:
+		return "ignored_stale"
+	case IgnoredDuplicate:
+		return "ignored_duplicate"
+	case Backfilled:
+		return "backfilled"
+	case RejectedOutlier:
+		return "rejected_outlier"
+	}
+	return "unknown"
+}
+
+// Compile-time checks that the implementations satisfy Store.
+var (
+	_ Store = (*MemoryStore)(nil)
+	_ Store = (*SQLiteStore)(nil)
+)
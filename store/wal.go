@@ -0,0 +1,379 @@
+package store
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jaggu/vehicle-tracker-prototype/model"
+)
+
+// SyncPolicy controls when the write-ahead log is flushed to stable storage.
+type SyncPolicy int
+
+const (
+	// SyncAlways fsyncs after every record.  Nothing acknowledged to a
+	// client is lost on a crash, at the cost of one fsync per report.
+	SyncAlways SyncPolicy = iota
+
+	// SyncInterval fsyncs in the background every WALConfig.SyncInterval.
+	// A crash can lose at most that much acknowledged data.
+	SyncInterval
+
+	// SyncNever leaves flushing to the operating system.
+	SyncNever
+)
+
+// Defaults applied by OpenWAL to zero-valued WALConfig fields.
+const (
+	DefaultSyncInterval     = time.Second
+	DefaultMaxSegmentBytes  = 64 << 20
+	DefaultSnapshotInterval = 5 * time.Minute
+)
+
+// WALConfig configures the on-disk write-ahead log used by OpenWAL.
+type WALConfig struct {
+	// Dir holds the log segments and the snapshot.  It is created if missing.
+	Dir string
+
+	// Sync is the fsync policy; SyncInterval uses SyncInterval below.
+	Sync         SyncPolicy
+	SyncInterval time.Duration
+
+	// MaxSegmentBytes is the size at which the active log segment is
+	// closed and a new one started.
+	MaxSegmentBytes int64
+
+	// SnapshotInterval is how often the locations/receivedAt maps are
+	// compacted into a snapshot, after which older segments are deleted.
+	SnapshotInterval time.Duration
+}
+
+const (
+	segmentPrefix = "wal-"
+	segmentSuffix = ".log"
+	snapshotName  = "snapshot.json"
+
+	// recordHeaderSize is the length (uint32) plus CRC-32C (uint32)
+	// that precede every record payload.
+	recordHeaderSize = 8
+
+	// maxRecordSize bounds a single payload; anything larger is treated
+	// as corruption rather than an allocation request.
+	maxRecordSize = 1 << 20
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// walRecord is the payload of one log record: an UpdateLocation call that
+// was applied either as the live position or as backfill.
+type walRecord struct {
+	Location   model.Location `json:"loc"`
+	ReceivedAt int64          `json:"received_at"` // Unix nanoseconds
+	Backfill   bool           `json:"backfill,omitempty"`
+}
+
+// walSnapshot is the compacted state written by snapshot().  Every
+// segment numbered below NextSegment is already reflected in Records.
+type walSnapshot struct {
+	NextSegment uint64      `json:"next_segment"`
+	Records     []walRecord `json:"records"`
+}
+
+// wal is an append-only log split into numbered segments.
+//
+// Each record is framed as:
+//
+//	uint32 little-endian payload length
+//	uint32 little-endian CRC-32C of the payload
+//	payload (JSON-encoded walRecord)
+//
+// A crash can leave a partially written record at the end of the active
+// segment.  readSegment stops at the first record whose frame is short or
+// whose checksum doesn't match, and openWAL truncates that torn tail
+// before appending.
+type wal struct {
+	cfg WALConfig
+
+	mu      sync.Mutex
+	file    *os.File
+	segment uint64
+	size    int64
+	dirty   bool
+}
+
+// segmentPath returns the file name of segment n.
+func (w *wal) segmentPath(n uint64) string {
+	return filepath.Join(w.cfg.Dir, fmt.Sprintf("%s%020d%s", segmentPrefix, n, segmentSuffix))
+}
+
+// listSegments returns the segment numbers present in dir, ascending.
+func listSegments(dir string) ([]uint64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var segs []uint64
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasPrefix(name, segmentPrefix) || !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		var n uint64
+		if _, err := fmt.Sscanf(strings.TrimSuffix(strings.TrimPrefix(name, segmentPrefix), segmentSuffix), "%d", &n); err != nil {
+			continue
+		}
+		segs = append(segs, n)
+	}
+	sort.Slice(segs, func(i, j int) bool { return segs[i] < segs[j] })
+	return segs, nil
+}
+
+// readSegment calls apply for every intact record in the segment file and
+// returns the offset just past the last intact record.  Reading stops at
+// the first torn or corrupt record; the bytes after it are skipped.
+func readSegment(path string, apply func(walRecord)) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var offset int64
+	header := make([]byte, recordHeaderSize)
+	for {
+		if _, err := io.ReadFull(f, header); err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.Printf("wal: %s: torn record header at offset %d, skipping tail", path, offset)
+			}
+			return offset, nil
+		}
+		length := binary.LittleEndian.Uint32(header[0:4])
+		sum := binary.LittleEndian.Uint32(header[4:8])
+		if length > maxRecordSize {
+			log.Printf("wal: %s: implausible record length %d at offset %d, skipping tail", path, length, offset)
+			return offset, nil
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(f, payload); err != nil {
+			log.Printf("wal: %s: torn record at offset %d, skipping tail", path, offset)
+			return offset, nil
+		}
+		if crc32.Checksum(payload, crcTable) != sum {
+			log.Printf("wal: %s: checksum mismatch at offset %d, skipping tail", path, offset)
+			return offset, nil
+		}
+		var rec walRecord
+		if err := json.Unmarshal(payload, &rec); err != nil {
+			log.Printf("wal: %s: undecodable record at offset %d, skipping tail", path, offset)
+			return offset, nil
+		}
+		apply(rec)
+		offset += recordHeaderSize + int64(length)
+	}
+}
+
+// openWAL loads the snapshot and replays every later segment through
+// apply, then opens the newest segment for appending.
+func openWAL(cfg WALConfig, apply func(walRecord)) (*wal, error) {
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("wal: create dir: %w", err)
+	}
+	w := &wal{cfg: cfg}
+
+	var first uint64
+	data, err := os.ReadFile(filepath.Join(cfg.Dir, snapshotName))
+	switch {
+	case err == nil:
+		var snap walSnapshot
+		if err := json.Unmarshal(data, &snap); err != nil {
+			return nil, fmt.Errorf("wal: decode snapshot: %w", err)
+		}
+		for _, rec := range snap.Records {
+			apply(rec)
+		}
+		first = snap.NextSegment
+	case !errors.Is(err, os.ErrNotExist):
+		return nil, fmt.Errorf("wal: read snapshot: %w", err)
+	}
+
+	segs, err := listSegments(cfg.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("wal: list segments: %w", err)
+	}
+
+	var lastGood int64
+	w.segment = first
+	for _, n := range segs {
+		if n < first {
+			// Already covered by the snapshot; left over from a crash
+			// between writing the snapshot and deleting old segments.
+			os.Remove(w.segmentPath(n))
+			continue
+		}
+		end, err := readSegment(w.segmentPath(n), apply)
+		if err != nil {
+			return nil, fmt.Errorf("wal: replay segment %d: %w", n, err)
+		}
+		w.segment, lastGood = n, end
+	}
+
+	f, err := os.OpenFile(w.segmentPath(w.segment), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("wal: open segment: %w", err)
+	}
+	// Drop any torn tail so new records follow the last intact one.
+	if err := f.Truncate(lastGood); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("wal: truncate torn tail: %w", err)
+	}
+	if _, err := f.Seek(lastGood, io.SeekStart); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("wal: seek: %w", err)
+	}
+	w.file, w.size = f, lastGood
+	return w, nil
+}
+
+// append writes one record, rotating to a new segment first if the
+// active one is full, and fsyncs when the policy is SyncAlways.
+func (w *wal) append(rec walRecord) error {
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("wal: encode record: %w", err)
+	}
+	buf := make([]byte, recordHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(buf[4:8], crc32.Checksum(payload, crcTable))
+	copy(buf[recordHeaderSize:], payload)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.size > 0 && w.size+int64(len(buf)) > w.cfg.MaxSegmentBytes {
+		if err := w.rotateLocked(); err != nil {
+			return err
+		}
+	}
+	if _, err := w.file.Write(buf); err != nil {
+		return fmt.Errorf("wal: write: %w", err)
+	}
+	w.size += int64(len(buf))
+	w.dirty = true
+
+	if w.cfg.Sync == SyncAlways {
+		return w.syncLocked()
+	}
+	return nil
+}
+
+// rotate closes the active segment and starts the next one, returning
+// the new segment number.
+func (w *wal) rotate() (uint64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.rotateLocked(); err != nil {
+		return 0, err
+	}
+	return w.segment, nil
+}
+
+func (w *wal) rotateLocked() error {
+	if err := w.syncLocked(); err != nil {
+		return err
+	}
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("wal: close segment: %w", err)
+	}
+	f, err := os.OpenFile(w.segmentPath(w.segment+1), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("wal: open segment: %w", err)
+	}
+	w.file, w.size = f, 0
+	w.segment++
+	return nil
+}
+
+// sync flushes the active segment if anything was written since the last
+// flush.
+func (w *wal) sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.syncLocked()
+}
+
+func (w *wal) syncLocked() error {
+	if !w.dirty {
+		return nil
+	}
+	if err := w.file.Sync(); err != nil {
+		return fmt.Errorf("wal: fsync: %w", err)
+	}
+	w.dirty = false
+	return nil
+}
+
+// writeSnapshot atomically replaces the snapshot file and then deletes the
+// segments it makes redundant.
+func (w *wal) writeSnapshot(snap walSnapshot) error {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return fmt.Errorf("wal: encode snapshot: %w", err)
+	}
+
+	tmp := filepath.Join(w.cfg.Dir, snapshotName+".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("wal: create snapshot: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("wal: write snapshot: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("wal: fsync snapshot: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("wal: close snapshot: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(w.cfg.Dir, snapshotName)); err != nil {
+		return fmt.Errorf("wal: install snapshot: %w", err)
+	}
+	if dir, err := os.Open(w.cfg.Dir); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+
+	segs, err := listSegments(w.cfg.Dir)
+	if err != nil {
+		return fmt.Errorf("wal: list segments: %w", err)
+	}
+	for _, n := range segs {
+		if n < snap.NextSegment {
+			os.Remove(w.segmentPath(n))
+		}
+	}
+	return nil
+}
+
+// close flushes and closes the active segment.
+func (w *wal) close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.syncLocked(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
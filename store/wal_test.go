@@ -0,0 +1,122 @@
+package store_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jaggu/vehicle-tracker-prototype/model"
+	"github.com/jaggu/vehicle-tracker-prototype/store"
+)
+
+// latitudes maps vehicle_id to latitude for quick assertions.
+func latitudes(s store.Store) map[string]float64 {
+	out := make(map[string]float64)
+	for _, loc := range s.GetAllLocations() {
+		out[loc.VehicleID] = loc.Latitude
+	}
+	return out
+}
+
+func TestWAL_ReplayAfterCrash(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := store.OpenWAL(store.WALConfig{Dir: dir})
+	if err != nil {
+		t.Fatalf("OpenWAL: %v", err)
+	}
+	s.UpdateLocation(model.Location{VehicleID: "bus-1", Latitude: 17.0, Longitude: 78.0})
+	s.UpdateLocation(model.Location{VehicleID: "bus-2", Latitude: 17.5, Longitude: 78.5})
+	s.UpdateLocation(model.Location{VehicleID: "bus-1", Latitude: 18.0, Longitude: 79.0})
+
+	// Simulate a crash: no Close, so there is no snapshot, only the log.
+	recovered, err := store.OpenWAL(store.WALConfig{Dir: dir})
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer recovered.Close()
+
+	got := latitudes(recovered)
+	if len(got) != 2 || got["bus-1"] != 18.0 || got["bus-2"] != 17.5 {
+		t.Errorf("recovered state = %v, want bus-1=18 bus-2=17.5", got)
+	}
+}
+
+func TestWAL_SkipsTornTail(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := store.OpenWAL(store.WALConfig{Dir: dir})
+	if err != nil {
+		t.Fatalf("OpenWAL: %v", err)
+	}
+	s.UpdateLocation(model.Location{VehicleID: "bus-1", Latitude: 17.0, Longitude: 78.0})
+
+	// Append half a record header, as a crash mid-write would leave.
+	segs, _ := filepath.Glob(filepath.Join(dir, "wal-*.log"))
+	if len(segs) != 1 {
+		t.Fatalf("expected 1 segment, got %d", len(segs))
+	}
+	f, err := os.OpenFile(segs[0], os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0x20, 0x00, 0x00})
+	f.Close()
+
+	recovered, err := store.OpenWAL(store.WALConfig{Dir: dir})
+	if err != nil {
+		t.Fatalf("reopen with torn tail: %v", err)
+	}
+	if got := latitudes(recovered); got["bus-1"] != 17.0 {
+		t.Errorf("bus-1 latitude = %v, want 17.0", got["bus-1"])
+	}
+
+	// Records appended after recovery must follow the truncated tail.
+	recovered.UpdateLocation(model.Location{VehicleID: "bus-2", Latitude: 17.5, Longitude: 78.5})
+	again, err := store.OpenWAL(store.WALConfig{Dir: dir})
+	if err != nil {
+		t.Fatalf("second reopen: %v", err)
+	}
+	defer again.Close()
+	if got := again.TotalVehicleCount(); got != 2 {
+		t.Errorf("vehicle count after second reopen = %d, want 2", got)
+	}
+}
+
+func TestWAL_SnapshotAndRotation(t *testing.T) {
+	dir := t.TempDir()
+	cfg := store.WALConfig{Dir: dir, Sync: store.SyncNever, MaxSegmentBytes: 256}
+
+	s, err := store.OpenWAL(cfg)
+	if err != nil {
+		t.Fatalf("OpenWAL: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		s.UpdateLocation(model.Location{VehicleID: "bus-1", Latitude: float64(i), Longitude: 78.0})
+	}
+	segs, _ := filepath.Glob(filepath.Join(dir, "wal-*.log"))
+	if len(segs) < 2 {
+		t.Errorf("expected the log to rotate into several segments, got %d", len(segs))
+	}
+
+	// Close writes a snapshot and drops the segments it covers.
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "snapshot.json")); err != nil {
+		t.Fatalf("snapshot not written: %v", err)
+	}
+	segs, _ = filepath.Glob(filepath.Join(dir, "wal-*.log"))
+	if len(segs) != 1 {
+		t.Errorf("expected only the active segment after snapshot, got %d", len(segs))
+	}
+
+	reopened, err := store.OpenWAL(cfg)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer reopened.Close()
+	if got := latitudes(reopened)["bus-1"]; got != 19 {
+		t.Errorf("bus-1 latitude after snapshot restore = %v, want 19", got)
+	}
+}
@@ -0,0 +1,299 @@
+// Package trips tracks which GTFS trip and route each vehicle is running.
+//
+// A driver starts a trip when they set off and ends it at the terminus.
+// In between, every location report from the vehicle inherits the trip,
+// so a driver who doesn't resend the trip with each report still shows
+// up on the right trip in the GTFS-RT feed.
+//
+// Active trips are kept in memory and, when a path is given, written to
+// a JSON file after every change so that a restart doesn't drop them.
+//
+// A trip ends when the driver ends it, when the driver who started it
+// logs out or is deactivated (see EndDriver), or when the vehicle stops
+// reporting for the idle timeout, so a forgotten trip doesn't stay on
+// the feed.
+package trips
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jaggu/vehicle-tracker-prototype/clock"
+	"github.com/jaggu/vehicle-tracker-prototype/jsonfile"
+	"github.com/jaggu/vehicle-tracker-prototype/model"
+)
+
+// DefaultIdleTimeout ends trips whose vehicle has not reported for half
+// an hour.
+const DefaultIdleTimeout = 30 * time.Minute
+
+// janitorInterval is how often idle trips are looked for.
+const janitorInterval = time.Minute
+
+// Errors returned by Manager.
+var (
+	ErrNoActiveTrip = errors.New("vehicle has no active trip")
+	ErrInvalid      = errors.New("invalid trip")
+)
+
+// Trip binds a vehicle to a GTFS trip and route for a session.
+type Trip struct {
+	VehicleID string     `json:"vehicle_id"`
+	TripID    string     `json:"trip_id,omitempty"`
+	RouteID   string     `json:"route_id,omitempty"`
+	DriverID  string     `json:"driver_id,omitempty"`
+	StartedAt time.Time  `json:"started_at"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
+}
+
+// Manager holds the active trip of each vehicle.  It is safe for
+// concurrent use.
+type Manager struct {
+	path  string
+	clock clock.Clock
+	idle  time.Duration
+
+	mu     sync.RWMutex
+	active map[string]Trip
+
+	// lastSeen is when each vehicle with an active trip last reported,
+	// or its trip started or was loaded.  It is kept in memory only.
+	lastSeen map[string]time.Time
+
+	stop      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// Open returns a Manager that keeps active trips in the JSON file at
+// path, loading the trips already there.  A missing file means no active
+// trips; an empty path keeps them in memory only.
+//
+// Trips whose vehicle hasn't reported for idle are ended by a background
+// janitor; zero disables that.  Call Close to stop it.
+func Open(path string, clk clock.Clock, idle time.Duration) (*Manager, error) {
+	m := &Manager{
+		path:     path,
+		clock:    clk,
+		idle:     idle,
+		active:   make(map[string]Trip),
+		lastSeen: make(map[string]time.Time),
+		stop:     make(chan struct{}),
+	}
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+	if idle > 0 {
+		ticker := clk.NewTicker(janitorInterval)
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C():
+					m.EndIdle() //nolint: errcheck
+				case <-m.stop:
+					return
+				}
+			}
+		}()
+	}
+	return m, nil
+}
+
+// Close stops the janitor.  It is safe to call more than once.
+func (m *Manager) Close() {
+	m.closeOnce.Do(func() {
+		close(m.stop)
+		m.wg.Wait()
+	})
+}
+
+// load reads the trip file.  Loaded trips count as seen now, so their
+// vehicles get the idle timeout to report after a restart.
+func (m *Manager) load() error {
+	if m.path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(m.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var list []Trip
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("parse %s: %w", m.path, err)
+	}
+	now := m.clock.Now()
+	for _, t := range list {
+		m.active[t.VehicleID] = t
+		m.lastSeen[t.VehicleID] = now
+	}
+	return nil
+}
+
+// Start makes t the vehicle's active trip, ending any trip it was
+// already running.  StartedAt is set to the current time.  It returns
+// the started trip.
+func (m *Manager) Start(t Trip) (Trip, error) {
+	if t.VehicleID == "" {
+		return Trip{}, fmt.Errorf("%w: vehicle_id is required", ErrInvalid)
+	}
+	if t.TripID == "" && t.RouteID == "" {
+		return Trip{}, fmt.Errorf("%w: trip_id or route_id is required", ErrInvalid)
+	}
+	t.StartedAt = m.clock.Now().UTC()
+	t.EndedAt = nil
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	old, had := m.active[t.VehicleID]
+	m.active[t.VehicleID] = t
+	if err := m.saveLocked(); err != nil {
+		if had {
+			m.active[t.VehicleID] = old
+		} else {
+			delete(m.active, t.VehicleID)
+		}
+		return Trip{}, err
+	}
+	m.lastSeen[t.VehicleID] = t.StartedAt
+	return t, nil
+}
+
+// End ends the vehicle's active trip and returns it.
+func (m *Manager) End(vehicleID string) (Trip, error) {
+	ended, err := m.EndWhere(func(t Trip) bool { return t.VehicleID == vehicleID })
+	if err != nil {
+		return Trip{}, err
+	}
+	if len(ended) == 0 {
+		return Trip{}, ErrNoActiveTrip
+	}
+	return ended[0], nil
+}
+
+// EndDriver ends the trips the driver started, as when they log out or
+// are deactivated, and returns them.
+func (m *Manager) EndDriver(driverID string) ([]Trip, error) {
+	if driverID == "" {
+		return nil, nil
+	}
+	return m.EndWhere(func(t Trip) bool { return t.DriverID == driverID })
+}
+
+// EndIdle ends the trips whose vehicle hasn't reported for the idle
+// timeout and returns them.  The janitor calls it once a minute; it is
+// exported so tests can force a pass.
+func (m *Manager) EndIdle() ([]Trip, error) {
+	if m.idle <= 0 {
+		return nil, nil
+	}
+	cutoff := m.clock.Now().Add(-m.idle)
+	return m.EndWhere(func(t Trip) bool { return !m.lastSeen[t.VehicleID].After(cutoff) })
+}
+
+// Seen records a report from the vehicle, keeping its active trip from
+// going idle.
+func (m *Manager) Seen(vehicleID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.active[vehicleID]; ok {
+		m.lastSeen[vehicleID] = m.clock.Now()
+	}
+}
+
+// EndWhere ends the active trips matching end and returns them, ordered
+// by vehicle ID.  end is called with the Manager locked and must not
+// call its methods.
+func (m *Manager) EndWhere(end func(Trip) bool) ([]Trip, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var ended []Trip
+	for _, t := range m.listLocked() {
+		if end(t) {
+			ended = append(ended, t)
+			delete(m.active, t.VehicleID)
+		}
+	}
+	if len(ended) == 0 {
+		return nil, nil
+	}
+	if err := m.saveLocked(); err != nil {
+		for _, t := range ended {
+			m.active[t.VehicleID] = t
+		}
+		return nil, err
+	}
+	now := m.clock.Now().UTC()
+	for i, t := range ended {
+		delete(m.lastSeen, t.VehicleID)
+		ended[i].EndedAt = &now
+	}
+	return ended, nil
+}
+
+// Active returns the vehicle's active trip.
+func (m *Manager) Active(vehicleID string) (Trip, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	t, ok := m.active[vehicleID]
+	return t, ok
+}
+
+// Inherit fills in the trip and route of a live report that doesn't carry
+// them from the vehicle's active trip.  Backfill points and reports with
+// a fix time before the trip started are returned unchanged, as they
+// belong to whatever the vehicle was doing before.
+func (m *Manager) Inherit(loc model.Location) model.Location {
+	if loc.Backfill || (loc.TripID != "" && loc.RouteID != "") {
+		return loc
+	}
+	t, ok := m.Active(loc.VehicleID)
+	if !ok || (loc.Timestamp > 0 && loc.Timestamp < t.StartedAt.Unix()) {
+		return loc
+	}
+	if loc.TripID == "" && loc.RouteID == "" {
+		loc.TripID, loc.RouteID = t.TripID, t.RouteID
+	} else if loc.TripID == t.TripID && loc.RouteID == "" {
+		loc.RouteID = t.RouteID
+	}
+	return loc
+}
+
+// List returns all active trips, ordered by vehicle ID.
+func (m *Manager) List() []Trip {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.listLocked()
+}
+
+func (m *Manager) listLocked() []Trip {
+	list := make([]Trip, 0, len(m.active))
+	for _, t := range m.active {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].VehicleID < list[j].VehicleID })
+	return list
+}
+
+// saveLocked rewrites the trip file atomically.  m.mu must be held.
+func (m *Manager) saveLocked() error {
+	if m.path == "" {
+		return nil
+	}
+	if err := jsonfile.Write(m.path, m.listLocked()); err != nil {
+		return fmt.Errorf("trips: %w", err)
+	}
+	return nil
+}
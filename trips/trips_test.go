@@ -0,0 +1,93 @@
+package trips_test
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jaggu/vehicle-tracker-prototype/clock"
+	"github.com/jaggu/vehicle-tracker-prototype/model"
+	"github.com/jaggu/vehicle-tracker-prototype/trips"
+)
+
+func TestManager_StartInheritEnd(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trips.json")
+	clk := clock.NewFake(time.Unix(1752566400, 0))
+	m, err := trips.Open(path, clk, 0)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	if _, err := m.Start(trips.Trip{VehicleID: "bus-42"}); !errors.Is(err, trips.ErrInvalid) {
+		t.Errorf("start without trip or route: err = %v, want ErrInvalid", err)
+	}
+	if _, err := m.Start(trips.Trip{VehicleID: "bus-42", TripID: "5_0830", RouteID: "5"}); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	// Active trips survive a restart.
+	if m, err = trips.Open(path, clk, 0); err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+
+	live := m.Inherit(model.Location{VehicleID: "bus-42", Timestamp: 1752566410})
+	if live.TripID != "5_0830" || live.RouteID != "5" {
+		t.Errorf("live report inherited %q/%q, want 5_0830/5", live.TripID, live.RouteID)
+	}
+	before := m.Inherit(model.Location{VehicleID: "bus-42", Timestamp: 1752566000})
+	if before.TripID != "" {
+		t.Errorf("report from before the trip inherited %q", before.TripID)
+	}
+	backfill := m.Inherit(model.Location{VehicleID: "bus-42", Timestamp: 1752566410, Backfill: true})
+	if backfill.TripID != "" {
+		t.Errorf("backfill point inherited %q", backfill.TripID)
+	}
+
+	ended, err := m.End("bus-42")
+	if err != nil || ended.EndedAt == nil {
+		t.Fatalf("End = %+v, %v", ended, err)
+	}
+	if _, err := m.End("bus-42"); !errors.Is(err, trips.ErrNoActiveTrip) {
+		t.Errorf("second End: err = %v, want ErrNoActiveTrip", err)
+	}
+	if got := m.Inherit(model.Location{VehicleID: "bus-42"}); got.TripID != "" {
+		t.Errorf("report after the trip ended inherited %q", got.TripID)
+	}
+}
+
+func TestManager_EndIdleAndEndDriver(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1752566400, 0))
+	m, err := trips.Open("", clk, 10*time.Minute)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer m.Close()
+
+	m.Start(trips.Trip{VehicleID: "bus-1", RouteID: "5", DriverID: "drv-1"})
+	m.Start(trips.Trip{VehicleID: "bus-2", RouteID: "5", DriverID: "drv-2"})
+	m.Start(trips.Trip{VehicleID: "bus-3", RouteID: "5", DriverID: "drv-2"})
+
+	// bus-2 keeps reporting; bus-1 and bus-3 go quiet.
+	clk.Advance(6 * time.Minute)
+	m.Seen("bus-2")
+	clk.Advance(5 * time.Minute)
+	ended, err := m.EndIdle()
+	if err != nil {
+		t.Fatalf("EndIdle: %v", err)
+	}
+	if len(ended) != 2 || ended[0].VehicleID != "bus-1" || ended[1].VehicleID != "bus-3" || ended[0].EndedAt == nil {
+		t.Fatalf("EndIdle ended %+v, want bus-1 and bus-3", ended)
+	}
+	if _, ok := m.Active("bus-2"); !ok {
+		t.Fatal("bus-2's trip ended while it was reporting")
+	}
+
+	ended, err = m.EndDriver("drv-2")
+	if err != nil || len(ended) != 1 || ended[0].VehicleID != "bus-2" {
+		t.Fatalf("EndDriver = %+v, %v, want bus-2's trip", ended, err)
+	}
+	if got := m.List(); len(got) != 0 {
+		t.Errorf("active trips = %+v, want none", got)
+	}
+}